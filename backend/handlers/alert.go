@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Limits of the alert rules and the alert listing
+const (
+	alertRuleMaxName        = 100
+	alertRuleMaxMinDuration = 24 * 60 * 60 // seconds
+	alertListDefaultLimit   = 100
+	alertListMaxLimit       = 1000
+)
+
+// alertRuleRequest is the JSON body of the alert rule create and update requests
+type alertRuleRequest struct {
+	Name        string   `json:"name"`
+	DeviceID    string   `json:"deviceId"`
+	Expression  string   `json:"expression"`
+	Hysteresis  float64  `json:"hysteresis"`
+	MinDuration int      `json:"minDuration"` // seconds
+	Channels    []string `json:"channels"`
+	Enabled     *bool    `json:"enabled"` // defaults to true
+}
+
+// GetAlertRuleList returns the alert rules of the authenticated user ordered by name,
+// with the notification channels the rules can send their alerts to.
+func GetAlertRuleList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	rules, err := models.AlertRuleListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"alertRules": rules,
+		"channels":   alertEngine.Channels(),
+	})
+}
+
+// CreateAlertRule creates an alert rule of the authenticated user from the JSON body.
+// The rule applies to the uploads of the device given in deviceId, or of all devices of the user if it is empty.
+func CreateAlertRule(c *gin.Context) {
+	var body alertRuleRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	rule := models.AlertRule{UserID: user.ID}
+	if !applyAlertRuleRequest(c, &rule, body) {
+		return
+	}
+
+	if err := models.AlertRuleCreate(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	alertEngine.Invalidate(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"alertRule": rule,
+	})
+}
+
+// UpdateAlertRule replaces the settings of the alert rule identified by the ID in the request URL with the JSON body.
+// The alerts active in the running sessions are re-evaluated with the new settings.
+func UpdateAlertRule(c *gin.Context) {
+	var body alertRuleRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	rule, ok := getAlertRule(c, user.ID)
+	if !ok {
+		return
+	}
+	if !applyAlertRuleRequest(c, &rule, body) {
+		return
+	}
+
+	if err := models.AlertRuleUpdate(&rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	alertEngine.Invalidate(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"alertRule": rule,
+	})
+}
+
+// DeleteAlertRule deletes the alert rule identified by the ID in the request URL.
+// The alerts it triggered are kept, the active ones are cleared.
+func DeleteAlertRule(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	rule, ok := getAlertRule(c, user.ID)
+	if !ok {
+		return
+	}
+
+	if err := rule.Delete(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	alertEngine.Invalidate(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted successfully"})
+}
+
+// GetAlertList returns the alerts triggered by the rules of the authenticated user, most recent first.
+// The alerts can be filtered by the device, session, rule, from and to query parameters,
+// active=true lists only the alerts not cleared yet. At most limit alerts are returned, 100 by default.
+func GetAlertList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	filter := models.AlertEventFilter{
+		DeviceID:  c.Query("device"),
+		SessionID: c.Query("session"),
+		Active:    c.Query("active") == "true",
+	}
+	if !from.IsZero() {
+		filter.From = &from
+	}
+	if !to.IsZero() {
+		filter.To = &to
+	}
+	if value := c.Query("rule"); value != "" {
+		ruleID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule ID"})
+			return
+		}
+		filter.RuleID = uint(ruleID)
+	}
+
+	limit := alertListDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > alertListMaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit, 1 to %d expected", alertListMaxLimit)})
+			return
+		}
+	}
+
+	alerts, err := models.AlertEventListGetByUserID(user.ID, filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"alerts": alerts,
+	})
+}
+
+// GetSessionAlerts returns the alerts triggered during the session identified by the ID in the request URL, in chronological order.
+func GetSessionAlerts(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	alerts, err := models.AlertEventListGetByUserID(user.ID, models.AlertEventFilter{SessionID: session.SessionID}, alertListMaxLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	slices.Reverse(alerts)
+
+	c.JSON(http.StatusOK, gin.H{
+		"alerts": alerts,
+	})
+}
+
+// getAlertRule retrieves the alert rule of the user identified by the ID in the request URL,
+// responding with an error if the ID is invalid or the rule does not exist
+func getAlertRule(c *gin.Context, userID uint) (models.AlertRule, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert rule ID"})
+		return models.AlertRule{}, false
+	}
+
+	rule, err := models.AlertRuleGetByID(userID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return rule, false
+	}
+	return rule, true
+}
+
+// applyAlertRuleRequest validates the settings of an alert rule request and sets them on the rule,
+// responding with an error if they are invalid
+func applyAlertRuleRequest(c *gin.Context, rule *models.AlertRule, body alertRuleRequest) bool {
+	body.Name = strings.TrimSpace(body.Name)
+	body.Expression = strings.TrimSpace(body.Expression)
+
+	if body.Name == "" || len(body.Name) > alertRuleMaxName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name is required, at most %d characters", alertRuleMaxName)})
+		return false
+	}
+	if _, err := models.ParseAlertExpression(body.Expression); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expression: " + err.Error()})
+		return false
+	}
+	if body.Hysteresis < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "hysteresis must not be negative"})
+		return false
+	}
+	if body.MinDuration < 0 || body.MinDuration > alertRuleMaxMinDuration {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid minDuration, 0 to %d seconds expected", alertRuleMaxMinDuration)})
+		return false
+	}
+	channels := []string{}
+	for _, channel := range body.Channels {
+		if !alertEngine.HasChannel(channel) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown notification channel %q", channel)})
+			return false
+		}
+		if !slices.Contains(channels, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	if body.DeviceID != "" {
+		if _, err := models.DeviceGetByDeviceID(rule.UserID, body.DeviceID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+			return false
+		}
+	}
+
+	rule.Name = body.Name
+	rule.DeviceID = body.DeviceID
+	rule.Expression = body.Expression
+	rule.Hysteresis = body.Hysteresis
+	rule.MinDuration = body.MinDuration
+	rule.Channels = channels
+	rule.IsEnabled = body.Enabled == nil || *body.Enabled
+	return true
+}
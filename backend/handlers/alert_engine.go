@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/aafeher/gorque/models"
+	"gorm.io/gorm"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+)
+
+// alertNotifyTimeout limits how long a notification channel may take to deliver an alert
+const alertNotifyTimeout = 30 * time.Second
+
+// AlertNotification is an alert passed to the notification channels when it is triggered or cleared
+type AlertNotification struct {
+	Rule    models.AlertRule
+	Event   models.AlertEvent
+	Cleared bool
+}
+
+// AlertNotifier delivers alert notifications to a channel, e.g. the log or a messaging service
+type AlertNotifier interface {
+	Notify(ctx context.Context, notification AlertNotification) error
+}
+
+// logAlertNotifier writes the alerts to the server log
+type logAlertNotifier struct{}
+
+// Notify writes the alert to the server log
+func (logAlertNotifier) Notify(_ context.Context, notification AlertNotification) error {
+	event := notification.Event
+	if notification.Cleared {
+		log.Printf("Alert %q cleared for device %s in session %s", event.RuleName, event.DeviceID, event.SessionID)
+		return nil
+	}
+	log.Printf("Alert %q triggered for device %s in session %s: %s %v", event.RuleName, event.DeviceID, event.SessionID, event.Expression, event.Values)
+	return nil
+}
+
+// alertRule is an enabled alert rule with its parsed expression
+type alertRule struct {
+	models.AlertRule
+	expression *models.AlertExpression
+}
+
+// alertStateKey identifies the state of a rule in a session
+type alertStateKey struct {
+	ruleID    uint
+	sessionID string
+}
+
+// alertState tracks a rule in a session between the samples
+type alertState struct {
+	lastTime time.Time          // time of the latest sample evaluated
+	since    time.Time          // start of the condition holding, zero if it does not hold
+	event    *models.AlertEvent // the active alert, nil if none
+}
+
+// AlertEngine evaluates the alert rules of the users on the uploaded samples.
+// It stores the triggered alerts and passes them to the notification channels of the rules in the background.
+type AlertEngine struct {
+	mutex     sync.Mutex
+	rules     map[uint][]alertRule // enabled rules keyed by user ID, loaded on the first sample of the user
+	states    map[alertStateKey]*alertState
+	notifiers map[string]AlertNotifier // keyed by channel name
+	webhooks  *WebhookDispatcher
+	pending   sync.WaitGroup // notifications being delivered
+}
+
+// NewAlertEngine creates a new alert engine notifying the log channel and publishing the alerts to the webhooks
+func NewAlertEngine(webhooks *WebhookDispatcher) *AlertEngine {
+	return &AlertEngine{
+		rules:     make(map[uint][]alertRule),
+		states:    make(map[alertStateKey]*alertState),
+		notifiers: map[string]AlertNotifier{"log": logAlertNotifier{}},
+		webhooks:  webhooks,
+	}
+}
+
+// RegisterNotifier adds a notification channel the rules can send their alerts to
+func (ae *AlertEngine) RegisterNotifier(channel string, notifier AlertNotifier) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	ae.notifiers[channel] = notifier
+}
+
+// HasChannel reports whether a notification channel is registered
+func (ae *AlertEngine) HasChannel(channel string) bool {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	_, exists := ae.notifiers[channel]
+	return exists
+}
+
+// Channels returns the names of the registered notification channels in alphabetical order
+func (ae *AlertEngine) Channels() []string {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	return slices.Sorted(maps.Keys(ae.notifiers))
+}
+
+// Invalidate drops the rules of the user and their state, e.g. after a rule changed.
+// They are reloaded with the next sample of the user, the active alerts are restored from the database.
+func (ae *AlertEngine) Invalidate(userID uint) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	for _, rule := range ae.rules[userID] {
+		for key := range ae.states {
+			if key.ruleID == rule.ID {
+				delete(ae.states, key)
+			}
+		}
+	}
+	delete(ae.rules, userID)
+}
+
+// Evaluate evaluates the rules of the user on a sample, triggering and clearing their alerts.
+// Samples older than the previous one of the session are skipped.
+func (ae *AlertEngine) Evaluate(sample uploadSample) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	rules, err := ae.userRules(sample.User.ID)
+	if err != nil {
+		log.Printf("Alert rule lookup error: %v", err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if rule.DeviceID != "" && rule.DeviceID != sample.DeviceID {
+			continue
+		}
+
+		state, err := ae.state(rule, sample.SessionID)
+		if err != nil {
+			log.Printf("Alert state lookup error for rule %d: %v", rule.ID, err)
+			continue
+		}
+		if sample.Time.Before(state.lastTime) {
+			continue
+		}
+		state.lastTime = sample.Time
+
+		holds, known := rule.expression.Evaluate(sample.Fields, rule.Hysteresis, state.event != nil)
+		if !known {
+			continue
+		}
+
+		switch {
+		case holds && state.event == nil:
+			if state.since.IsZero() {
+				state.since = sample.Time
+			}
+			if sample.Time.Sub(state.since) >= time.Duration(rule.MinDuration)*time.Second {
+				ae.trigger(rule, state, sample)
+			}
+		case !holds:
+			state.since = time.Time{}
+			if state.event != nil {
+				ae.clear(rule, state, sample.Time)
+			}
+		}
+	}
+}
+
+// CloseSession clears the alerts still active in the session at its end and drops the state of its rules
+func (ae *AlertEngine) CloseSession(sessionID string, endTime time.Time) {
+	ae.mutex.Lock()
+	defer ae.mutex.Unlock()
+
+	for key := range ae.states {
+		if key.sessionID == sessionID {
+			delete(ae.states, key)
+		}
+	}
+	if err := models.AlertEventClearBySessionID(sessionID, endTime); err != nil {
+		log.Printf("Alert clear error for session %s: %v", sessionID, err)
+	}
+}
+
+// Close waits for the notifications being delivered
+func (ae *AlertEngine) Close() {
+	ae.pending.Wait()
+}
+
+// userRules returns the enabled rules of the user, loading them on first use
+func (ae *AlertEngine) userRules(userID uint) ([]alertRule, error) {
+	if rules, exists := ae.rules[userID]; exists {
+		return rules, nil
+	}
+
+	records, err := models.AlertRuleListGetEnabled(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]alertRule, 0, len(records))
+	for _, record := range records {
+		expression, err := models.ParseAlertExpression(record.Expression)
+		if err != nil {
+			log.Printf("Alert rule %d skipped, invalid expression: %v", record.ID, err)
+			continue
+		}
+		rules = append(rules, alertRule{AlertRule: record, expression: expression})
+	}
+	ae.rules[userID] = rules
+	return rules, nil
+}
+
+// state returns the state of the rule in the session, restoring the active alert from the database on first use
+func (ae *AlertEngine) state(rule *alertRule, sessionID string) (*alertState, error) {
+	key := alertStateKey{ruleID: rule.ID, sessionID: sessionID}
+	if state, exists := ae.states[key]; exists {
+		return state, nil
+	}
+
+	state := &alertState{}
+	event, err := models.AlertEventGetActive(rule.ID, sessionID)
+	if err == nil {
+		state.event = &event
+		state.lastTime = event.Time
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	ae.states[key] = state
+	return state, nil
+}
+
+// trigger stores a new alert of the rule, notifies its channels and publishes it to the webhooks
+func (ae *AlertEngine) trigger(rule *alertRule, state *alertState, sample uploadSample) {
+	values := make(map[string]float64)
+	for _, key := range rule.expression.Fields() {
+		if value, ok := sample.Fields[key].(float64); ok {
+			values[string(models.UserDataCodeFromFieldKey(key))] = value
+		}
+	}
+
+	event := models.AlertEvent{
+		RuleID:         rule.ID,
+		UserID:         rule.UserID,
+		DeviceID:       sample.DeviceID,
+		SessionID:      sample.SessionID,
+		RuleName:       rule.Name,
+		Expression:     rule.Expression,
+		Values:         values,
+		ConditionSince: state.since,
+		Time:           sample.Time,
+	}
+	if err := models.AlertEventCreate(&event); err != nil {
+		log.Printf("Alert creation error for rule %d: %v", rule.ID, err)
+		return
+	}
+	state.event = &event
+
+	ae.notify(rule, AlertNotification{Rule: rule.AlertRule, Event: event})
+	ae.webhooks.AlertTriggered(event)
+}
+
+// clear marks the active alert of the rule cleared and notifies its channels
+func (ae *AlertEngine) clear(rule *alertRule, state *alertState, clearedAt time.Time) {
+	event := *state.event
+	if err := models.AlertEventClear(event.ID, clearedAt); err != nil {
+		log.Printf("Alert clear error for rule %d: %v", rule.ID, err)
+		return
+	}
+	event.ClearedAt = &clearedAt
+	state.event = nil
+
+	ae.notify(rule, AlertNotification{Rule: rule.AlertRule, Event: event, Cleared: true})
+}
+
+// notify passes the notification to the channels of the rule in the background, the upload does not wait for them
+func (ae *AlertEngine) notify(rule *alertRule, notification AlertNotification) {
+	for _, channel := range rule.Channels {
+		notifier, exists := ae.notifiers[channel]
+		if !exists {
+			log.Printf("Alert channel %q of rule %d is not available", channel, rule.ID)
+			continue
+		}
+
+		ae.pending.Add(1)
+		go func() {
+			defer ae.pending.Done()
+
+			ctx, cancel := context.WithTimeout(context.Background(), alertNotifyTimeout)
+			defer cancel()
+			if err := notifier.Notify(ctx, notification); err != nil {
+				log.Printf("Alert notification error on channel %s: %v", channel, err)
+			}
+		}()
+	}
+}
@@ -78,7 +78,7 @@ func GetProfile(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"email": user.Email, "id": user.ID, "name": user.Name})
+	c.JSON(http.StatusOK, gin.H{"email": user.Email, "id": user.ID, "name": user.Name, "units": user.UnitPreferences()})
 }
 
 // UpdateProfileName updates the name of a user profile identified by the ID in the request URL.
@@ -111,6 +111,52 @@ func UpdateProfileName(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Name updated successfully"})
 }
 
+// GetProfileUnits returns the unit preferences of the currently authenticated user together with the selectable units
+// per quantity.
+func GetProfileUnits(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"units":   user.UnitPreferences(),
+		"systems": []string{models.UnitSystemMetric, models.UnitSystemImperial},
+		"options": models.UnitOptions(),
+	})
+}
+
+// UpdateProfileUnits updates the unit preferences of the currently authenticated user.
+// It expects a JSON body with the unit system (metric or imperial) and optionally units overriding it per quantity.
+func UpdateProfileUnits(c *gin.Context) {
+	var body models.UnitPreferences
+
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	for quantity, unit := range body.Units {
+		body.Units[quantity] = models.CanonicalUnit(unit)
+	}
+	if err := body.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	if err := user.UpdateUnitPreferences(body); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Units updated successfully"})
+}
+
 // UpdateProfilePassword updates the password of a user based on the provided current and new password.
 // It validates the current password, hashes the new password, and updates it in the database.
 func UpdateProfilePassword(c *gin.Context) {
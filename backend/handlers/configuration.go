@@ -1,33 +1,37 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/aafeher/gorque/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
 )
 
-func GetConfiguration(c *gin.Context) {
-	_, ok := GetUserFromContext(c)
-	if !ok {
-		return
-	}
+// ChartVariable is a field plotted on a chart
+type ChartVariable struct {
+	Key   models.UserDataCode `json:"key"`
+	Field string              `json:"field"` // key of the stored field, as requested from the data endpoint
+	Name  string              `json:"name"`
+	Unit  string              `json:"unit"`
+}
 
-	type ChartVariable struct {
-		Key  models.UserDataCode `json:"key"`
-		Name string              `json:"name"`
-		Unit string              `json:"unit"`
-	}
-	type ConfigurationChart struct {
-		ID         int64           `json:"id"`
-		Title      string          `json:"title"`
-		Type       string          `json:"type"`
-		YAxisTitle string          `json:"yAxisTitle"`
-		Variables  []ChartVariable `json:"variables"`
-	}
-	type Configuration struct {
-		Charts []ConfigurationChart `json:"charts"`
-	}
+// ConfigurationChart describes a chart of the session view
+type ConfigurationChart struct {
+	ID         int64           `json:"id"`
+	Title      string          `json:"title"`
+	Type       string          `json:"type"`
+	YAxisTitle string          `json:"yAxisTitle"`
+	Variables  []ChartVariable `json:"variables"`
+}
+
+// Configuration is the chart setup of the session view
+type Configuration struct {
+	Charts []ConfigurationChart `json:"charts"`
+}
 
+// defaultConfiguration returns the charts seeded as the default dashboard of every user
+func defaultConfiguration() Configuration {
 	configuration := Configuration{
 		Charts: []ConfigurationChart{
 			{
@@ -79,11 +83,6 @@ func GetConfiguration(c *gin.Context) {
 						Name: models.UserDataItems[models.UserDataCodeK43].FullName,
 						Unit: models.UserDataItems[models.UserDataCodeK43].Unit,
 					},
-					//{
-					//	Key:  "kc",
-					//	Name: "Engine RPM",
-					//	Unit: "rpm",
-					//},
 					{
 						Key:  models.UserDataCodeKFF1225,
 						Name: models.UserDataItems[models.UserDataCodeKFF1225].FullName,
@@ -142,7 +141,80 @@ func GetConfiguration(c *gin.Context) {
 		},
 	}
 
+	for i := range configuration.Charts {
+		for j := range configuration.Charts[i].Variables {
+			variable := &configuration.Charts[i].Variables[j]
+			variable.Field = variable.Key.FieldKey()
+		}
+	}
+	return configuration
+}
+
+// configurationFromDashboard converts a dashboard to the chart setup of the session view
+func configurationFromDashboard(dashboard models.Dashboard) Configuration {
+	configuration := Configuration{Charts: make([]ConfigurationChart, 0, len(dashboard.Charts))}
+	for _, chart := range dashboard.Charts {
+		variables := make([]ChartVariable, 0, len(chart.Variables))
+		for _, variable := range chart.Variables {
+			variables = append(variables, ChartVariable{
+				Key:   variable.Key,
+				Field: variable.Key.FieldKey(),
+				Name:  variable.Name,
+				Unit:  variable.Unit,
+			})
+		}
+		configuration.Charts = append(configuration.Charts, ConfigurationChart{
+			ID:         int64(chart.ID),
+			Title:      chart.Title,
+			Type:       chart.Type,
+			YAxisTitle: chart.YAxisTitle,
+			Variables:  variables,
+		})
+	}
+	return configuration
+}
+
+// inUnits returns the configuration with the units of the variables converted to the preferred units,
+// which are the units the data endpoint returns the values in
+func (configuration Configuration) inUnits(preferences models.UnitPreferences) Configuration {
+	for i := range configuration.Charts {
+		for j := range configuration.Charts[i].Variables {
+			variable := &configuration.Charts[i].Variables[j]
+			variable.Unit = preferences.Conversion(variable.Unit).To
+		}
+	}
+	return configuration
+}
+
+// GetConfiguration returns the chart setup of the session view from the dashboard of the user.
+// The optional device-id query parameter selects the dashboard defined for the device, if there is one.
+// Users without any dashboard get the default one. Units are those of the user's unit preferences.
+func GetConfiguration(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	if err := ensureDefaultDashboard(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	dashboard, err := models.DashboardGetForDevice(user.ID, c.Query("device-id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Only device dashboards are left, the general one was deleted
+			c.JSON(http.StatusOK, gin.H{
+				"configuration": defaultConfiguration().inUnits(user.UnitPreferences()),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
-		"configuration": configuration,
+		"configuration": configurationFromDashboard(dashboard).inUnits(user.UnitPreferences()),
+		"dashboardId":   dashboard.ID,
 	})
 }
@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Limits of a dashboard definition
+const (
+	dashboardMaxCharts    = 20
+	dashboardMaxVariables = 20
+	dashboardMaxTitle     = 100
+)
+
+// dashboardChartTypes are the chart types supported by the session view
+var dashboardChartTypes = []string{"line", "area", "bar", "scatter"}
+
+var errDashboardDeviceNotFound = errors.New("device not found")
+
+type dashboardVariableRequest struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	Unit string `json:"unit"`
+}
+
+type dashboardChartRequest struct {
+	Title      string                     `json:"title"`
+	Type       string                     `json:"type"`
+	YAxisTitle string                     `json:"yAxisTitle"`
+	Variables  []dashboardVariableRequest `json:"variables"`
+}
+
+// dashboardRequest is the definition of a dashboard sent on create and update, charts are ordered as listed
+type dashboardRequest struct {
+	Name     string                  `json:"name"`
+	DeviceID string                  `json:"deviceId"`
+	Charts   []dashboardChartRequest `json:"charts"`
+}
+
+// GetDashboardList returns the dashboards of the authenticated user. Users without any get the default one.
+func GetDashboardList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	if err := ensureDefaultDashboard(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	dashboards, err := models.DashboardListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dashboards": dashboards,
+	})
+}
+
+// GetDashboard returns the dashboard identified by the ID in the request URL.
+func GetDashboard(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	dashboard, ok := dashboardFromParam(c, user.ID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dashboard": dashboard,
+	})
+}
+
+// CreateDashboard creates a dashboard for the authenticated user, for one of the user's devices or,
+// without a device ID, for all devices without their own dashboard.
+func CreateDashboard(c *gin.Context) {
+	var body dashboardRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	dashboard, ok := dashboardFromRequest(c, user.ID, 0, body)
+	if !ok {
+		return
+	}
+
+	if err := models.DashboardCreate(&dashboard); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dashboard": dashboard,
+	})
+}
+
+// UpdateDashboard replaces the definition of the dashboard identified by the ID in the request URL.
+func UpdateDashboard(c *gin.Context) {
+	var body dashboardRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	existing, ok := dashboardFromParam(c, user.ID)
+	if !ok {
+		return
+	}
+
+	dashboard, ok := dashboardFromRequest(c, user.ID, existing.ID, body)
+	if !ok {
+		return
+	}
+	dashboard.ID = existing.ID
+	dashboard.CreatedAt = existing.CreatedAt
+
+	if err := models.DashboardReplace(&dashboard); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	dashboard, err := models.DashboardGetByID(existing.ID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dashboard": dashboard,
+	})
+}
+
+// DeleteDashboard deletes the dashboard identified by the ID in the request URL.
+func DeleteDashboard(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dashboard ID"})
+		return
+	}
+
+	if err := models.DashboardDelete(uint(id), user.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dashboard not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Dashboard deleted successfully"})
+}
+
+// dashboardFromParam loads the dashboard identified by the ID in the request URL, responding with an error if it fails
+func dashboardFromParam(c *gin.Context, userID uint) (models.Dashboard, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dashboard ID"})
+		return models.Dashboard{}, false
+	}
+
+	dashboard, err := models.DashboardGetByID(uint(id), userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "dashboard not found"})
+			return models.Dashboard{}, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return models.Dashboard{}, false
+	}
+
+	return dashboard, true
+}
+
+// dashboardFromRequest validates a dashboard definition, responding with an error if it is invalid.
+// A device can only have one dashboard, dashboardID is the dashboard being updated, 0 on create.
+func dashboardFromRequest(c *gin.Context, userID uint, dashboardID uint, body dashboardRequest) (models.Dashboard, bool) {
+	dashboard, err := buildDashboard(userID, body)
+	if err != nil {
+		if errors.Is(err, errDashboardDeviceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return dashboard, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return dashboard, false
+	}
+
+	existing, err := models.DashboardGetByDeviceID(userID, dashboard.DeviceID)
+	if err == nil && existing.ID != dashboardID {
+		if dashboard.DeviceID == "" {
+			c.JSON(http.StatusConflict, gin.H{"error": "a dashboard for all devices already exists"})
+		} else {
+			c.JSON(http.StatusConflict, gin.H{"error": "a dashboard for this device already exists"})
+		}
+		return dashboard, false
+	}
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return dashboard, false
+	}
+
+	return dashboard, true
+}
+
+// buildDashboard validates a dashboard definition and converts it to a dashboard of the user.
+// Variable keys are accepted as codes (k0d) or as uploaded (kd); names and units default to the field's metadata.
+func buildDashboard(userID uint, body dashboardRequest) (models.Dashboard, error) {
+	dashboard := models.Dashboard{
+		UserID:   userID,
+		DeviceID: strings.TrimSpace(body.DeviceID),
+		Name:     strings.TrimSpace(body.Name),
+	}
+
+	if dashboard.Name == "" {
+		return dashboard, fmt.Errorf("name is required")
+	}
+	if len(dashboard.Name) > dashboardMaxTitle {
+		return dashboard, fmt.Errorf("name too long (max %d characters)", dashboardMaxTitle)
+	}
+
+	if dashboard.DeviceID != "" {
+		if _, err := models.DeviceGetByDeviceID(userID, dashboard.DeviceID); err != nil {
+			return dashboard, errDashboardDeviceNotFound
+		}
+	}
+
+	if len(body.Charts) == 0 {
+		return dashboard, fmt.Errorf("at least one chart is required")
+	}
+	if len(body.Charts) > dashboardMaxCharts {
+		return dashboard, fmt.Errorf("too many charts (max %d)", dashboardMaxCharts)
+	}
+
+	for i, chartBody := range body.Charts {
+		chart, err := buildDashboardChart(userID, chartBody)
+		if err != nil {
+			return dashboard, fmt.Errorf("chart %d: %w", i+1, err)
+		}
+		chart.Position = i
+		dashboard.Charts = append(dashboard.Charts, chart)
+	}
+
+	return dashboard, nil
+}
+
+// buildDashboardChart validates a chart definition and converts it to a dashboard chart
+func buildDashboardChart(userID uint, body dashboardChartRequest) (models.DashboardChart, error) {
+	chart := models.DashboardChart{
+		Title:      strings.TrimSpace(body.Title),
+		Type:       body.Type,
+		YAxisTitle: strings.TrimSpace(body.YAxisTitle),
+	}
+
+	if chart.Type == "" {
+		chart.Type = "line"
+	}
+	if !slices.Contains(dashboardChartTypes, chart.Type) {
+		return chart, fmt.Errorf("invalid chart type, %s expected", strings.Join(dashboardChartTypes, ", "))
+	}
+	if len(chart.Title) > dashboardMaxTitle || len(chart.YAxisTitle) > dashboardMaxTitle {
+		return chart, fmt.Errorf("title too long (max %d characters)", dashboardMaxTitle)
+	}
+
+	if len(body.Variables) == 0 {
+		return chart, fmt.Errorf("at least one variable is required")
+	}
+	if len(body.Variables) > dashboardMaxVariables {
+		return chart, fmt.Errorf("too many variables (max %d)", dashboardMaxVariables)
+	}
+
+	for i, variableBody := range body.Variables {
+		variable, err := buildDashboardChartVariable(userID, variableBody)
+		if err != nil {
+			return chart, err
+		}
+		for _, other := range chart.Variables {
+			if other.Key == variable.Key {
+				return chart, fmt.Errorf("duplicate variable %s", variable.Key)
+			}
+		}
+		variable.Position = i
+		chart.Variables = append(chart.Variables, variable)
+	}
+
+	return chart, nil
+}
+
+// buildDashboardChartVariable validates a variable against the built-in UserDataItems and, for custom PIDs,
+// the field definitions Torque uploaded in the sessions of the user
+func buildDashboardChartVariable(userID uint, body dashboardVariableRequest) (models.DashboardChartVariable, error) {
+	key := strings.ToLower(strings.TrimSpace(body.Key))
+	if !isDataFieldKey(key) {
+		return models.DashboardChartVariable{}, fmt.Errorf("invalid variable key %q", body.Key)
+	}
+
+	code := models.UserDataCodeFromFieldKey(key)
+	variable := models.DashboardChartVariable{
+		Key:  code,
+		Name: strings.TrimSpace(body.Name),
+		Unit: strings.TrimSpace(body.Unit),
+	}
+
+	name, unit := "", ""
+	if item, exists := models.UserDataItems[code]; exists {
+		name, unit = item.FullName, item.Unit
+	} else {
+		field, err := models.SessionFieldGetLatestByUserAndKey(userID, strings.TrimPrefix(string(code), "k"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return variable, fmt.Errorf("unknown variable %q", body.Key)
+			}
+			return variable, err
+		}
+		name, unit = field.FullName, field.DefaultUnit
+		if unit == "" {
+			unit = field.Unit
+		}
+		if name == "" {
+			name = string(code)
+		}
+	}
+
+	if variable.Name == "" {
+		variable.Name = name
+	}
+	if variable.Unit == "" {
+		variable.Unit = unit
+	}
+	if len(variable.Name) > dashboardMaxTitle || len(variable.Unit) > dashboardMaxTitle {
+		return variable, fmt.Errorf("variable %s: name too long (max %d characters)", code, dashboardMaxTitle)
+	}
+
+	return variable, nil
+}
+
+// ensureDefaultDashboard seeds the default dashboard for a user without any dashboard
+func ensureDefaultDashboard(userID uint) error {
+	count, err := models.DashboardCountByUserID(userID)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	dashboard := models.Dashboard{UserID: userID, Name: "Default"}
+	for i, chart := range defaultConfiguration().Charts {
+		dashboardChart := models.DashboardChart{
+			Position:   i,
+			Title:      chart.Title,
+			Type:       chart.Type,
+			YAxisTitle: chart.YAxisTitle,
+		}
+		for j, variable := range chart.Variables {
+			dashboardChart.Variables = append(dashboardChart.Variables, models.DashboardChartVariable{
+				Position: j,
+				Key:      variable.Key,
+				Name:     variable.Name,
+				Unit:     variable.Unit,
+			})
+		}
+		dashboard.Charts = append(dashboard.Charts, dashboardChart)
+	}
+
+	if err := models.DashboardCreate(&dashboard); err != nil {
+		// A concurrent request may have seeded it already
+		if count, countErr := models.DashboardCountByUserID(userID); countErr == nil && count > 0 {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
@@ -1,14 +1,22 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/aafeher/gorque/models"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // GetData retrieves time-series data for a specific user, device, and session within a defined time range.
-// It verifies the user, extracts query parameters, validates session existence, and queries the InfluxDB instance.
-// The response includes data, GPS coordinates, and the center point of the captured coordinates.
+// It verifies the user, extracts query parameters, validates session existence, and queries the time-series store.
+// The fields, the time window and the downsampling can be selected with the fields, start, stop, window,
+// max-points and method query parameters.
+// The response includes one series per field, converted to the user's preferred units, with the names and units
+// of the fields, GPS coordinates, and the center point of the captured coordinates.
+// Positions within the privacy zones of the user are dropped or snapped to the centre of the zone.
 func GetData(c *gin.Context) {
 	user, ok := GetUserFromContext(c)
 	if !ok {
@@ -33,15 +41,78 @@ func GetData(c *gin.Context) {
 		return
 	}
 
-	data, coords, center, err := session.GetSessionData()
+	options, err := parseSessionDataOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	options.Privacy, err = models.PrivacyZoneListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, err := session.GetSessionData(timeSeriesStore, options)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	catalog, err := models.FieldCatalogGetBySessionID(session.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields := data.ApplyUnits(catalog, user.UnitPreferences())
+
 	c.JSON(http.StatusOK, gin.H{
-		"data":   data,
-		"coords": coords,
-		"center": center,
+		"series": data.Series,
+		"fields": fields,
+		"coords": data.Coords,
+		"center": data.Center,
 	})
 }
+
+// parseSessionDataOptions reads the field selection and downsampling options from the query parameters.
+// fields is a comma separated list of field keys, start and stop are RFC3339 timestamps,
+// window is a duration such as 30s and method is either mean (default) or lttb.
+func parseSessionDataOptions(c *gin.Context) (models.SessionDataOptions, error) {
+	options := models.SessionDataOptions{
+		Method: c.DefaultQuery("method", models.SessionDataMethodMean),
+	}
+
+	for _, field := range strings.Split(c.Query("fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			options.Fields = append(options.Fields, field)
+		}
+	}
+
+	var err error
+	if start := c.Query("start"); start != "" {
+		if options.Start, err = time.Parse(time.RFC3339, start); err != nil {
+			return options, errors.New("invalid start time, RFC3339 expected")
+		}
+	}
+	if stop := c.Query("stop"); stop != "" {
+		if options.Stop, err = time.Parse(time.RFC3339, stop); err != nil {
+			return options, errors.New("invalid stop time, RFC3339 expected")
+		}
+	}
+	if window := c.Query("window"); window != "" {
+		if options.Every, err = time.ParseDuration(window); err != nil || options.Every <= 0 {
+			return options, errors.New("invalid window, a positive duration such as 30s expected")
+		}
+	}
+	if maxPoints := c.Query("max-points"); maxPoints != "" {
+		if options.MaxPoints, err = strconv.Atoi(maxPoints); err != nil || options.MaxPoints < 0 {
+			return options, errors.New("invalid max-points, a non-negative number expected")
+		}
+	}
+
+	if options.Method != models.SessionDataMethodMean && options.Method != models.SessionDataMethodLTTB {
+		return options, errors.New("invalid method, mean or lttb expected")
+	}
+
+	return options, nil
+}
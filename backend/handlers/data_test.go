@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// dataTestResponse is the response of GetData
+type dataTestResponse struct {
+	Series []struct {
+		Field  string    `json:"field"`
+		Times  []int64   `json:"times"`
+		Values []float64 `json:"values"`
+	} `json:"series"`
+	Coords [][]float64 `json:"coords"`
+	Center []float64   `json:"center"`
+	Error  string      `json:"error"`
+}
+
+func TestGetData(t *testing.T) {
+	r := newTestRouter()
+	owner := newTestUser(t)
+	other := newTestUser(t)
+	uploadTestSamples(t, r, owner, "data-dev", 1714568400000, 4)
+
+	session := url.Values{"device-id": {"data-dev"}, "session-id": {"1714568400000"}}
+	withQuery := func(extra url.Values) url.Values {
+		query := url.Values{}
+		for k, v := range session {
+			query[k] = v
+		}
+		for k, v := range extra {
+			query[k] = v
+		}
+		return query
+	}
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		token      string
+		wantStatus int
+		wantError  string
+		wantSeries map[string][]float64
+		wantCoords int
+	}{
+		{
+			name:       "all fields",
+			query:      session,
+			token:      owner.token,
+			wantStatus: http.StatusOK,
+			wantSeries: map[string][]float64{
+				"kd":      {0, 10, 20, 30},
+				"kff1005": {19.05, 19.05, 19.05, 19.05},
+				"kff1006": {47.5, 47.501, 47.502, 47.503},
+			},
+			wantCoords: 4,
+		},
+		{
+			name:       "selected fields",
+			query:      withQuery(url.Values{"fields": {"kd"}}),
+			token:      owner.token,
+			wantStatus: http.StatusOK,
+			wantSeries: map[string][]float64{"kd": {0, 10, 20, 30}},
+			wantCoords: 4,
+		},
+		{
+			name:       "time window",
+			query:      withQuery(url.Values{"fields": {"kd"}, "start": {"2024-05-01T13:00:01Z"}, "stop": {"2024-05-01T13:00:03Z"}}),
+			token:      owner.token,
+			wantStatus: http.StatusOK,
+			wantSeries: map[string][]float64{"kd": {10, 20}},
+			wantCoords: 2,
+		},
+		{
+			name:       "averaged",
+			query:      withQuery(url.Values{"fields": {"kd"}, "window": {"2s"}}),
+			token:      owner.token,
+			wantStatus: http.StatusOK,
+			wantSeries: map[string][]float64{"kd": {5, 25}},
+			wantCoords: 2,
+		},
+		{
+			name:       "missing token",
+			query:      session,
+			wantStatus: http.StatusUnauthorized,
+			wantError:  "missing Authorization header",
+		},
+		{
+			name:       "missing device",
+			query:      url.Values{"session-id": {"1714568400000"}},
+			token:      owner.token,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "device ID is required",
+		},
+		{
+			name:       "session of another user",
+			query:      session,
+			token:      other.token,
+			wantStatus: http.StatusNotFound,
+			wantError:  "record not found",
+		},
+		{
+			name:       "invalid window",
+			query:      withQuery(url.Values{"window": {"-1s"}}),
+			token:      owner.token,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "invalid window, a positive duration such as 30s expected",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveTest(r, "/api/data", test.query, test.token)
+			if recorder.Code != test.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, test.wantStatus, recorder.Body.String())
+			}
+
+			var response dataTestResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response %s: %v", recorder.Body.String(), err)
+			}
+			if response.Error != test.wantError {
+				t.Errorf("error = %q, want %q", response.Error, test.wantError)
+			}
+			if test.wantStatus != http.StatusOK {
+				return
+			}
+
+			series := make(map[string][]float64, len(response.Series))
+			for _, s := range response.Series {
+				series[s.Field] = s.Values
+			}
+			if !reflect.DeepEqual(series, test.wantSeries) {
+				t.Errorf("series = %v, want %v", series, test.wantSeries)
+			}
+			if len(response.Coords) != test.wantCoords {
+				t.Errorf("coords = %d, want %d", len(response.Coords), test.wantCoords)
+			}
+		})
+	}
+}
@@ -1,18 +1,36 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Limits of the device details
+const (
+	deviceMaxDisplayName = 100
+	deviceMaxIcon        = 32
+)
+
+// deviceColorPattern matches the hex colors of devices, e.g. #4f46e5
+var deviceColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
 // GetDeviceList retrieves the list of devices associated with the authenticated user and returns them in the response.
+// Archived devices are only listed if the include-archived query parameter is true.
 func GetDeviceList(c *gin.Context) {
 	user, ok := GetUserFromContext(c)
 	if !ok {
 		return
 	}
 
-	devices, err := user.GetDevices()
+	devices, err := user.GetDevices(c.Query("include-archived") == "true")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -22,3 +40,328 @@ func GetDeviceList(c *gin.Context) {
 		"devices": devices,
 	})
 }
+
+// GetDeviceDTCList returns the diagnostic trouble codes reported by the device identified by the ID in the request URL,
+// with the first and the last time they were seen, most recently seen first.
+func GetDeviceDTCList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	device, err := models.DeviceGetByDeviceID(user.ID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	dtcs, err := models.DeviceDTCListGetByDeviceID(user.ID, device.DeviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dtcs": dtcs,
+	})
+}
+
+// UpdateDevice updates the display name, the icon, the color and the archived mark of the device identified by the ID
+// in the request URL. Values missing from the JSON body are left unchanged, empty strings clear them.
+func UpdateDevice(c *gin.Context) {
+	var body struct {
+		DisplayName *string `json:"displayName"`
+		Icon        *string `json:"icon"`
+		Color       *string `json:"color"`
+		Archived    *bool   `json:"archived"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	if body.DisplayName != nil {
+		displayName := strings.TrimSpace(*body.DisplayName)
+		if len(displayName) > deviceMaxDisplayName {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("display name too long (max %d characters)", deviceMaxDisplayName)})
+			return
+		}
+		body.DisplayName = &displayName
+	}
+	if body.Icon != nil && len(*body.Icon) > deviceMaxIcon {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("icon too long (max %d characters)", deviceMaxIcon)})
+		return
+	}
+	if body.Color != nil && *body.Color != "" && !deviceColorPattern.MatchString(*body.Color) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid color, hex color like #4f46e5 expected"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	device, err := models.DeviceGetByDeviceID(user.ID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	if err := models.DeviceUpdateDetails(device.DeviceID, body.DisplayName, body.Icon, body.Color, body.Archived); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device updated successfully"})
+}
+
+// DeleteDevice deletes the device identified by the ID in the request URL with all of its sessions and their data.
+// Devices with an active session cannot be deleted, as the next upload would recreate them.
+func DeleteDevice(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	device, ok := getInactiveDevice(c, user.ID, c.Param("id"))
+	if !ok {
+		return
+	}
+
+	if err := device.Delete(timeSeriesStore); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device deleted successfully"})
+}
+
+// TransferDevice requests the transfer of the device identified by the ID in the request URL to the user with the email
+// given in the JSON body, e.g. when the car is sold. The device stays with the user until the recipient accepts the transfer.
+// keepHistory is required: if true, the sessions, trouble codes and vehicle profiles are transferred with the device,
+// otherwise they are deleted on acceptance. A new request replaces the pending transfer of the device.
+func TransferDevice(c *gin.Context) {
+	var body struct {
+		Email       string `json:"email"`
+		KeepHistory *bool  `json:"keepHistory"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if err := validateEmail(body.Email); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if body.KeepHistory == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keepHistory is required"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	recipient, err := models.UserGetByEmail(body.Email)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if recipient.ID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device already belongs to the user"})
+		return
+	}
+
+	device, err := models.DeviceGetByDeviceID(user.ID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	transfer := models.DeviceTransfer{
+		DeviceID:    device.DeviceID,
+		FromUserID:  user.ID,
+		ToUserID:    recipient.ID,
+		KeepHistory: *body.KeepHistory,
+	}
+	if err := models.DeviceTransferCreate(&transfer); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transfer": transfer,
+	})
+}
+
+// GetDeviceTransferList returns the pending transfers of the authenticated user's devices and to the user, newest first.
+func GetDeviceTransferList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	transfers, err := models.DeviceTransferListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"transfers": transfers,
+	})
+}
+
+// AcceptDeviceTransfer accepts the pending transfer to the authenticated user identified by the ID in the request URL,
+// moving the device to the user. The dashboards defined for the device are deleted and the upload keys bound to it revoked.
+// Devices with an active session cannot be transferred.
+func AcceptDeviceTransfer(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	transfer, ok := getDeviceTransfer(c, user.ID)
+	if !ok {
+		return
+	}
+	if transfer.ToUserID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "only the recipient can accept the transfer"})
+		return
+	}
+
+	device, ok := getInactiveDevice(c, transfer.FromUserID, transfer.DeviceID)
+	if !ok {
+		return
+	}
+
+	if err := device.Transfer(timeSeriesStore, user.ID, transfer.KeepHistory); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device transferred successfully"})
+}
+
+// DeleteDeviceTransfer cancels or declines the pending transfer identified by the ID in the request URL,
+// the authenticated user being its sender or its recipient.
+func DeleteDeviceTransfer(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	transfer, ok := getDeviceTransfer(c, user.ID)
+	if !ok {
+		return
+	}
+
+	if err := models.DeviceTransferDelete(user.ID, transfer.ID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device transfer not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device transfer deleted successfully"})
+}
+
+// getDeviceTransfer retrieves the pending transfer identified by the ID in the request URL the user is the sender
+// or the recipient of, responding with an error if it is not found
+func getDeviceTransfer(c *gin.Context, userID uint) (models.DeviceTransfer, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid device transfer ID"})
+		return models.DeviceTransfer{}, false
+	}
+
+	transfer, err := models.DeviceTransferGetByID(userID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device transfer not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return transfer, false
+	}
+
+	return transfer, true
+}
+
+// getInactiveDevice retrieves the device of the user by its device ID,
+// responding with an error if it is not found or has an active session
+func getInactiveDevice(c *gin.Context, userID uint, deviceID string) (models.Device, bool) {
+	device, err := models.DeviceGetByDeviceID(userID, deviceID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return device, false
+	}
+
+	isActive := true
+	activeSessions, err := device.GetSessions(models.SessionFilter{IsActive: &isActive})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return device, false
+	}
+	if len(activeSessions) > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "device has an active session"})
+		return device, false
+	}
+
+	return device, true
+}
+
+// GetDeviceProfiles returns the vehicle profile snapshots of the device identified by the ID in the request URL
+// in chronological order, showing e.g. how the odometer and the fuel cost evolved.
+// The optional from and to query parameters (RFC3339 times or dates) limit the time range, a to date includes that day.
+func GetDeviceProfiles(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	device, err := models.DeviceGetByDeviceID(user.ID, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+		return
+	}
+
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	profiles, err := models.VehicleProfileListGetByDeviceID(user.ID, device.DeviceID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles,
+	})
+}
+
+// parseTimeRange parses the from and to query parameters of the history listings, e.g. of the vehicle profiles,
+// responding with an error if one of them is invalid. Missing parameters are returned as zero times.
+func parseTimeRange(c *gin.Context) (time.Time, time.Time, bool) {
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = parseSessionListTime(value, false); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, RFC3339 time or date expected"})
+			return from, to, false
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = parseSessionListTime(value, true); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to, RFC3339 time or date expected"})
+			return from, to, false
+		}
+	}
+	return from, to, true
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestDeviceTransfer(t *testing.T) {
+	r := newTestRouter()
+
+	tests := []struct {
+		name            string
+		keepHistory     bool
+		accept          bool // accepted by the recipient, declined otherwise
+		wantTransferred bool
+		wantHistory     bool // the recipient sees the sessions after the transfer
+	}{
+		{name: "accepted with the history", keepHistory: true, accept: true, wantTransferred: true, wantHistory: true},
+		{name: "accepted without the history", accept: true, wantTransferred: true},
+		{name: "declined", keepHistory: true},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sender, recipient := newTestUser(t), newTestUser(t)
+			deviceID := fmt.Sprintf("transfer-dev-%d", i)
+			sessionID := int64(1714590000000 + i*3600000)
+			if _, _, err := models.DeviceFindOrCreate(deviceID, sender.user.ID); err != nil {
+				t.Fatalf("DeviceFindOrCreate: %v", err)
+			}
+			uploadTestSamples(t, r, sender, deviceID, sessionID, 3)
+			sessionActivity.flush()
+			if err := models.SessionClose(fmt.Sprint(sessionID)); err != nil {
+				t.Fatalf("SessionClose: %v", err)
+			}
+			data := url.Values{"device-id": {deviceID}, "session-id": {fmt.Sprint(sessionID)}}
+
+			body := map[string]any{"email": recipient.user.Email, "keepHistory": test.keepHistory}
+			recorder := serveTestJSON(t, r, http.MethodPost, "/api/device/"+deviceID+"/transfer", body, sender.token)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("transfer: status %d: %s", recorder.Code, recorder.Body.String())
+			}
+			var response struct {
+				Transfer models.DeviceTransfer
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			transferPath := fmt.Sprintf("/api/device-transfer/%d", response.Transfer.ID)
+
+			// Before the acceptance, the device and its history stay with the sender
+			if recorder := serveTest(r, "/api/data", data, recipient.token); recorder.Code != http.StatusNotFound {
+				t.Errorf("data of the recipient before the acceptance: status %d, want %d", recorder.Code, http.StatusNotFound)
+			}
+			if recorder := serveTestJSON(t, r, http.MethodPost, transferPath+"/accept", nil, sender.token); recorder.Code != http.StatusForbidden {
+				t.Errorf("accepted by the sender: status %d, want %d", recorder.Code, http.StatusForbidden)
+			}
+			recorder = serveTest(r, "/api/device-transfer", nil, recipient.token)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("transfer list: status %d: %s", recorder.Code, recorder.Body.String())
+			}
+			var list struct {
+				Transfers []models.DeviceTransfer
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &list); err != nil {
+				t.Fatal(err)
+			}
+			if len(list.Transfers) != 1 || list.Transfers[0].DeviceID != deviceID || list.Transfers[0].KeepHistory != test.keepHistory {
+				t.Errorf("transfers of the recipient = %+v, want the requested one", list.Transfers)
+			}
+
+			if test.accept {
+				recorder = serveTestJSON(t, r, http.MethodPost, transferPath+"/accept", nil, recipient.token)
+			} else {
+				recorder = serveTestJSON(t, r, http.MethodDelete, transferPath, nil, recipient.token)
+			}
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("accept or decline: status %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			owner := sender
+			if test.wantTransferred {
+				owner = recipient
+			}
+			if _, err := models.DeviceGetByDeviceID(owner.user.ID, deviceID); err != nil {
+				t.Errorf("device not owned by %s: %v", owner.user.Email, err)
+			}
+			wantStatus := http.StatusNotFound
+			if test.wantHistory {
+				wantStatus = http.StatusOK
+			}
+			if recorder := serveTest(r, "/api/data", data, recipient.token); recorder.Code != wantStatus {
+				t.Errorf("data of the recipient: status %d, want %d", recorder.Code, wantStatus)
+			}
+			if transfers, _ := models.DeviceTransferListGetByUserID(recipient.user.ID); len(transfers) != 0 {
+				t.Errorf("transfers left pending: %+v", transfers)
+			}
+		})
+	}
+}
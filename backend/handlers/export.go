@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Field keys of the values used by the track exports
+const (
+	exportFieldSpeedGPS  = "kff1001" // km/h
+	exportFieldLongitude = "kff1005"
+	exportFieldLatitude  = "kff1006"
+	exportFieldAltitude  = "kff1010" // m
+)
+
+// exportFlushRows is the number of rows or track points after which the response is flushed to the client
+const exportFlushRows = 500
+
+// sessionExporter writes the data of a session in an export format
+type sessionExporter struct {
+	contentType string
+	extension   string
+	write       func(w *bufio.Writer, flush func(), session models.Session, options models.SessionDataOptions, units models.UnitPreferences) error
+}
+
+var sessionExporters = map[string]sessionExporter{
+	"csv":     {contentType: "text/csv; charset=utf-8", extension: "csv", write: writeSessionCSV},
+	"gpx":     {contentType: "application/gpx+xml", extension: "gpx", write: writeSessionGPX},
+	"kml":     {contentType: "application/vnd.google-earth.kml+xml", extension: "kml", write: writeSessionKML},
+	"geojson": {contentType: "application/geo+json", extension: "geojson", write: writeSessionGeoJSON},
+}
+
+// ExportSession streams the data of a session as a file download.
+// The format query parameter selects csv, gpx, kml or geojson. The fields (csv only), start, stop
+// and window query parameters select and average the exported data like for GetData.
+// CSV values are converted to the user's preferred units, the track formats use the units of their standards.
+// Positions within the privacy zones of the user are dropped or snapped to the centre of the zone.
+func ExportSession(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	exporter, exists := sessionExporters[format]
+	if !exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid format, csv, gpx, kml or geojson expected"})
+		return
+	}
+
+	options, err := parseSessionDataOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if options.Method == models.SessionDataMethodLTTB {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "lttb is not supported for exports"})
+		return
+	}
+	options.Privacy, err = models.PrivacyZoneListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("gorque-%s-%s.%s", session.DeviceID, session.SessionID, exporter.extension)
+	c.Header("Content-Type", exporter.contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	w := bufio.NewWriter(c.Writer)
+	flush := func() {
+		w.Flush()
+		c.Writer.Flush()
+	}
+
+	if err := exporter.write(w, flush, session, options, user.UnitPreferences()); err != nil {
+		// The response is already committed, the client sees a truncated file
+		log.Printf("Session export error for %s: %v", session.SessionID, err)
+	}
+	flush()
+}
+
+// writeSessionCSV writes one row per point in time and one column per field.
+// The fields are collected in a first pass, so the rows can be streamed in the second.
+func writeSessionCSV(w *bufio.Writer, flush func(), session models.Session, options models.SessionDataOptions, units models.UnitPreferences) error {
+	keys := options.Fields
+	if len(keys) == 0 {
+		keySet := make(map[string]struct{})
+		err := session.EachSessionPoint(timeSeriesStore, options, func(point models.TimeSeriesPoint) error {
+			for key := range point.Fields {
+				keySet[key] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for key := range keySet {
+			keys = append(keys, key)
+		}
+		slices.SortFunc(keys, func(a, b string) int {
+			return strings.Compare(string(models.UserDataCodeFromFieldKey(a)), string(models.UserDataCodeFromFieldKey(b)))
+		})
+	}
+
+	catalog, err := models.FieldCatalogGetBySessionID(session.SessionID)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(w)
+	header := []string{"Time"}
+	conversions := make([]models.UnitConversion, len(keys))
+	for i, key := range keys {
+		metadata := catalog.Metadata(key)
+		conversions[i] = units.Conversion(metadata.Unit)
+		metadata.Unit = conversions[i].To
+		header = append(header, exportColumnName(metadata))
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	rows := 0
+	record := make([]string, len(keys)+1)
+	err = session.EachSessionPoint(timeSeriesStore, options, func(point models.TimeSeriesPoint) error {
+		record[0] = point.Time.UTC().Format(time.RFC3339Nano)
+		for i, key := range keys {
+			value := point.Fields[key]
+			if number, ok := value.(float64); ok {
+				value = conversions[i].Apply(number)
+			}
+			record[i+1] = formatExportValue(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+
+		if rows++; rows%exportFlushRows == 0 {
+			writer.Flush()
+			flush()
+		}
+		return writer.Error()
+	})
+	writer.Flush()
+	if err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+// writeSessionGPX writes the GPS track as a GPX 1.1 track with elevation and the Garmin speed extension
+func writeSessionGPX(w *bufio.Writer, flush func(), session models.Session, options models.SessionDataOptions, _ models.UnitPreferences) error {
+	fmt.Fprintf(w, `%s<gpx version="1.1" creator="gorque" xmlns="http://www.topografix.com/GPX/1/1" `+
+		`xmlns:gpxtpx="http://www.garmin.com/xmlschemas/TrackPointExtension/v2">`+"\n", xml.Header)
+	fmt.Fprintf(w, "<trk><name>%s</name><trkseg>\n", exportEscapeXML(exportTrackName(session)))
+
+	err := eachTrackPoint(session, options, flush, func(point models.TimeSeriesPoint, lat float64, lon float64) {
+		fmt.Fprintf(w, `<trkpt lat="%s" lon="%s">`, formatExportFloat(lat), formatExportFloat(lon))
+		if altitude, ok := point.Fields[exportFieldAltitude].(float64); ok {
+			fmt.Fprintf(w, "<ele>%s</ele>", formatExportFloat(altitude))
+		}
+		fmt.Fprintf(w, "<time>%s</time>", point.Time.UTC().Format(time.RFC3339Nano))
+		if speed, ok := point.Fields[exportFieldSpeedGPS].(float64); ok {
+			// GPX speeds are in meters per second
+			fmt.Fprintf(w, "<extensions><gpxtpx:TrackPointExtension><gpxtpx:speed>%s</gpxtpx:speed></gpxtpx:TrackPointExtension></extensions>",
+				formatExportFloat(speed/3.6))
+		}
+		w.WriteString("</trkpt>\n")
+	})
+
+	w.WriteString("</trkseg></trk></gpx>\n")
+	return err
+}
+
+// writeSessionKML writes the GPS track as a KML line string with altitudes
+func writeSessionKML(w *bufio.Writer, flush func(), session models.Session, options models.SessionDataOptions, _ models.UnitPreferences) error {
+	fmt.Fprintf(w, `%s<kml xmlns="http://www.opengis.net/kml/2.2"><Document>`+"\n", xml.Header)
+	fmt.Fprintf(w, "<name>%s</name>\n", exportEscapeXML(exportTrackName(session)))
+	w.WriteString("<Placemark><LineString><tessellate>1</tessellate><altitudeMode>clampToGround</altitudeMode><coordinates>\n")
+
+	err := eachTrackPoint(session, options, flush, func(point models.TimeSeriesPoint, lat float64, lon float64) {
+		altitude, _ := point.Fields[exportFieldAltitude].(float64)
+		fmt.Fprintf(w, "%s,%s,%s\n", formatExportFloat(lon), formatExportFloat(lat), formatExportFloat(altitude))
+	})
+
+	w.WriteString("</coordinates></LineString></Placemark></Document></kml>\n")
+	return err
+}
+
+// geoJSONProperties are the properties of the exported GeoJSON feature, the coordTimes property is streamed after them
+type geoJSONProperties struct {
+	Name      string     `json:"name"`
+	DeviceID  string     `json:"deviceId"`
+	SessionID string     `json:"sessionId"`
+	StartTime time.Time  `json:"startTime"`
+	EndTime   *time.Time `json:"endTime"`
+}
+
+// writeSessionGeoJSON writes the GPS track as a GeoJSON feature with a line string geometry.
+// The coordinates are streamed in a first pass and their times in a second one as the coordTimes property, one per coordinate,
+// so the track is not kept in memory. Both passes read the data up to the start of the export, the second one writes
+// at most as many times as there are coordinates. If a pass fails, the feature is closed without the missing times.
+func writeSessionGeoJSON(w *bufio.Writer, flush func(), session models.Session, options models.SessionDataOptions, _ models.UnitPreferences) error {
+	encoded, err := json.Marshal(geoJSONProperties{
+		Name:      exportTrackName(session),
+		DeviceID:  session.DeviceID,
+		SessionID: session.SessionID,
+		StartTime: session.StartTime,
+		EndTime:   session.EndTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Points uploaded to an active session during the export do not change the time range or the averaging windows
+	now := time.Now()
+	if session.EndTime == nil {
+		session.EndTime = &now
+	}
+	if options.Stop.IsZero() || options.Stop.After(now) {
+		options.Stop = now
+	}
+
+	coordinates := 0
+	w.WriteString(`{"type":"Feature","geometry":{"type":"LineString","coordinates":[`)
+	err = eachTrackPoint(session, options, flush, func(point models.TimeSeriesPoint, lat float64, lon float64) {
+		if coordinates > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, "[%s,%s", formatExportFloat(lon), formatExportFloat(lat))
+		if altitude, ok := point.Fields[exportFieldAltitude].(float64); ok {
+			fmt.Fprintf(w, ",%s", formatExportFloat(altitude))
+		}
+		w.WriteByte(']')
+		coordinates++
+	})
+
+	// The properties object is left open for the coordTimes property
+	w.WriteString(`]},"properties":`)
+	w.Write(encoded[:len(encoded)-1])
+	w.WriteString(`,"coordTimes":[`)
+	if err == nil {
+		times := 0
+		err = eachTrackPoint(session, options, flush, func(point models.TimeSeriesPoint, _ float64, _ float64) {
+			if times == coordinates {
+				return
+			}
+			if times > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, `"%s"`, point.Time.UTC().Format(time.RFC3339Nano))
+			times++
+		})
+	}
+	w.WriteString("]}}\n")
+	return err
+}
+
+// eachTrackPoint calls fn for every point of the session with a GPS position, flushing the response regularly
+func eachTrackPoint(session models.Session, options models.SessionDataOptions, flush func(), fn func(point models.TimeSeriesPoint, lat float64, lon float64)) error {
+	options.Fields = []string{exportFieldLatitude, exportFieldLongitude, exportFieldAltitude, exportFieldSpeedGPS}
+
+	count := 0
+	return session.EachSessionPoint(timeSeriesStore, options, func(point models.TimeSeriesPoint) error {
+		lat, latOK := point.Fields[exportFieldLatitude].(float64)
+		lon, lonOK := point.Fields[exportFieldLongitude].(float64)
+		if !latOK || !lonOK || (lat == 0 && lon == 0) {
+			return nil
+		}
+
+		fn(point, lat, lon)
+		if count++; count%exportFlushRows == 0 {
+			flush()
+		}
+		return nil
+	})
+}
+
+// exportColumnName returns the CSV column name of a field in the Torque log format, e.g. Speed (OBD)(km/h)
+func exportColumnName(metadata models.FieldMetadata) string {
+	if metadata.Unit == "" {
+		return metadata.FullName
+	}
+	return metadata.FullName + "(" + metadata.Unit + ")"
+}
+
+// exportTrackName returns the name of the exported track
+func exportTrackName(session models.Session) string {
+	return fmt.Sprintf("%s %s", session.DeviceID, session.StartTime.UTC().Format("2006-01-02 15:04"))
+}
+
+// exportEscapeXML escapes s for XML character data
+func exportEscapeXML(s string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(s))
+	return escaped.String()
+}
+
+// formatExportValue formats a field value for CSV, empty if missing
+func formatExportValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case float64:
+		return formatExportFloat(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// formatExportFloat formats a number with the shortest exact representation
+func formatExportFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/aafeher/gorque/models"
+	"testing"
+	"time"
+)
+
+// failingQueryStore fails the queries after the first passed points
+type failingQueryStore struct {
+	*models.MemoryTimeSeriesStore
+	passed int // points passed before failing
+}
+
+func (store *failingQueryStore) Query(ctx context.Context, query models.TimeSeriesQuery, fn func(point models.TimeSeriesPoint) error) error {
+	passed := 0
+	return store.MemoryTimeSeriesStore.Query(ctx, query, func(point models.TimeSeriesPoint) error {
+		if passed == store.passed {
+			return errors.New("store down")
+		}
+		passed++
+		return fn(point)
+	})
+}
+
+func TestWriteSessionGeoJSON(t *testing.T) {
+	r := newTestRouter()
+	user := newTestUser(t)
+	uploadTestSamples(t, r, user, "export-dev", 1714600000000, 4)
+	session, err := models.SessionGetBySessionID("1714600000000", user.user.ID)
+	if err != nil {
+		t.Fatalf("SessionGetBySessionID: %v", err)
+	}
+
+	tests := []struct {
+		name            string
+		store           models.TimeSeriesStore
+		wantCoordinates int
+		wantTimes       int
+		wantErr         bool
+	}{
+		{name: "streamed", store: testStore, wantCoordinates: 4, wantTimes: 4},
+		{name: "failed query closes the feature", store: &failingQueryStore{MemoryTimeSeriesStore: testStore, passed: 2}, wantCoordinates: 2, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			timeSeriesStore = test.store
+			defer func() { timeSeriesStore = testStore }()
+
+			var buffer bytes.Buffer
+			w := bufio.NewWriter(&buffer)
+			err := writeSessionGeoJSON(w, func() {}, session, models.SessionDataOptions{}, models.UnitPreferences{})
+			w.Flush()
+			if (err != nil) != test.wantErr {
+				t.Errorf("error = %v, want error %v", err, test.wantErr)
+			}
+
+			var feature struct {
+				Geometry struct {
+					Coordinates [][]float64
+				}
+				Properties struct {
+					SessionID  string
+					CoordTimes []time.Time
+				}
+			}
+			if err := json.Unmarshal(buffer.Bytes(), &feature); err != nil {
+				t.Fatalf("invalid GeoJSON: %v\n%s", err, buffer.String())
+			}
+			if got := len(feature.Geometry.Coordinates); got != test.wantCoordinates {
+				t.Errorf("coordinates = %d, want %d", got, test.wantCoordinates)
+			}
+			if got := len(feature.Properties.CoordTimes); got != test.wantTimes {
+				t.Errorf("coordTimes = %d, want %d", got, test.wantTimes)
+			}
+			if feature.Properties.SessionID != session.SessionID {
+				t.Errorf("sessionId = %q, want %q", feature.Properties.SessionID, session.SessionID)
+			}
+			for i, at := range feature.Properties.CoordTimes {
+				if want := time.UnixMilli(1714600000000 + int64(i)*1000); !at.Equal(want) {
+					t.Errorf("coordTimes[%d] = %v, want %v", i, at, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits of the geofences and the geofence event lists
+const (
+	geofenceMaxName           = 100
+	geofenceMaxRadius         = 100000 // meters
+	geofenceMaxVertices       = 1000
+	geofenceEventDefaultLimit = 100
+	geofenceEventMaxLimit     = 1000
+)
+
+// geofenceRequest is the JSON body of the geofence create and update requests
+type geofenceRequest struct {
+	Name      string      `json:"name"`
+	Type      string      `json:"type"`      // circle or polygon
+	Latitude  float64     `json:"latitude"`  // center of a circle
+	Longitude float64     `json:"longitude"` // center of a circle
+	Radius    float64     `json:"radius"`    // radius of a circle in meters
+	Polygon   [][]float64 `json:"polygon"`   // [lat, lon] vertices of a polygon
+}
+
+// GetGeofenceList returns the geofences of the authenticated user ordered by name.
+func GetGeofenceList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	geofences, err := models.GeofenceListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"geofences": geofences,
+	})
+}
+
+// CreateGeofence creates a geofence of the authenticated user from the JSON body.
+// The geofence is used for the sessions ending from now on, earlier sessions are detected again on request.
+func CreateGeofence(c *gin.Context) {
+	var body geofenceRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	geofence := models.Geofence{UserID: user.ID}
+	if !applyGeofenceRequest(c, &geofence, body) {
+		return
+	}
+
+	if err := models.GeofenceCreate(&geofence); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"geofence": geofence,
+	})
+}
+
+// UpdateGeofence replaces the name and the shape of the geofence identified by the ID in the request URL with the JSON body.
+func UpdateGeofence(c *gin.Context) {
+	var body geofenceRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	geofence, ok := getGeofence(c, user.ID)
+	if !ok {
+		return
+	}
+	if !applyGeofenceRequest(c, &geofence, body) {
+		return
+	}
+
+	if err := models.GeofenceUpdate(&geofence); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"geofence": geofence,
+	})
+}
+
+// DeleteGeofence deletes the geofence identified by the ID in the request URL.
+// Its events are kept, the sessions starting or ending in it lose the reference.
+func DeleteGeofence(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	geofence, ok := getGeofence(c, user.ID)
+	if !ok {
+		return
+	}
+
+	if err := geofence.Delete(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Geofence deleted successfully"})
+}
+
+// GetGeofenceEventList returns the geofence enter and exit events of the authenticated user, most recent first.
+// The events can be filtered by the device, session, geofence, from and to query parameters.
+// At most limit events are returned, 100 by default.
+func GetGeofenceEventList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+	filter := models.GeofenceEventFilter{
+		DeviceID:  c.Query("device"),
+		SessionID: c.Query("session"),
+	}
+	if !from.IsZero() {
+		filter.From = &from
+	}
+	if !to.IsZero() {
+		filter.To = &to
+	}
+	if value := c.Query("geofence"); value != "" {
+		geofenceID, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid geofence ID"})
+			return
+		}
+		filter.GeofenceID = uint(geofenceID)
+	}
+
+	limit := geofenceEventDefaultLimit
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > geofenceEventMaxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit, 1 to %d expected", geofenceEventMaxLimit)})
+			return
+		}
+	}
+
+	events, err := models.GeofenceEventListGetByUserID(user.ID, filter, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+	})
+}
+
+// GetSessionGeofences returns the geofences the session identified by the ID in the request URL started and ended in,
+// with its geofence events in chronological order.
+func GetSessionGeofences(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	events, err := models.GeofenceEventListGetBySessionID(session.SessionID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"startGeofenceId": session.StartGeofenceID,
+		"endGeofenceId":   session.EndGeofenceID,
+		"events":          events,
+	})
+}
+
+// DetectSessionGeofences detects the geofence events and the start and end geofences of the session identified by the ID
+// in the request URL again, e.g. after the geofences changed. A generated title is renamed after the trip.
+func DetectSessionGeofences(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	events, err := session.DetectGeofences(timeSeriesStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session":         session,
+		"startGeofenceId": session.StartGeofenceID,
+		"endGeofenceId":   session.EndGeofenceID,
+		"events":          events,
+	})
+}
+
+// getGeofence retrieves the geofence of the user identified by the ID in the request URL,
+// responding with an error if the ID is invalid or the geofence does not exist
+func getGeofence(c *gin.Context, userID uint) (models.Geofence, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid geofence ID"})
+		return models.Geofence{}, false
+	}
+
+	geofence, err := models.GeofenceGetByID(userID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "geofence not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return geofence, false
+	}
+	return geofence, true
+}
+
+// applyGeofenceRequest validates the settings of a geofence request and sets them on the geofence,
+// responding with an error if they are invalid
+func applyGeofenceRequest(c *gin.Context, geofence *models.Geofence, body geofenceRequest) bool {
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return false
+	}
+	if len(body.Name) > geofenceMaxName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name too long (max %d characters)", geofenceMaxName)})
+		return false
+	}
+
+	switch body.Type {
+	case models.GeofenceTypeCircle:
+		if !validCoordinate(body.Latitude, body.Longitude) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid center coordinates"})
+			return false
+		}
+		if body.Radius <= 0 || body.Radius > geofenceMaxRadius {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid radius, more than 0 up to %d meters expected", geofenceMaxRadius)})
+			return false
+		}
+		body.Polygon = nil
+	case models.GeofenceTypePolygon:
+		if len(body.Polygon) < 3 || len(body.Polygon) > geofenceMaxVertices {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid polygon, 3 to %d vertices expected", geofenceMaxVertices)})
+			return false
+		}
+		for _, vertex := range body.Polygon {
+			if len(vertex) != 2 || !validCoordinate(vertex[0], vertex[1]) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid polygon vertex, [lat, lon] expected"})
+				return false
+			}
+		}
+		body.Latitude, body.Longitude, body.Radius = 0, 0, 0
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be circle or polygon"})
+		return false
+	}
+
+	geofence.Name = body.Name
+	geofence.Type = body.Type
+	geofence.Latitude = body.Latitude
+	geofence.Longitude = body.Longitude
+	geofence.Radius = body.Radius
+	geofence.Polygon = body.Polygon
+	return true
+}
+
+// validCoordinate reports whether the latitude and longitude are in range
+func validCoordinate(lat, lon float64) bool {
+	return lat >= -90 && lat <= 90 && lon >= -180 && lon <= 180
+}
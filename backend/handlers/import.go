@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Time formats of the time columns of Torque CSV logs
+const (
+	torqueCSVGPSTimeLayout    = "Mon Jan 02 15:04:05 GMT-07:00 2006" // GPS Time, e.g. Sun Oct 18 08:00:00 GMT+02:00 2026
+	torqueCSVDeviceTimeLayout = "02-Jan-2006 15:04:05"               // Device Time in the phone's time zone, e.g. 18-Oct-2026 08:00:00.123
+)
+
+var (
+	errImportSessionExists = errors.New("the log has already been imported")
+	errImportNoSamples     = errors.New("the log contains no samples with a time and known fields")
+)
+
+// torqueCSVFixedColumns are the columns Torque writes at the start of every log, which are not named after a PID
+var torqueCSVFixedColumns = map[string]torqueCSVColumn{
+	"longitude":                 {key: "kff1005", factor: 1},
+	"latitude":                  {key: "kff1006", factor: 1},
+	"altitude":                  {key: "kff1010", factor: 1},
+	"gps speed (meters/second)": {key: "kff1001", factor: 3.6}, // stored in km/h
+	"g(x)":                      {key: "kff1220", factor: 1},
+	"g(y)":                      {key: "kff1221", factor: 1},
+	"g(z)":                      {key: "kff1222", factor: 1},
+	"g(calibrated)":             {key: "kff1223", factor: 1},
+}
+
+// torqueCSVNames looks up UserDataItems by their lower case full and short names
+var torqueCSVNames = sync.OnceValues(func() (map[string]models.UserDataCode, map[string]models.UserDataCode) {
+	fullNames := make(map[string]models.UserDataCode)
+	shortNames := make(map[string]models.UserDataCode)
+	for code, item := range models.UserDataItems {
+		fullNames[strings.ToLower(item.FullName)] = code
+		shortNames[strings.ToLower(item.ShortName)] = code
+	}
+	return fullNames, shortNames
+})
+
+// TorqueImportResult summarizes an imported Torque CSV log
+type TorqueImportResult struct {
+	SessionID      string   `json:"sessionId"`
+	Samples        int      `json:"samples"`
+	SkippedRows    int      `json:"skippedRows"`    // rows without a readable time
+	SkippedColumns []string `json:"skippedColumns"` // columns that could not be mapped to a field
+}
+
+// torqueCSVColumn maps a column of a Torque CSV log to a stored field
+type torqueCSVColumn struct {
+	index  int
+	key    string  // stored field key, e.g. kd
+	factor float64 // converts the logged value to the unit of the field
+}
+
+// torqueCSVLayout describes the columns of a Torque CSV log
+type torqueCSVLayout struct {
+	gpsTime    int // column indexes, -1 if missing
+	deviceTime int
+	time       int // RFC3339 time written by the gorque CSV export
+	columns    []torqueCSVColumn
+	skipped    []string
+}
+
+// ImportTorqueCSV imports a Torque CSV trip log of a device as a new session, writing the samples through the upload path.
+// Device times are read in the given location; if it is nil, the time zone is derived from the GPS times of the log.
+// If the import fails after the session was created, the session is deleted with its samples, so it can be retried.
+func (s *UploadService) ImportTorqueCSV(user *models.User, deviceID string, r io.ReadSeeker, location *time.Location) (result TorqueImportResult, err error) {
+	sessionCreated := false
+	defer func() {
+		if err != nil && sessionCreated {
+			s.discardImport(user, result.SessionID)
+		}
+	}()
+
+	if err := s.checkDeviceOwner(deviceID, user.ID); err != nil {
+		return result, err
+	}
+	if _, _, err := models.DeviceFindOrCreate(deviceID, user.ID); err != nil {
+		return result, err
+	}
+
+	if location == nil {
+		if location, err = detectTorqueCSVLocation(r); err != nil {
+			return result, err
+		}
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return result, err
+		}
+	}
+
+	reader := newTorqueCSVReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return result, fmt.Errorf("cannot read the header: %w", err)
+	}
+	layout := parseTorqueCSVHeader(header)
+	result.SkippedColumns = layout.skipped
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+
+		// Torque repeats the header when the logged PIDs change
+		if isTorqueCSVHeader(record) {
+			layout = parseTorqueCSVHeader(record)
+			result.SkippedColumns = append(result.SkippedColumns, layout.skipped...)
+			continue
+		}
+
+		sampleTime, ok := layout.rowTime(record, location)
+		if !ok {
+			result.SkippedRows++
+			continue
+		}
+
+		fields := layout.rowFields(record)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if result.SessionID == "" {
+			// Like Torque, the session is identified by the time of its first sample
+			result.SessionID = strconv.FormatInt(sampleTime.UnixMilli(), 10)
+			session, created, err := models.SessionFindOrCreate(result.SessionID, deviceID, user.ID, 0, sampleTime)
+			if err != nil && !errors.Is(err, models.ErrSessionOwner) {
+				return result, err
+			}
+			if !created {
+				return result, errImportSessionExists
+			}
+			sessionCreated = true
+			s.webhooks.SessionStarted(session)
+		}
+
+		err = s.acceptSample(&uploadSample{
+			User:      user,
+			DeviceID:  deviceID,
+			SessionID: result.SessionID,
+			Time:      sampleTime,
+			Fields:    fields,
+		})
+		if err != nil {
+			return result, err
+		}
+		result.Samples++
+	}
+
+	if result.Samples == 0 {
+		return result, errImportNoSamples
+	}
+
+	// The samples are complete at this point, a session left active by a failure is closed by the reaper
+	if err := s.finishImport(user, deviceID, result.SessionID); err != nil {
+		sessionCreated = false
+		return result, err
+	}
+	return result, nil
+}
+
+// discardImport deletes the session of a failed import with the samples stored so far
+func (s *UploadService) discardImport(user *models.User, sessionID string) {
+	s.activity.flush()
+	session, err := models.SessionGetBySessionID(sessionID, user.ID)
+	if err == nil {
+		err = session.Delete(s.store)
+	}
+	if err != nil {
+		log.Printf("Failed import cleanup error for session %s: %v", sessionID, err)
+		return
+	}
+	log.Printf("Deleted session %s of the failed import", sessionID)
+}
+
+// finishImport waits for the imported samples to be stored, then closes the session, detects its geofences
+// and calculates its statistics.
+// The end of the session and its statistics are published to the webhooks.
+func (s *UploadService) finishImport(user *models.User, deviceID string, sessionID string) error {
+	s.activity.flush()
+	if err := models.FlushTimeSeries(context.Background(), s.store); err != nil {
+		return err
+	}
+
+	if err := models.SessionClose(sessionID); err != nil {
+		return err
+	}
+
+	session, err := models.SessionGetBySessionID(sessionID, user.ID)
+	if err != nil {
+		return err
+	}
+	if _, err := session.DetectGeofences(s.store); err != nil {
+		log.Printf("Session geofence detection error for %s: %v", sessionID, err)
+	}
+	s.webhooks.SessionEnded(session)
+	if stat, err := session.CalculateStats(s.store); err != nil {
+		log.Printf("Session stats calculation error for %s: %v", sessionID, err)
+	} else {
+		s.webhooks.StatsComputed(user, session, stat)
+	}
+
+	log.Printf("Imported session %s of device %s", sessionID, deviceID)
+	return nil
+}
+
+// newTorqueCSVReader creates a CSV reader tolerating the irregularities of Torque logs
+func newTorqueCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.LazyQuotes = true
+	reader.ReuseRecord = true
+	return reader
+}
+
+// detectTorqueCSVLocation derives the time zone of the device times from the first row having both a GPS and a device time.
+// The offset is rounded to 15 minutes. UTC is returned if the log has no such row.
+func detectTorqueCSVLocation(r io.Reader) (*time.Location, error) {
+	reader := newTorqueCSVReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the header: %w", err)
+	}
+	layout := parseTorqueCSVHeader(header)
+	if layout.gpsTime < 0 || layout.deviceTime < 0 {
+		return time.UTC, nil
+	}
+
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			return time.UTC, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) <= max(layout.gpsTime, layout.deviceTime) {
+			continue
+		}
+
+		gpsTime, err := time.Parse(torqueCSVGPSTimeLayout, strings.TrimSpace(record[layout.gpsTime]))
+		if err != nil {
+			continue
+		}
+		deviceTime, err := time.ParseInLocation(torqueCSVDeviceTimeLayout, strings.TrimSpace(record[layout.deviceTime]), time.UTC)
+		if err != nil {
+			continue
+		}
+
+		offset := deviceTime.Sub(gpsTime).Round(15 * time.Minute)
+		return time.FixedZone("", int(offset.Seconds())), nil
+	}
+}
+
+// isTorqueCSVHeader reports whether a record is a header row
+func isTorqueCSVHeader(record []string) bool {
+	return len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "GPS Time")
+}
+
+// parseTorqueCSVHeader maps the columns of a Torque CSV log to stored fields
+func parseTorqueCSVHeader(header []string) torqueCSVLayout {
+	layout := torqueCSVLayout{gpsTime: -1, deviceTime: -1, time: -1}
+
+	for index, name := range header {
+		name = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		switch strings.ToLower(name) {
+		case "":
+			continue
+		case "gps time":
+			layout.gpsTime = index
+			continue
+		case "device time":
+			layout.deviceTime = index
+			continue
+		case "time":
+			layout.time = index
+			continue
+		}
+
+		column, ok := lookupTorqueCSVColumn(name)
+		if !ok {
+			layout.skipped = append(layout.skipped, name)
+			continue
+		}
+		column.index = index
+		layout.columns = append(layout.columns, column)
+	}
+
+	return layout
+}
+
+// lookupTorqueCSVColumn maps a column header like "Speed (OBD)(km/h)" to a field through the UserDataItems names.
+// Columns logged in a unit other than the one of the field are not mapped.
+func lookupTorqueCSVColumn(header string) (torqueCSVColumn, bool) {
+	if column, exists := torqueCSVFixedColumns[strings.ToLower(header)]; exists {
+		return column, true
+	}
+
+	name, unit := header, ""
+	if strings.HasSuffix(header, ")") {
+		if i := strings.LastIndex(header, "("); i > 0 {
+			name, unit = strings.TrimSpace(header[:i]), header[i+1:len(header)-1]
+		}
+	}
+
+	fullNames, shortNames := torqueCSVNames()
+	for _, candidate := range []string{name, header} {
+		code, exists := fullNames[strings.ToLower(candidate)]
+		if !exists {
+			code, exists = shortNames[strings.ToLower(candidate)]
+		}
+		if !exists {
+			continue
+		}
+
+		itemUnit := models.UserDataItems[code].Unit
+		if candidate == name && unit != "" && itemUnit != "" && !strings.EqualFold(unit, itemUnit) {
+			return torqueCSVColumn{}, false
+		}
+		return torqueCSVColumn{key: code.FieldKey(), factor: 1}, true
+	}
+
+	return torqueCSVColumn{}, false
+}
+
+// rowTime returns the time of a row, preferring the device time, which Torque logs for every row
+func (layout torqueCSVLayout) rowTime(record []string, location *time.Location) (time.Time, bool) {
+	if value, ok := csvValue(record, layout.deviceTime); ok {
+		if t, err := time.ParseInLocation(torqueCSVDeviceTimeLayout, value, location); err == nil {
+			return t, true
+		}
+	}
+	if value, ok := csvValue(record, layout.gpsTime); ok {
+		if t, err := time.Parse(torqueCSVGPSTimeLayout, value); err == nil {
+			return t, true
+		}
+	}
+	if value, ok := csvValue(record, layout.time); ok {
+		if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// rowFields returns the numeric values of a row keyed by field key. Torque logs missing values as "-".
+func (layout torqueCSVLayout) rowFields(record []string) map[string]any {
+	fields := make(map[string]any)
+	for _, column := range layout.columns {
+		value, ok := csvValue(record, column.index)
+		if !ok || value == "-" {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		fields[column.key] = number * column.factor
+	}
+	return fields
+}
+
+// csvValue returns the trimmed value of a column of a record, if present
+func csvValue(record []string, index int) (string, bool) {
+	if index < 0 || index >= len(record) {
+		return "", false
+	}
+	value := strings.TrimSpace(record[index])
+	return value, value != ""
+}
+
+// ImportTorqueLog imports a Torque CSV trip log uploaded as the file form field for the device given in device-id.
+// The optional tz form field is the IANA time zone of the phone, otherwise it is derived from the GPS times.
+func ImportTorqueLog(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	deviceID := c.PostForm("device-id")
+	if deviceID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "device ID is required"})
+		return
+	}
+
+	var location *time.Location
+	if tz := c.PostForm("tz"); tz != "" {
+		var err error
+		if location, err = time.LoadLocation(tz); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid time zone"})
+			return
+		}
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "file is required"})
+		return
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	result, err := uploadService.ImportTorqueCSV(user, deviceID, file, location)
+	if err != nil {
+		switch {
+		case errors.Is(err, errUploadDeviceMismatch):
+			c.JSON(http.StatusForbidden, gin.H{"error": "device is registered to another user"})
+		case errors.Is(err, errImportSessionExists):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, errImportNoSamples):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Torque log imported successfully",
+		"import":  result,
+	})
+}
+
+// ImportTorqueCSVFile imports a Torque CSV trip log file, for use from the command line.
+func ImportTorqueCSVFile(user *models.User, deviceID string, path string, location *time.Location) (TorqueImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return TorqueImportResult{}, err
+	}
+	defer file.Close()
+
+	return uploadService.ImportTorqueCSV(user, deviceID, file, location)
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/aafeher/gorque/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// failingTestStore fails the writes after the first accepted ones
+type failingTestStore struct {
+	*models.MemoryTimeSeriesStore
+	accepted int // writes accepted before failing
+}
+
+func (store *failingTestStore) Write(ctx context.Context, points ...models.TimeSeriesPoint) error {
+	if store.accepted == 0 {
+		return errors.New("store down")
+	}
+	store.accepted--
+	return store.MemoryTimeSeriesStore.Write(ctx, points...)
+}
+
+const importTestLog = `Time,Speed (OBD)(km/h)
+2024-05-01T14:00:00Z,10
+2024-05-01T14:00:01Z,20
+2024-05-01T14:00:02Z,30
+`
+
+func TestImportTorqueCSVRetryAfterFailure(t *testing.T) {
+	user := newTestUser(t)
+	store := &failingTestStore{MemoryTimeSeriesStore: models.NewMemoryTimeSeriesStore(), accepted: 2}
+	service := NewUploadService(store, sessionActivity, liveBroker, alertEngine, webhookDispatcher, nil, ingestPrivacy)
+
+	result, err := service.ImportTorqueCSV(user.user, "import-dev", strings.NewReader(importTestLog), time.UTC)
+	if err == nil {
+		t.Fatal("import with failing store succeeded")
+	}
+	if _, err := models.SessionGetBySessionID(result.SessionID, user.user.ID); err == nil {
+		t.Errorf("session %s of the failed import was kept", result.SessionID)
+	}
+	start := time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC)
+	points, err := models.QueryAll(context.Background(), store, models.TimeSeriesQuery{
+		DeviceID: "import-dev", SessionID: result.SessionID, Start: start, Stop: start.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(points) != 0 {
+		t.Errorf("points of the failed import = %d, want 0", len(points))
+	}
+
+	store.accepted = 3
+	result, err = service.ImportTorqueCSV(user.user, "import-dev", strings.NewReader(importTestLog), time.UTC)
+	if err != nil {
+		t.Fatalf("retried import: %v", err)
+	}
+	if result.Samples != 3 {
+		t.Errorf("samples = %d, want 3", result.Samples)
+	}
+	session, err := models.SessionGetBySessionID(result.SessionID, user.user.ID)
+	if err != nil {
+		t.Fatalf("session of the retried import: %v", err)
+	}
+	if session.IsActive {
+		t.Error("session of the retried import is active")
+	}
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"github.com/aafeher/gorque/models"
+	"sync"
+)
+
+// IngestPrivacy masks the GPS positions of the uploaded samples within the privacy zones applied at ingest,
+// before they are stored or passed on to the live streams, the alert rules and MQTT.
+type IngestPrivacy struct {
+	mutex sync.Mutex
+	zones map[uint]models.PrivacyZones // ingest zones keyed by user ID, loaded on the first sample of the user
+}
+
+// NewIngestPrivacy creates a new ingest privacy filter
+func NewIngestPrivacy() *IngestPrivacy {
+	return &IngestPrivacy{
+		zones: make(map[uint]models.PrivacyZones),
+	}
+}
+
+// Invalidate drops the zones of the user, e.g. after a zone changed. They are reloaded with the next sample of the user.
+func (ip *IngestPrivacy) Invalidate(userID uint) {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	delete(ip.zones, userID)
+}
+
+// Mask drops or snaps the position of the sample if it is within an ingest zone of the user.
+// Both the GPS fields and the lat and lon parameters are masked.
+// Returns an error if the zones cannot be loaded, the sample must not be stored then.
+func (ip *IngestPrivacy) Mask(sample *uploadSample) error {
+	zones, err := ip.userZones(sample.User.ID)
+	if err != nil || len(zones) == 0 {
+		return err
+	}
+
+	sample.Fields = zones.MaskFields(sample.Fields)
+	if sample.Lat == 0 && sample.Lon == 0 {
+		return nil
+	}
+	if zone := zones.ZoneAt(sample.Lat, sample.Lon); zone != nil {
+		sample.Lat, sample.Lon = 0, 0
+		if zone.Mode == models.PrivacyZoneModeSnap {
+			sample.Lat, sample.Lon = zone.Latitude, zone.Longitude
+		}
+	}
+	return nil
+}
+
+// userZones returns the ingest zones of the user, loading them on first use
+func (ip *IngestPrivacy) userZones(userID uint) (models.PrivacyZones, error) {
+	ip.mutex.Lock()
+	defer ip.mutex.Unlock()
+
+	if zones, exists := ip.zones[userID]; exists {
+		return zones, nil
+	}
+	zones, err := models.PrivacyZoneListGetIngest(userID)
+	if err != nil {
+		return nil, err
+	}
+	ip.zones[userID] = zones
+	return zones, nil
+}
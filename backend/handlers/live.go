@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"time"
+)
+
+// liveKeepAliveInterval is how often an idle live stream sends a ping, so proxies do not close it
+const liveKeepAliveInterval = 15 * time.Second
+
+// GetSessionLive streams the samples of an active session as Server-Sent Events while they are uploaded.
+// Each sample event carries the GPS position and the values of the uploaded fields.
+// A dropped event reports samples skipped because the client did not keep up,
+// and an end event is sent when the server closes the stream, e.g. because the session ended.
+func GetSessionLive(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if !session.IsActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "session has ended"})
+		return
+	}
+
+	topic := LiveTopic{UserID: user.ID, DeviceID: session.DeviceID, SessionID: session.SessionID}
+	sub := liveBroker.Subscribe(topic)
+	defer liveBroker.Unsubscribe(topic, sub)
+
+	// The reaper marks the session inactive before it closes the topic. If that happened before the subscription,
+	// the subscriber would never be closed, so the session is checked again now that it is subscribed.
+	if session, err = models.SessionGetBySessionID(session.SessionID, user.ID); err != nil || !session.IsActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "session has ended"})
+		return
+	}
+
+	keepAlive := time.NewTicker(liveKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	var dropped int64
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case sample := <-sub.C:
+			if total := sub.Dropped(); total > dropped {
+				c.SSEvent("dropped", gin.H{"count": total - dropped})
+				dropped = total
+			}
+			c.SSEvent("sample", sample)
+			return true
+		case <-keepAlive.C:
+			c.SSEvent("ping", time.Now().UnixMilli())
+			return true
+		case <-sub.Done:
+			c.SSEvent("end", gin.H{"sessionId": session.SessionID})
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
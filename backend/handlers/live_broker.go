@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// LiveTopic identifies the stream of a device session of a user
+type LiveTopic struct {
+	UserID    uint
+	DeviceID  string
+	SessionID string
+}
+
+// LiveSample is a sample pushed to the live subscribers of a session
+type LiveSample struct {
+	Time   int64              `json:"time"` // Unix time in milliseconds
+	Lat    *float64           `json:"lat,omitempty"`
+	Lon    *float64           `json:"lon,omitempty"`
+	Values map[string]float64 `json:"values"` // keyed by UserDataCode, e.g. k0d
+}
+
+// LiveSubscription receives the samples of a topic until it is cancelled or the topic is closed
+type LiveSubscription struct {
+	C       <-chan LiveSample
+	Done    <-chan struct{} // closed when the topic is closed, e.g. because the session ended
+	samples chan LiveSample
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// Dropped returns the number of samples dropped because the subscriber did not keep up
+func (sub *LiveSubscription) Dropped() int64 {
+	return sub.dropped.Load()
+}
+
+// LiveBroker is an in-process publish/subscribe broker for live session samples.
+// Publishing never blocks: a subscriber with a full buffer loses its oldest sample.
+type LiveBroker struct {
+	mutex       sync.RWMutex
+	subscribers map[LiveTopic]map[*LiveSubscription]struct{}
+	bufferSize  int // samples buffered per subscriber
+}
+
+// NewLiveBroker creates a new live broker
+func NewLiveBroker(bufferSize int) *LiveBroker {
+	return &LiveBroker{
+		subscribers: make(map[LiveTopic]map[*LiveSubscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Subscribe registers a new subscriber of the topic
+func (lb *LiveBroker) Subscribe(topic LiveTopic) *LiveSubscription {
+	samples := make(chan LiveSample, lb.bufferSize)
+	done := make(chan struct{})
+	sub := &LiveSubscription{C: samples, Done: done, samples: samples, done: done}
+
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	if lb.subscribers[topic] == nil {
+		lb.subscribers[topic] = make(map[*LiveSubscription]struct{})
+	}
+	lb.subscribers[topic][sub] = struct{}{}
+
+	return sub
+}
+
+// Unsubscribe removes a subscriber of the topic
+func (lb *LiveBroker) Unsubscribe(topic LiveTopic, sub *LiveSubscription) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	delete(lb.subscribers[topic], sub)
+	if len(lb.subscribers[topic]) == 0 {
+		delete(lb.subscribers, topic)
+	}
+}
+
+// HasSubscribers reports whether the topic has any subscriber, so publishers can skip building samples
+func (lb *LiveBroker) HasSubscribers(topic LiveTopic) bool {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+
+	return len(lb.subscribers[topic]) > 0
+}
+
+// Publish sends the sample to all subscribers of the topic without blocking
+func (lb *LiveBroker) Publish(topic LiveTopic, sample LiveSample) {
+	lb.mutex.RLock()
+	defer lb.mutex.RUnlock()
+
+	for sub := range lb.subscribers[topic] {
+		select {
+		case sub.samples <- sample:
+			continue
+		default:
+		}
+
+		// Buffer full, drop the oldest sample to make room for the latest
+		select {
+		case <-sub.samples:
+			sub.dropped.Add(1)
+		default:
+		}
+		select {
+		case sub.samples <- sample:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// CloseTopic ends all subscriptions of the topic
+func (lb *LiveBroker) CloseTopic(topic LiveTopic) {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	for sub := range lb.subscribers[topic] {
+		close(sub.done)
+	}
+	delete(lb.subscribers, topic)
+}
+
+// Close ends all subscriptions of all topics
+func (lb *LiveBroker) Close() {
+	lb.mutex.Lock()
+	defer lb.mutex.Unlock()
+
+	for topic, subs := range lb.subscribers {
+		for sub := range subs {
+			close(sub.done)
+		}
+		delete(lb.subscribers, topic)
+	}
+}
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// mqttQueueSize is the number of samples waiting to be published, further samples are dropped
+const mqttQueueSize = 1024
+
+// MQTT availability payloads of the server, published to the status topic
+const (
+	mqttStatusOnline  = "online"
+	mqttStatusOffline = "offline"
+)
+
+// MQTTConfig holds the broker settings of the MQTT publisher
+type MQTTConfig struct {
+	URL             string // e.g. tcp://localhost:1883, ssl:// and ws:// are supported too
+	Username        string
+	Password        string
+	ClientID        string
+	TopicPrefix     string // topics are <prefix>/<device>/<pid>
+	DiscoveryPrefix string // Home Assistant discovery prefix, empty to not publish discovery configs
+}
+
+// MQTTConfigFromEnv reads the MQTT settings from the environment. The publisher is disabled if MQTT_URL is not set.
+func MQTTConfigFromEnv() (MQTTConfig, bool) {
+	config := MQTTConfig{
+		URL:             os.Getenv("MQTT_URL"),
+		Username:        os.Getenv("MQTT_USERNAME"),
+		Password:        os.Getenv("MQTT_PASSWORD"),
+		ClientID:        os.Getenv("MQTT_CLIENT_ID"),
+		TopicPrefix:     strings.Trim(os.Getenv("MQTT_TOPIC_PREFIX"), "/"),
+		DiscoveryPrefix: strings.Trim(os.Getenv("MQTT_DISCOVERY_PREFIX"), "/"),
+	}
+	if config.ClientID == "" {
+		config.ClientID = "gorque"
+	}
+	if config.TopicPrefix == "" {
+		config.TopicPrefix = "gorque"
+	}
+	if config.DiscoveryPrefix == "" {
+		config.DiscoveryPrefix = "homeassistant"
+	}
+	if os.Getenv("MQTT_DISCOVERY") == "false" {
+		config.DiscoveryPrefix = ""
+	}
+	return config, config.URL != ""
+}
+
+// mqttClient is the part of the MQTT client used by the publisher
+type mqttClient interface {
+	Connect() mqtt.Token
+	IsConnectionOpen() bool
+	Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token
+	Disconnect(quiesce uint)
+}
+
+// mqttPosition is the retained last known position of a device, usable as Home Assistant device tracker attributes
+type mqttPosition struct {
+	Latitude    float64  `json:"latitude"`
+	Longitude   float64  `json:"longitude"`
+	GPSAccuracy *float64 `json:"gps_accuracy,omitempty"`
+	Time        int64    `json:"time"` // Unix milliseconds
+}
+
+// mqttDevice is the state of a device kept by the publisher
+type mqttDevice struct {
+	name       string
+	discovered map[models.UserDataCode]bool // fields with a published discovery config
+	trackerSet bool                         // device tracker and driving discovery configs published
+	driving    *bool                        // last published driving state
+}
+
+// MQTTPublisher publishes the accepted samples to an MQTT broker in the background, one topic per device and field,
+// e.g. gorque/<device>/k0d. The last known position of the devices and whether they are driving are published
+// as retained topics, with Home Assistant discovery configs for the fields, the position and the driving state.
+// Samples are dropped while the broker is unreachable, the client reconnects automatically.
+type MQTTPublisher struct {
+	config  MQTTConfig
+	client  mqttClient
+	samples chan uploadSample
+	mutex   sync.Mutex
+	devices map[string]*mqttDevice // keyed by device ID
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+}
+
+// NewMQTTPublisher creates a new MQTT publisher, connects to the broker and starts publishing in the background.
+// Connecting is retried until the publisher is closed.
+func NewMQTTPublisher(config MQTTConfig) *MQTTPublisher {
+	mp := newMQTTPublisher(config)
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.URL).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(10*time.Second).
+		SetMaxReconnectInterval(time.Minute).
+		SetWill(mp.statusTopic(), mqttStatusOffline, 1, true).
+		SetOnConnectHandler(mp.onConnect).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("MQTT connection lost: %v", err)
+		})
+	mp.start(mqtt.NewClient(options))
+
+	return mp
+}
+
+// newMQTTPublisher creates a new MQTT publisher without a client, start connects it
+func newMQTTPublisher(config MQTTConfig) *MQTTPublisher {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &MQTTPublisher{
+		config:  config,
+		samples: make(chan uploadSample, mqttQueueSize),
+		devices: make(map[string]*mqttDevice),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// start connects the client to the broker and starts publishing in the background
+func (mp *MQTTPublisher) start(client mqttClient) {
+	mp.client = client
+	mp.client.Connect()
+
+	mp.running.Add(1)
+	go mp.run()
+}
+
+// Publish queues an accepted sample for publishing without blocking the caller.
+// The sample is dropped if the queue is full.
+func (mp *MQTTPublisher) Publish(sample uploadSample) {
+	select {
+	case mp.samples <- sample:
+	default:
+		log.Printf("MQTT queue full, sample of device %s dropped", sample.DeviceID)
+	}
+}
+
+// SessionEnded marks the device of the session not driving
+func (mp *MQTTPublisher) SessionEnded(session models.Session) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	if device, exists := mp.devices[session.DeviceID]; exists {
+		mp.publishDriving(session.DeviceID, device, false)
+	}
+}
+
+// Close stops publishing, marks the server offline and disconnects from the broker
+func (mp *MQTTPublisher) Close() {
+	mp.cancel()
+	mp.running.Wait()
+
+	if mp.client.IsConnectionOpen() {
+		mp.client.Publish(mp.statusTopic(), 1, true, mqttStatusOffline).WaitTimeout(time.Second)
+	}
+	mp.client.Disconnect(250)
+}
+
+// onConnect marks the server online. The discovery configs are published again,
+// the broker may have lost the retained messages.
+func (mp *MQTTPublisher) onConnect(client mqtt.Client) {
+	log.Printf("MQTT connected to %s", mp.config.URL)
+	client.Publish(mp.statusTopic(), 1, true, mqttStatusOnline)
+
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	for _, device := range mp.devices {
+		device.discovered = make(map[models.UserDataCode]bool)
+		device.trackerSet = false
+		device.driving = nil
+	}
+}
+
+// run publishes the queued samples until the publisher is closed
+func (mp *MQTTPublisher) run() {
+	defer mp.running.Done()
+
+	for {
+		select {
+		case sample := <-mp.samples:
+			if mp.client.IsConnectionOpen() {
+				mp.publishSample(sample)
+			}
+		case <-mp.ctx.Done():
+			return
+		}
+	}
+}
+
+// publishSample publishes the field values, the position and the driving state of a sample,
+// preceded by the discovery configs not published yet
+func (mp *MQTTPublisher) publishSample(sample uploadSample) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	device := mp.device(sample)
+	if !device.trackerSet {
+		mp.publishTrackerDiscovery(sample.DeviceID, device)
+		device.trackerSet = true
+	}
+
+	for key, value := range sample.Fields {
+		number, ok := value.(float64)
+		if !ok {
+			continue
+		}
+		code := models.UserDataCodeFromFieldKey(key)
+		if !device.discovered[code] {
+			mp.publishSensorDiscovery(sample.DeviceID, device, code)
+			device.discovered[code] = true
+		}
+		mp.client.Publish(mp.deviceTopic(sample.DeviceID, string(code)), 0, false, strconv.FormatFloat(number, 'f', -1, 64))
+	}
+
+	if position, ok := samplePosition(sample); ok {
+		if payload, err := json.Marshal(position); err == nil {
+			mp.client.Publish(mp.deviceTopic(sample.DeviceID, "position"), 1, true, payload)
+		}
+	}
+
+	// The OBD speed is preferred, the GPS speed is used by vehicles without it
+	speed, ok := sample.Fields[models.UserDataCodeK0D.FieldKey()].(float64)
+	if !ok {
+		speed, ok = sample.Fields[models.UserDataCodeKFF1001.FieldKey()].(float64)
+	}
+	if ok {
+		mp.publishDriving(sample.DeviceID, device, speed > 0)
+	}
+}
+
+// device returns the state of the device of the sample, loading its name on first use
+func (mp *MQTTPublisher) device(sample uploadSample) *mqttDevice {
+	if device, exists := mp.devices[sample.DeviceID]; exists {
+		return device
+	}
+
+	device := &mqttDevice{
+		name:       "Torque " + sample.DeviceID,
+		discovered: make(map[models.UserDataCode]bool),
+	}
+	if record, err := models.DeviceGetByDeviceID(sample.User.ID, sample.DeviceID); err == nil {
+		if record.DisplayName != "" {
+			device.name = record.DisplayName
+		} else if record.ProfileName != "" {
+			device.name = record.ProfileName
+		}
+	}
+	mp.devices[sample.DeviceID] = device
+	return device
+}
+
+// publishDriving publishes the driving state of a device if it changed
+func (mp *MQTTPublisher) publishDriving(deviceID string, device *mqttDevice, driving bool) {
+	if device.driving != nil && *device.driving == driving {
+		return
+	}
+	device.driving = &driving
+
+	payload := "OFF"
+	if driving {
+		payload = "ON"
+	}
+	mp.client.Publish(mp.deviceTopic(deviceID, "driving"), 1, true, payload)
+}
+
+// publishSensorDiscovery publishes the Home Assistant sensor config of a field of a device.
+// Fields missing from UserDataItems, e.g. custom PIDs, are named after their code.
+func (mp *MQTTPublisher) publishSensorDiscovery(deviceID string, device *mqttDevice, code models.UserDataCode) {
+	config := map[string]any{
+		"name":        string(code),
+		"state_topic": mp.deviceTopic(deviceID, string(code)),
+		"state_class": "measurement",
+	}
+	if item, known := models.UserDataItems[code]; known {
+		config["name"] = item.FullName
+		if item.Unit != "" {
+			config["unit_of_measurement"] = item.Unit
+		}
+	}
+	mp.publishDiscovery("sensor", deviceID, device, string(code), config)
+}
+
+// publishTrackerDiscovery publishes the Home Assistant configs of the position and the driving state of a device
+func (mp *MQTTPublisher) publishTrackerDiscovery(deviceID string, device *mqttDevice) {
+	mp.publishDiscovery("device_tracker", deviceID, device, "position", map[string]any{
+		"name":                  "Position",
+		"json_attributes_topic": mp.deviceTopic(deviceID, "position"),
+		"source_type":           "gps",
+	})
+	mp.publishDiscovery("binary_sensor", deviceID, device, "driving", map[string]any{
+		"name":         "Driving",
+		"state_topic":  mp.deviceTopic(deviceID, "driving"),
+		"device_class": "moving",
+	})
+}
+
+// publishDiscovery publishes a retained Home Assistant discovery config of an entity of a device
+func (mp *MQTTPublisher) publishDiscovery(component string, deviceID string, device *mqttDevice, entity string, config map[string]any) {
+	if mp.config.DiscoveryPrefix == "" {
+		return
+	}
+
+	id := fmt.Sprintf("%s_%s_%s", mp.config.ClientID, mqttTopicLevel(deviceID), entity)
+	config["unique_id"] = id
+	config["object_id"] = id
+	config["availability_topic"] = mp.statusTopic()
+	config["device"] = map[string]any{
+		"identifiers":  []string{mp.config.ClientID + "_" + mqttTopicLevel(deviceID)},
+		"name":         device.name,
+		"manufacturer": "gorque",
+		"model":        "Torque",
+	}
+
+	payload, err := json.Marshal(config)
+	if err != nil {
+		log.Printf("MQTT discovery config error for %s: %v", id, err)
+		return
+	}
+	mp.client.Publish(fmt.Sprintf("%s/%s/%s/config", mp.config.DiscoveryPrefix, component, id), 1, true, payload)
+}
+
+// deviceTopic returns the topic of a value of a device
+func (mp *MQTTPublisher) deviceTopic(deviceID string, name string) string {
+	return mp.config.TopicPrefix + "/" + mqttTopicLevel(deviceID) + "/" + name
+}
+
+// statusTopic returns the availability topic of the server
+func (mp *MQTTPublisher) statusTopic() string {
+	return mp.config.TopicPrefix + "/status"
+}
+
+// samplePosition returns the GPS position of a sample, preferring the GPS fields over the lat and lon parameters
+func samplePosition(sample uploadSample) (mqttPosition, bool) {
+	position := mqttPosition{Time: sample.Time.UnixMilli()}
+
+	lat, latOK := sample.Fields["kff1006"].(float64)
+	lon, lonOK := sample.Fields["kff1005"].(float64)
+	switch {
+	case latOK && lonOK:
+		position.Latitude, position.Longitude = lat, lon
+	case sample.Lat != 0 || sample.Lon != 0:
+		position.Latitude, position.Longitude = sample.Lat, sample.Lon
+	default:
+		return position, false
+	}
+
+	if accuracy, ok := sample.Fields["kff1239"].(float64); ok {
+		position.GPSAccuracy = &accuracy
+	}
+	return position, true
+}
+
+// mqttTopicLevel replaces the characters of a device ID that are not safe in a topic level or a discovery ID
+func mqttTopicLevel(value string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, value)
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mqttTestMessage is a message published through the mqttTestClient
+type mqttTestMessage struct {
+	topic    string
+	retained bool
+	payload  string
+}
+
+// mqttTestClient records the published messages instead of sending them to a broker
+type mqttTestClient struct {
+	mutex    sync.Mutex
+	open     bool
+	messages []mqttTestMessage
+}
+
+func (client *mqttTestClient) Connect() mqtt.Token {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.open = true
+	return &mqtt.DummyToken{}
+}
+
+func (client *mqttTestClient) IsConnectionOpen() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.open
+}
+
+func (client *mqttTestClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.messages = append(client.messages, mqttTestMessage{topic: topic, retained: retained, payload: fmt.Sprintf("%s", payload)})
+	return &mqtt.DummyToken{}
+}
+
+func (client *mqttTestClient) Disconnect(uint) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.open = false
+}
+
+// published returns the messages published since the last call, keyed by topic
+func (client *mqttTestClient) published() map[string]mqttTestMessage {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	messages := make(map[string]mqttTestMessage, len(client.messages))
+	for _, message := range client.messages {
+		messages[message.topic] = message
+	}
+	client.messages = nil
+	return messages
+}
+
+// mqttTestTopics returns the topics of the messages
+func mqttTestTopics(messages map[string]mqttTestMessage) []string {
+	var topics []string
+	for topic := range messages {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+func TestMQTTPublisherPublishSample(t *testing.T) {
+	user := newTestUser(t)
+	sample := func(speed float64) uploadSample {
+		return uploadSample{
+			User:     user.user,
+			DeviceID: "mqtt dev",
+			Time:     time.UnixMilli(1714564800000),
+			Fields:   map[string]any{"kd": speed, "kff1006": 47.5, "kff1005": 19.05},
+		}
+	}
+
+	tests := []struct {
+		name            string
+		discoveryPrefix string
+		samples         []uploadSample
+		wantMessages    map[string]mqttTestMessage // messages of the last sample
+		wantDiscovery   int                        // discovery configs published in total
+	}{
+		{
+			name:            "first sample",
+			discoveryPrefix: "homeassistant",
+			samples:         []uploadSample{sample(50)},
+			wantMessages: map[string]mqttTestMessage{
+				"gorque/mqtt_dev/k0d":      {topic: "gorque/mqtt_dev/k0d", payload: "50"},
+				"gorque/mqtt_dev/kff1006":  {topic: "gorque/mqtt_dev/kff1006", payload: "47.5"},
+				"gorque/mqtt_dev/kff1005":  {topic: "gorque/mqtt_dev/kff1005", payload: "19.05"},
+				"gorque/mqtt_dev/position": {topic: "gorque/mqtt_dev/position", retained: true, payload: `{"latitude":47.5,"longitude":19.05,"time":1714564800000}`},
+				"gorque/mqtt_dev/driving":  {topic: "gorque/mqtt_dev/driving", retained: true, payload: "ON"},
+			},
+			wantDiscovery: 5,
+		},
+		{
+			name:            "discovery configs and unchanged driving state are published once",
+			discoveryPrefix: "homeassistant",
+			samples:         []uploadSample{sample(50), sample(60)},
+			wantMessages: map[string]mqttTestMessage{
+				"gorque/mqtt_dev/k0d":      {topic: "gorque/mqtt_dev/k0d", payload: "60"},
+				"gorque/mqtt_dev/kff1006":  {topic: "gorque/mqtt_dev/kff1006", payload: "47.5"},
+				"gorque/mqtt_dev/kff1005":  {topic: "gorque/mqtt_dev/kff1005", payload: "19.05"},
+				"gorque/mqtt_dev/position": {topic: "gorque/mqtt_dev/position", retained: true, payload: `{"latitude":47.5,"longitude":19.05,"time":1714564800000}`},
+			},
+			wantDiscovery: 5,
+		},
+		{
+			name:            "stopped without discovery",
+			discoveryPrefix: "",
+			samples:         []uploadSample{sample(50), sample(0)},
+			wantMessages: map[string]mqttTestMessage{
+				"gorque/mqtt_dev/k0d":      {topic: "gorque/mqtt_dev/k0d", payload: "0"},
+				"gorque/mqtt_dev/kff1006":  {topic: "gorque/mqtt_dev/kff1006", payload: "47.5"},
+				"gorque/mqtt_dev/kff1005":  {topic: "gorque/mqtt_dev/kff1005", payload: "19.05"},
+				"gorque/mqtt_dev/position": {topic: "gorque/mqtt_dev/position", retained: true, payload: `{"latitude":47.5,"longitude":19.05,"time":1714564800000}`},
+				"gorque/mqtt_dev/driving":  {topic: "gorque/mqtt_dev/driving", retained: true, payload: "OFF"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &mqttTestClient{}
+			mp := newMQTTPublisher(MQTTConfig{ClientID: "gorque", TopicPrefix: "gorque", DiscoveryPrefix: test.discoveryPrefix})
+			mp.start(client)
+			defer mp.Close()
+
+			discovery := 0
+			var messages map[string]mqttTestMessage
+			for _, sample := range test.samples {
+				mp.publishSample(sample)
+				messages = client.published()
+				for topic, message := range messages {
+					if strings.HasPrefix(topic, "homeassistant/") {
+						if !message.retained {
+							t.Errorf("discovery config %s not retained", topic)
+						}
+						discovery++
+						delete(messages, topic)
+					}
+				}
+			}
+
+			if !reflect.DeepEqual(messages, test.wantMessages) {
+				t.Errorf("messages = %v, want %v", mqttTestTopics(messages), mqttTestTopics(test.wantMessages))
+				for topic, want := range test.wantMessages {
+					if got := messages[topic]; got != want {
+						t.Errorf("%s = %+v, want %+v", topic, got, want)
+					}
+				}
+			}
+			if discovery != test.wantDiscovery {
+				t.Errorf("discovery configs = %d, want %d", discovery, test.wantDiscovery)
+			}
+		})
+	}
+}
+
+func TestMQTTPublisherStatus(t *testing.T) {
+	client := &mqttTestClient{}
+	mp := newMQTTPublisher(MQTTConfig{ClientID: "gorque", TopicPrefix: "gorque"})
+	mp.start(client)
+	mp.Close()
+
+	want := map[string]mqttTestMessage{"gorque/status": {topic: "gorque/status", retained: true, payload: mqttStatusOffline}}
+	if got := client.published(); !reflect.DeepEqual(got, want) {
+		t.Errorf("messages on close = %+v, want %+v", got, want)
+	}
+	if client.IsConnectionOpen() {
+		t.Error("client not disconnected on close")
+	}
+}
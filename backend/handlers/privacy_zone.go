@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits of the privacy zones
+const (
+	privacyZoneMaxName   = 100
+	privacyZoneMaxRadius = 10000 // meters
+)
+
+// privacyZoneRequest is the JSON body of the privacy zone create and update requests
+type privacyZoneRequest struct {
+	Name         string  `json:"name"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	Radius       float64 `json:"radius"`       // meters
+	Mode         string  `json:"mode"`         // drop (default) or snap
+	DropAtIngest bool    `json:"dropAtIngest"` // mask the positions before they are stored
+}
+
+// GetPrivacyZoneList returns the privacy zones of the authenticated user ordered by name.
+func GetPrivacyZoneList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	zones, err := models.PrivacyZoneListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"privacyZones": zones,
+	})
+}
+
+// CreatePrivacyZone creates a privacy zone of the authenticated user from the JSON body.
+// The zone masks the positions of all sessions of the user in the session data, exports and shares,
+// with dropAtIngest also the positions uploaded from now on before they are stored.
+func CreatePrivacyZone(c *gin.Context) {
+	var body privacyZoneRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	zone := models.PrivacyZone{UserID: user.ID}
+	if !applyPrivacyZoneRequest(c, &zone, body) {
+		return
+	}
+
+	if err := models.PrivacyZoneCreate(&zone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ingestPrivacy.Invalidate(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"privacyZone": zone,
+	})
+}
+
+// UpdatePrivacyZone replaces the settings of the privacy zone identified by the ID in the request URL with the JSON body.
+func UpdatePrivacyZone(c *gin.Context) {
+	var body privacyZoneRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	zone, ok := getPrivacyZone(c, user.ID)
+	if !ok {
+		return
+	}
+	if !applyPrivacyZoneRequest(c, &zone, body) {
+		return
+	}
+
+	if err := models.PrivacyZoneUpdate(&zone); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ingestPrivacy.Invalidate(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"privacyZone": zone,
+	})
+}
+
+// DeletePrivacyZone deletes the privacy zone identified by the ID in the request URL.
+// Positions dropped at ingest are not restored.
+func DeletePrivacyZone(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	zone, ok := getPrivacyZone(c, user.ID)
+	if !ok {
+		return
+	}
+
+	if err := zone.Delete(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	ingestPrivacy.Invalidate(user.ID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Privacy zone deleted successfully"})
+}
+
+// getPrivacyZone retrieves the privacy zone of the user identified by the ID in the request URL,
+// responding with an error if the ID is invalid or the zone does not exist
+func getPrivacyZone(c *gin.Context, userID uint) (models.PrivacyZone, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid privacy zone ID"})
+		return models.PrivacyZone{}, false
+	}
+
+	zone, err := models.PrivacyZoneGetByID(userID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "privacy zone not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return zone, false
+	}
+	return zone, true
+}
+
+// applyPrivacyZoneRequest validates the settings of a privacy zone request and sets them on the zone,
+// responding with an error if they are invalid
+func applyPrivacyZoneRequest(c *gin.Context, zone *models.PrivacyZone, body privacyZoneRequest) bool {
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return false
+	}
+	if len(body.Name) > privacyZoneMaxName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name too long (max %d characters)", privacyZoneMaxName)})
+		return false
+	}
+	if !validCoordinate(body.Latitude, body.Longitude) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid centre coordinates"})
+		return false
+	}
+	if body.Radius <= 0 || body.Radius > privacyZoneMaxRadius {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid radius, more than 0 up to %d meters expected", privacyZoneMaxRadius)})
+		return false
+	}
+	switch body.Mode {
+	case "":
+		body.Mode = models.PrivacyZoneModeDrop
+	case models.PrivacyZoneModeDrop, models.PrivacyZoneModeSnap:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be drop or snap"})
+		return false
+	}
+
+	zone.Name = body.Name
+	zone.Latitude = body.Latitude
+	zone.Longitude = body.Longitude
+	zone.Radius = body.Radius
+	zone.Mode = body.Mode
+	zone.DropAtIngest = body.DropAtIngest
+	return true
+}
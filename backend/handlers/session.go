@@ -1,13 +1,37 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"github.com/aafeher/gorque/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 )
 
-// GetSessionList retrieves a list of sessions for a specific user and device from the database and returns them as JSON.
+// Limits of the session details
+const (
+	sessionMaxTitle = 200
+	sessionMaxNotes = 10000
+	sessionMaxTag   = 50
+	sessionMaxTags  = 20
+
+	sessionMaxSplitTimes = 100
+)
+
+// GetSessionList retrieves a list of sessions for a specific user and device from the database and returns them as JSON,
+// together with the trip statistics of the sessions keyed by session ID, in the user's preferred units.
 // It requires a valid user ID from the request context and a device ID passed as a query parameter.
+// The optional status query parameter limits the list to active (currently driving) or finished sessions,
+// the tag, favourite (true or false), from and to (RFC3339 times or dates) query parameters to the sessions
+// with the tag, the favourite mark and the start time. A to date includes the sessions started on that day.
+// The between query parameter takes one or two comma separated geofence IDs: the sessions starting or ending in the geofence,
+// or the trips between the two geofences in either direction.
+// The tags of the sessions are returned keyed by session ID.
 // Responds with an error if the user is not found, the device ID is missing, or a database query fails.
 func GetSessionList(c *gin.Context) {
 	user, ok := GetUserFromContext(c)
@@ -27,7 +51,102 @@ func GetSessionList(c *gin.Context) {
 		return
 	}
 
-	sessions, err := device.GetSessions()
+	filter, ok := parseSessionFilter(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := device.GetSessions(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondSessionList(c, user, sessions)
+}
+
+// parseSessionFilter parses the status, tag, between, favourite, from and to query parameters of session lists,
+// responding with an error if one of them is invalid
+func parseSessionFilter(c *gin.Context) (models.SessionFilter, bool) {
+	var filter models.SessionFilter
+	switch c.Query("status") {
+	case "":
+	case "active":
+		isActive := true
+		filter.IsActive = &isActive
+	case "finished":
+		isActive := false
+		filter.IsActive = &isActive
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be active or finished"})
+		return filter, false
+	}
+
+	filter.Tag = strings.TrimSpace(c.Query("tag"))
+
+	if between := c.Query("between"); between != "" {
+		ids := strings.Split(between, ",")
+		if len(ids) > 2 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "between must be one or two geofence IDs"})
+			return filter, false
+		}
+		for _, value := range ids {
+			id, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid geofence ID"})
+				return filter, false
+			}
+			filter.Geofences = append(filter.Geofences, uint(id))
+		}
+	}
+
+	if favourite := c.Query("favourite"); favourite != "" {
+		isFavourite, err := strconv.ParseBool(favourite)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "favourite must be true or false"})
+			return filter, false
+		}
+		filter.IsFavourite = &isFavourite
+	}
+
+	if from := c.Query("from"); from != "" {
+		t, err := parseSessionListTime(from, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid from, RFC3339 time or date expected"})
+			return filter, false
+		}
+		filter.From = &t
+	}
+	if to := c.Query("to"); to != "" {
+		t, err := parseSessionListTime(to, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid to, RFC3339 time or date expected"})
+			return filter, false
+		}
+		filter.To = &t
+	}
+
+	return filter, true
+}
+
+// respondSessionList responds with the sessions, their trip statistics in the user's preferred units
+// and their tags, both keyed by session ID
+func respondSessionList(c *gin.Context, user *models.User, sessions []models.Session) {
+	sessionIDs := make([]string, len(sessions))
+	for i, session := range sessions {
+		sessionIDs[i] = session.SessionID
+	}
+
+	stats, err := models.SessionStatListGetBySessionIDs(sessionIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	for sessionID, stat := range stats {
+		stats[sessionID] = stat.InUnits(user.UnitPreferences())
+	}
+
+	tags, err := models.SessionTagListGetBySessionIDs(sessionIDs)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -35,5 +154,372 @@ func GetSessionList(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"sessions": sessions,
+		"stats":    stats,
+		"tags":     tags,
+	})
+}
+
+// GetSessionStats returns the trip summary of the session identified by the ID in the request URL,
+// in the user's preferred units.
+// Statistics that have not been calculated yet, e.g. of a session that is still active, are calculated on demand.
+func GetSessionStats(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	stat, err := models.SessionStatGetBySessionID(session.SessionID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if stat, err = session.CalculateStats(timeSeriesStore); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		webhookDispatcher.StatsComputed(user, session, stat)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stats": stat.InUnits(user.UnitPreferences()),
+	})
+}
+
+// RecalculateSessionStats recalculates the trip summary of the session identified by the ID in the request URL.
+func RecalculateSessionStats(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	stat, err := session.CalculateStats(timeSeriesStore)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	webhookDispatcher.StatsComputed(user, session, stat)
+
+	c.JSON(http.StatusOK, gin.H{
+		"stats": stat.InUnits(user.UnitPreferences()),
+	})
+}
+
+// GetSessionEvents returns the notices Torque sent during the session identified by the ID in the request URL,
+// e.g. trip starts and stops, fault codes and alarms, in chronological order.
+func GetSessionEvents(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	events, err := models.SessionEventListGetBySessionID(session.SessionID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+	})
+}
+
+// UpdateSession updates the title, the notes and the favourite mark of the session identified by the ID in the request URL.
+// Values missing from the JSON body are left unchanged.
+func UpdateSession(c *gin.Context) {
+	var body struct {
+		Title     *string `json:"title"`
+		Notes     *string `json:"notes"`
+		Favourite *bool   `json:"favourite"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	if body.Title != nil {
+		title := strings.TrimSpace(*body.Title)
+		if len(title) > sessionMaxTitle {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("title too long (max %d characters)", sessionMaxTitle)})
+			return
+		}
+		body.Title = &title
+	}
+	if body.Notes != nil && len(*body.Notes) > sessionMaxNotes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("notes too long (max %d characters)", sessionMaxNotes)})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := models.SessionUpdateDetails(session.SessionID, body.Title, body.Notes, body.Favourite); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session updated successfully"})
+}
+
+// UpdateSessionTags replaces the tags of the session identified by the ID in the request URL.
+// Tags are trimmed and duplicates, differing only in case, are dropped.
+func UpdateSessionTags(c *gin.Context) {
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	var tags []string
+	for _, tag := range body.Tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if len(tag) > sessionMaxTag {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("tag too long (max %d characters)", sessionMaxTag)})
+			return
+		}
+		if !slices.ContainsFunc(tags, func(other string) bool { return strings.EqualFold(other, tag) }) {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) > sessionMaxTags {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many tags (max %d)", sessionMaxTags)})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if err := models.SessionTagReplace(session.SessionID, user.ID, tags); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tags": tags,
 	})
 }
+
+// UpdateSessionVehicle assigns the session identified by the ID in the request URL to the vehicle given by vehicleId
+// in the JSON body, or unassigns it if vehicleId is null.
+func UpdateSessionVehicle(c *gin.Context) {
+	var body struct {
+		VehicleID *uint `json:"vehicleId"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if body.VehicleID != nil {
+		if _, err := models.VehicleGetByID(*body.VehicleID, user.ID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+			return
+		}
+	}
+
+	if err := models.SessionUpdateVehicle(session.SessionID, body.VehicleID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session vehicle updated successfully"})
+}
+
+// DeleteSession deletes the session identified by the ID in the request URL with all of its data.
+// Active sessions cannot be deleted, as the next upload would recreate them.
+func DeleteSession(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if session.IsActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "session is still active"})
+		return
+	}
+
+	if err := session.Delete(timeSeriesStore); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session deleted successfully"})
+}
+
+// SplitSession splits the session identified by the ID in the request URL, either at the times given in the JSON body
+// or wherever no data was uploaded for longer than gapMinutes. The data after each split point is moved to a new session.
+// Responds with the resulting sessions like the session list.
+func SplitSession(c *gin.Context) {
+	var body struct {
+		Times      []time.Time `json:"times"`
+		GapMinutes float64     `json:"gapMinutes"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if (len(body.Times) == 0) == (body.GapMinutes <= 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "either times or a positive gapMinutes is required"})
+		return
+	}
+	if len(body.Times) > sessionMaxSplitTimes {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many split times (max %d)", sessionMaxSplitTimes)})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if session.IsActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "session is still active"})
+		return
+	}
+
+	var sessions []models.Session
+	if len(body.Times) > 0 {
+		sessions, err = session.Split(timeSeriesStore, body.Times)
+	} else {
+		sessions, err = session.SplitAtGaps(timeSeriesStore, time.Duration(body.GapMinutes*float64(time.Minute)))
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrSessionSplitNothing) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondSessionList(c, user, sessions)
+}
+
+// MergeSession merges the session identified by the ID in the request URL with the adjacent session of the same device
+// given by sessionId in the JSON body. The data is kept in the earlier session, the later one is deleted.
+// Responds with the merged session like the session list.
+func MergeSession(c *gin.Context) {
+	var body struct {
+		SessionID string `json:"sessionId"`
+	}
+	if err := c.BindJSON(&body); err != nil || body.SessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+	if body.SessionID == c.Param("id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "a session cannot be merged with itself"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+	other, err := models.SessionGetBySessionID(body.SessionID, user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	if session.IsActive || other.IsActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "session is still active"})
+		return
+	}
+
+	merged, err := models.SessionMerge(timeSeriesStore, session, other)
+	if err != nil {
+		if errors.Is(err, models.ErrSessionMergeDevice) || errors.Is(err, models.ErrSessionMergeNotAdjacent) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondSessionList(c, user, []models.Session{merged})
+}
+
+// parseSessionListTime parses a time of the session list filters, an RFC3339 time or a date in UTC.
+// A date is the start of the day, or the end of the day if endOfDay is set.
+func parseSessionListTime(value string, endOfDay bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return t, err
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
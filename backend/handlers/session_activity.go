@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"github.com/aafeher/gorque/models"
+	"log"
+	"sync"
+	"time"
+)
+
+// sessionActivityEntry holds the not yet persisted activity of a session
+type sessionActivityEntry struct {
+	endTime time.Time // time of the latest data point
+	records int       // number of data points received
+}
+
+// SessionActivity coalesces the per-sample session updates of the upload path into periodic database updates
+type SessionActivity struct {
+	mutex    sync.Mutex
+	pending  map[string]*sessionActivityEntry // keyed by session ID
+	interval time.Duration                    // how often pending activity is written
+	done     chan struct{}
+	closed   chan struct{}
+}
+
+// NewSessionActivity creates a new session activity tracker and starts its periodic flush in the background
+func NewSessionActivity(interval time.Duration) *SessionActivity {
+	sa := &SessionActivity{
+		pending:  make(map[string]*sessionActivityEntry),
+		interval: interval,
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+
+	go sa.run()
+
+	return sa
+}
+
+// Record registers a data point of a session
+func (sa *SessionActivity) Record(sessionID string, dataTime time.Time) {
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+
+	entry, exists := sa.pending[sessionID]
+	if !exists {
+		sa.pending[sessionID] = &sessionActivityEntry{endTime: dataTime, records: 1}
+		return
+	}
+
+	entry.records++
+	if dataTime.After(entry.endTime) {
+		entry.endTime = dataTime
+	}
+}
+
+// Close writes the pending activity and stops the periodic flush
+func (sa *SessionActivity) Close() {
+	close(sa.done)
+	<-sa.closed
+}
+
+// run flushes the pending activity on every tick
+func (sa *SessionActivity) run() {
+	defer close(sa.closed)
+
+	ticker := time.NewTicker(sa.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			sa.flush()
+		case <-sa.done:
+			sa.flush()
+			return
+		}
+	}
+}
+
+// flush writes the pending activity of all sessions to the database
+func (sa *SessionActivity) flush() {
+	sa.mutex.Lock()
+	pending := sa.pending
+	sa.pending = make(map[string]*sessionActivityEntry)
+	sa.mutex.Unlock()
+
+	for sessionID, entry := range pending {
+		if err := models.SessionUpdateActivityAndRecords(sessionID, entry.endTime, entry.records); err != nil {
+			log.Printf("Session update error for %s: %v", sessionID, err)
+		}
+	}
+}
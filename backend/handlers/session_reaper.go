@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"github.com/aafeher/gorque/models"
+	"log"
+	"time"
+)
+
+// SessionReaper periodically closes sessions that have not received an upload within the idle timeout
+type SessionReaper struct {
+	store       models.TimeSeriesStore
+	idleTimeout time.Duration // how long a session may go without uploads before it is closed
+	interval    time.Duration // how often idle sessions are looked up
+}
+
+// NewSessionReaper creates a new session reaper and starts it in the background
+func NewSessionReaper(store models.TimeSeriesStore, idleTimeout time.Duration, interval time.Duration) *SessionReaper {
+	sr := &SessionReaper{
+		store:       store,
+		idleTimeout: idleTimeout,
+		interval:    interval,
+	}
+
+	go sr.run()
+
+	return sr
+}
+
+// run closes idle sessions on every tick
+func (sr *SessionReaper) run() {
+	ticker := time.NewTicker(sr.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		sr.closeIdleSessions()
+	}
+}
+
+// closeIdleSessions marks all sessions inactive whose last upload is older than the idle timeout,
+// clears their active alerts, detects their geofences and calculates their trip statistics.
+// The ends and the statistics are published to the webhooks.
+func (sr *SessionReaper) closeIdleSessions() {
+	sessions, err := models.SessionListGetIdle(time.Now().Add(-sr.idleTimeout))
+	if err != nil {
+		log.Printf("Idle session lookup error: %v", err)
+		return
+	}
+
+	for _, session := range sessions {
+		if err := models.SessionClose(session.SessionID); err != nil {
+			log.Printf("Session close error for %s: %v", session.SessionID, err)
+			continue
+		}
+		log.Printf("Session %s of device %s closed after %s without uploads", session.SessionID, session.DeviceID, sr.idleTimeout)
+		liveBroker.CloseTopic(LiveTopic{UserID: session.UserID, DeviceID: session.DeviceID, SessionID: session.SessionID})
+
+		endTime := time.Now()
+		if session.EndTime != nil {
+			endTime = *session.EndTime
+		}
+		alertEngine.CloseSession(session.SessionID, endTime)
+
+		session.IsActive = false
+		if _, err := session.DetectGeofences(sr.store); err != nil {
+			log.Printf("Session geofence detection error for %s: %v", session.SessionID, err)
+		}
+		webhookDispatcher.SessionEnded(session)
+		if mqttPublisher != nil {
+			mqttPublisher.SessionEnded(session)
+		}
+
+		stat, err := session.CalculateStats(sr.store)
+		if err != nil {
+			log.Printf("Session stats calculation error for %s: %v", session.SessionID, err)
+			continue
+		}
+		if user, err := models.UserGetByID(session.UserID); err == nil {
+			webhookDispatcher.StatsComputed(user, session, stat)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"github.com/aafeher/gorque/models"
+	"os"
+	"time"
+)
+
+// timeSeriesStore is the storage of the Torque samples used by the handlers
+var timeSeriesStore models.TimeSeriesStore
+
+// sessionActivity coalesces the session updates of the upload path
+var sessionActivity *SessionActivity
+
+// liveBroker distributes the uploaded samples to the live session streams
+var liveBroker *LiveBroker
+
+// alertEngine evaluates the alert rules on the uploaded samples
+var alertEngine *AlertEngine
+
+// webhookDispatcher posts the session, trouble code, alert and statistics events to the webhooks of the users
+var webhookDispatcher *WebhookDispatcher
+
+// ingestPrivacy masks the positions of the uploaded samples within the privacy zones applied at ingest
+var ingestPrivacy *IngestPrivacy
+
+// mqttPublisher publishes the uploaded samples to the MQTT broker, nil if MQTT is not configured
+var mqttPublisher *MQTTPublisher
+
+// Init wires the handlers to the time-series store. It must be called before serving requests.
+func Init(store models.TimeSeriesStore) {
+	timeSeriesStore = store
+	sessionActivity = NewSessionActivity(models.GetEnvDuration("SESSION_ACTIVITY_INTERVAL", 5*time.Second))
+	liveBroker = NewLiveBroker(models.GetEnvInt("LIVE_BUFFER_SIZE", 64))
+	webhookDispatcher = NewWebhookDispatcher(
+		models.GetEnvDuration("WEBHOOK_TIMEOUT", 10*time.Second),
+		models.GetEnvInt("WEBHOOK_MAX_ATTEMPTS", 6),
+		models.GetEnvDuration("WEBHOOK_RETRY_DELAY", 30*time.Second),
+		os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true",
+	)
+	alertEngine = NewAlertEngine(webhookDispatcher)
+	if config, enabled := MQTTConfigFromEnv(); enabled {
+		mqttPublisher = NewMQTTPublisher(config)
+	}
+	ingestPrivacy = NewIngestPrivacy()
+	uploadService = NewUploadService(store, sessionActivity, liveBroker, alertEngine, webhookDispatcher, mqttPublisher, ingestPrivacy)
+}
+
+// CloseLiveStreams ends all live session streams, so the server does not wait for them when shutting down.
+func CloseLiveStreams() {
+	liveBroker.Close()
+}
+
+// Shutdown writes the state buffered by the handlers. It must be called after the server stopped serving requests.
+func Shutdown() {
+	sessionActivity.Close()
+	alertEngine.Close()
+	webhookDispatcher.Close()
+	if mqttPublisher != nil {
+		mqttPublisher.Close()
+	}
+}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/aafeher/gorque/middlewares"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testStore is the time-series store the handlers are wired to in the tests
+var testStore *models.MemoryTimeSeriesStore
+
+// testUserSequence makes the emails and upload keys of the test users unique
+var testUserSequence atomic.Int64
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	log.SetOutput(os.Stderr)
+
+	dir, err := os.MkdirTemp("", "gorque-handlers-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := models.OpenDatabase(filepath.Join(dir, "gorque.db")); err != nil {
+		log.Fatal(err)
+	}
+	middlewares.JWTKey = []byte("test-secret")
+
+	testStore = models.NewMemoryTimeSeriesStore()
+	Init(testStore)
+
+	code := m.Run()
+
+	Shutdown()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// newTestRouter returns a router serving the routes under test like main does
+func newTestRouter() *gin.Engine {
+	r := gin.New()
+	api := r.Group("/api")
+	api.Use(middlewares.JWTAuthMiddleware)
+	api.GET("/data", GetData)
+	api.POST("/device/:id/transfer", TransferDevice)
+	api.GET("/device-transfer", GetDeviceTransferList)
+	api.POST("/device-transfer/:id/accept", AcceptDeviceTransfer)
+	api.DELETE("/device-transfer/:id", DeleteDeviceTransfer)
+	r.GET("/upload", Upload)
+	r.GET("/share/:token", GetSharedSession)
+	return r
+}
+
+// testUser is a registered user with an upload key and a login token
+type testUser struct {
+	user      *models.User
+	uploadKey string
+	token     string
+}
+
+// newTestUser registers a user with an upload key and returns it with a login token
+func newTestUser(t *testing.T) testUser {
+	t.Helper()
+	n := testUserSequence.Add(1)
+
+	user := &models.User{Email: fmt.Sprintf("user%d@example.com", n), Password: "-", Name: fmt.Sprintf("User %d", n)}
+	if err := models.UserCreate(user); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	key := fmt.Sprintf("%stest%d", uploadKeyPrefix, n)
+	uploadKey := models.UploadKey{UserID: user.ID, Name: "test", KeyHash: models.UploadKeyHash(key), KeyPrefix: key}
+	if err := models.UploadKeyCreate(&uploadKey); err != nil {
+		t.Fatalf("UploadKeyCreate: %v", err)
+	}
+
+	token, err := middlewares.GenerateJWT(user.ID, time.Hour)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+
+	return testUser{user: user, uploadKey: key, token: token}
+}
+
+// serveTest serves a GET request with the query parameters, authorized by the token unless it is empty
+func serveTest(r *gin.Engine, path string, query url.Values, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodGet, path+"?"+query.Encode(), nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// serveTestJSON serves a request with the JSON encoded body, authorized by the token
+func serveTestJSON(t *testing.T, r *gin.Engine, method string, path string, body any, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// uploadTestSamples uploads count samples of the device session one second apart, starting at the session start.
+// The speed (kd) rises by 10 per sample from 0, the latitude (kff1006) by 0.001 from 47.5.
+func uploadTestSamples(t *testing.T, r *gin.Engine, user testUser, deviceID string, sessionID int64, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		query := url.Values{
+			"key":     {user.uploadKey},
+			"id":      {deviceID},
+			"session": {fmt.Sprint(sessionID)},
+			"time":    {fmt.Sprint(sessionID + int64(i)*1000)},
+			"v":       {"8"},
+			"kd":      {fmt.Sprint(i * 10)},
+			"kff1005": {"19.05"},
+			"kff1006": {fmt.Sprint(47.5 + float64(i)*0.001)},
+		}
+		if recorder := serveTest(r, "/upload", query, ""); recorder.Code != http.StatusOK {
+			t.Fatalf("upload %d: status %d: %s", i, recorder.Code, recorder.Body.String())
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// shareTokenPrefix marks gorque share tokens
+const shareTokenPrefix = "gqs_"
+
+// Limits of the share links
+const (
+	shareLinkMaxName   = 100
+	shareLinkMaxFields = 100
+)
+
+// shareLinkRequest is the JSON body of the share link create request
+type shareLinkRequest struct {
+	Name              string     `json:"name"`
+	Fields            []string   `json:"fields"`            // shared fields, all fields if empty
+	ExpiresAt         *time.Time `json:"expiresAt"`         // the link never expires if missing
+	ApplyPrivacyZones *bool      `json:"applyPrivacyZones"` // true if missing
+}
+
+// GetShareLinkList returns the share links of the authenticated user, including revoked and expired ones, newest first.
+// The session query parameter selects the links of a session. Tokens are never returned.
+func GetShareLinkList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	links, err := models.ShareLinkListGetByUserID(user.ID, c.Query("session"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shareLinks": links,
+	})
+}
+
+// CreateShareLink creates a read-only share link for the session identified by the ID in the request URL.
+// The link can be limited to the listed fields and an expiry time; the privacy zones of the user are applied unless disabled.
+// The plain token is part of the response only once; afterward only its hash is stored.
+func CreateShareLink(c *gin.Context) {
+	var body shareLinkRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	session, err := models.SessionGetBySessionID(c.Param("id"), user.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if len(body.Name) > shareLinkMaxName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name too long (max %d characters)", shareLinkMaxName)})
+		return
+	}
+	var fields []string
+	for _, field := range body.Fields {
+		if field = strings.TrimSpace(field); field != "" && !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	if len(fields) > shareLinkMaxFields {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("too many fields (max %d)", shareLinkMaxFields)})
+		return
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiry time must be in the future"})
+		return
+	}
+
+	token, err := generateShareToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "share token generation failed"})
+		return
+	}
+
+	link := models.ShareLink{
+		UserID:            user.ID,
+		SessionID:         session.SessionID,
+		Name:              body.Name,
+		TokenHash:         models.ShareLinkTokenHash(token),
+		TokenPrefix:       token[:len(shareTokenPrefix)+6],
+		Fields:            fields,
+		ApplyPrivacyZones: body.ApplyPrivacyZones == nil || *body.ApplyPrivacyZones,
+		ExpiresAt:         body.ExpiresAt,
+	}
+	if err := models.ShareLinkCreate(&link); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shareLink": link,
+		"token":     token,
+		"path":      "/share/" + token,
+	})
+}
+
+// RevokeShareLink revokes a share link of the authenticated user identified by the ID in the request URL.
+func RevokeShareLink(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid share link ID"})
+		return
+	}
+
+	if err := models.ShareLinkRevoke(user.ID, uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked successfully"})
+}
+
+// GetSharedSession returns the data of the session shared by the token in the request URL, without authentication.
+// The response has the shape of GetData, converted to the owner's preferred units, with the session details and statistics.
+// The fields, start, stop, window, max-points and method query parameters select the data like for GetData,
+// limited to the shared fields. The coordinates are only returned if the position is shared.
+// The statistics are null until they are calculated. An automatic title is omitted if it could reveal a place
+// the link hides, i.e. if privacy zones are applied or the position is not shared.
+func GetSharedSession(c *gin.Context) {
+	link, err := models.ShareLinkGetByToken(c.Param("token"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	owner, err := models.UserGetByID(link.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "share link not found"})
+		return
+	}
+	session, err := models.SessionGetBySessionID(link.SessionID, link.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	options, err := parseSessionDataOptions(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(link.Fields) > 0 {
+		if len(options.Fields) == 0 {
+			options.Fields = link.Fields
+		} else {
+			options.Fields = slices.DeleteFunc(options.Fields, func(field string) bool {
+				return !link.Shares(field)
+			})
+			if len(options.Fields) == 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "none of the fields is shared"})
+				return
+			}
+		}
+	}
+	if link.ApplyPrivacyZones {
+		if options.Privacy, err = models.PrivacyZoneListGetByUserID(owner.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	data, err := session.GetSessionData(timeSeriesStore, options)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !link.SharesPosition() {
+		data.Coords = [][]float64{}
+		data.Center = []float64{0, 0}
+	}
+
+	catalog, err := models.FieldCatalogGetBySessionID(session.SessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	fields := data.ApplyUnits(catalog, owner.UnitPreferences())
+
+	// The statistics are not calculated on behalf of an anonymous viewer, they are missing until the owner's are
+	var stats *models.SessionStat
+	stat, err := models.SessionStatGetBySessionID(session.SessionID)
+	if err == nil {
+		stat = link.FilterStats(stat).InUnits(owner.UnitPreferences())
+		stats = &stat
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := link.UpdateLastUsed(time.Now()); err != nil {
+		log.Printf("Failed to update last use of share link %d: %v", link.ID, err)
+	}
+
+	details := gin.H{
+		"startTime": session.StartTime,
+		"endTime":   session.EndTime,
+		"isActive":  session.IsActive,
+	}
+	// Automatic titles are named after the geofences the trip started and ended in
+	if !session.IsAutoTitle || (!link.ApplyPrivacyZones && link.SharesPosition()) {
+		details["title"] = session.Title
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session":   details,
+		"stats":     stats,
+		"expiresAt": link.ExpiresAt,
+		"series":    data.Series,
+		"fields":    fields,
+		"coords":    data.Coords,
+		"center":    data.Center,
+	})
+}
+
+// generateShareToken returns a new random share token.
+func generateShareToken() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return shareTokenPrefix + hex.EncodeToString(buf), nil
+}
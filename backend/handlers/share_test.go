@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"math"
+	"net/http"
+	"testing"
+)
+
+// sharedSessionTestResponse is the response of GetSharedSession
+type sharedSessionTestResponse struct {
+	Session map[string]any
+	Stats   *models.SessionStat
+}
+
+func TestGetSharedSession(t *testing.T) {
+	r := newTestRouter()
+	owner := newTestUser(t)
+	if err := owner.user.UpdateUnitPreferences(models.UnitPreferences{System: models.UnitSystemImperial}); err != nil {
+		t.Fatalf("UpdateUnitPreferences: %v", err)
+	}
+	uploadTestSamples(t, r, owner, "share-dev", 1714575600000, 4)
+
+	session, err := models.SessionGetBySessionID("1714575600000", owner.user.ID)
+	if err != nil {
+		t.Fatalf("SessionGetBySessionID: %v", err)
+	}
+	err = models.DBSQLite.Model(&session).Updates(map[string]any{"title": "Home → Work", "is_auto_title": true}).Error
+	if err != nil {
+		t.Fatalf("auto title: %v", err)
+	}
+
+	tests := []struct {
+		name              string
+		fields            []string
+		applyPrivacyZones bool
+		statsCalculated   bool
+		wantTitle         bool
+		wantStats         bool
+	}{
+		{name: "statistics not calculated", wantTitle: true},
+		{name: "statistics", statsCalculated: true, wantTitle: true, wantStats: true},
+		{name: "privacy zones hide the automatic title", applyPrivacyZones: true, statsCalculated: true, wantStats: true},
+		{name: "position not shared hides the automatic title", fields: []string{"kd"}, statsCalculated: true, wantStats: true},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.statsCalculated {
+				if _, err := session.CalculateStats(testStore); err != nil {
+					t.Fatalf("CalculateStats: %v", err)
+				}
+			}
+
+			token := fmt.Sprintf("%stest%d", shareTokenPrefix, i)
+			link := models.ShareLink{
+				UserID:            owner.user.ID,
+				SessionID:         session.SessionID,
+				TokenHash:         models.ShareLinkTokenHash(token),
+				Fields:            test.fields,
+				ApplyPrivacyZones: test.applyPrivacyZones,
+			}
+			if err := models.ShareLinkCreate(&link); err != nil {
+				t.Fatalf("ShareLinkCreate: %v", err)
+			}
+
+			recorder := serveTest(r, "/share/"+token, nil, "")
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d: %s", recorder.Code, recorder.Body.String())
+			}
+			var response sharedSessionTestResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("invalid response %s: %v", recorder.Body.String(), err)
+			}
+
+			if _, exists := response.Session["title"]; exists != test.wantTitle {
+				t.Errorf("title %v returned = %v, want %v", response.Session["title"], exists, test.wantTitle)
+			}
+			if !test.wantStats {
+				if response.Stats != nil {
+					t.Errorf("stats = %+v, want none", response.Stats)
+				}
+				if _, err := models.SessionStatGetBySessionID(session.SessionID); err == nil {
+					t.Error("statistics calculated for the viewer")
+				}
+				return
+			}
+			if response.Stats == nil {
+				t.Fatal("stats missing")
+			}
+			// 30 km/h in mph, without a coolant temperature
+			if math.Abs(response.Stats.MaxSpeed-30/1.609344) > 1e-9 {
+				t.Errorf("max speed = %v, want 30 km/h in mph", response.Stats.MaxSpeed)
+			}
+			if response.Stats.MaxTemperature != nil {
+				t.Errorf("max temperature = %v, want none", *response.Stats.MaxTemperature)
+			}
+		})
+	}
+}
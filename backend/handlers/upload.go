@@ -4,8 +4,8 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-// uploadService is the global instance of the upload service
-var uploadService = NewUploadService()
+// uploadService is the global instance of the upload service, created by Init
+var uploadService *UploadService
 
 // Upload handles file upload requests by delegating to the upload service
 // This function maintains backward compatibility while using the new refactored service architecture
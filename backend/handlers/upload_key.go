@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+)
+
+// uploadKeyPrefix marks gorque upload keys so they are recognisable in the Torque settings.
+const uploadKeyPrefix = "gq_"
+
+// GetUploadKeyList retrieves the upload keys of the authenticated user. Key values are never returned.
+func GetUploadKeyList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	uploadKeys, err := models.UploadKeyListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"uploadKeys": uploadKeys,
+	})
+}
+
+// CreateUploadKey generates a new upload key for the authenticated user, optionally bound to one of the user's devices.
+// The plain key is part of the response only once; afterward only its hash is stored.
+func CreateUploadKey(c *gin.Context) {
+	var body struct {
+		Name     string `json:"name"`
+		DeviceID string `json:"deviceId"`
+	}
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	if body.DeviceID != "" {
+		if _, err := models.DeviceGetByDeviceID(user.ID, body.DeviceID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "device not found"})
+			return
+		}
+	}
+
+	key, err := generateUploadKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "upload key generation failed"})
+		return
+	}
+
+	uploadKey := models.UploadKey{
+		UserID:    user.ID,
+		DeviceID:  body.DeviceID,
+		Name:      body.Name,
+		KeyHash:   models.UploadKeyHash(key),
+		KeyPrefix: key[:len(uploadKeyPrefix)+6],
+	}
+	if err := models.UploadKeyCreate(&uploadKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"uploadKey": uploadKey,
+		"key":       key,
+	})
+}
+
+// RevokeUploadKey revokes an upload key of the authenticated user identified by the ID in the request URL.
+func RevokeUploadKey(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid upload key ID"})
+		return
+	}
+
+	if err := models.UploadKeyRevoke(user.ID, uint(id)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "upload key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Upload key revoked successfully"})
+}
+
+// generateUploadKey returns a new random upload key.
+func generateUploadKey() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return uploadKeyPrefix + hex.EncodeToString(buf), nil
+}
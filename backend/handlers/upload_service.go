@@ -2,30 +2,62 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/aafeher/gorque/models"
 	"github.com/gin-gonic/gin"
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"gorm.io/gorm"
 	"log"
+	"math"
 	"net/http"
+	"net/url"
+	"os"
 	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
+var (
+	errUploadUnauthorized   = errors.New("invalid upload key")
+	errUploadDeviceMismatch = errors.New("device ID does not match upload key")
+)
+
+// uploadKeyLastUsedInterval is the resolution of the last use time of the upload keys.
+// Torque uploads about every second, the time is only written when the stored one is older.
+const uploadKeyLastUsedInterval = time.Minute
+
 type UploadService struct {
-	// dependencies here later
+	store          models.TimeSeriesStore
+	activity       *SessionActivity
+	live           *LiveBroker
+	alerts         *AlertEngine
+	webhooks       *WebhookDispatcher
+	mqtt           *MQTTPublisher // nil if MQTT is not configured
+	privacy        *IngestPrivacy
+	allowEmailAuth bool
 }
 
-func NewUploadService() *UploadService {
-	return &UploadService{}
+func NewUploadService(store models.TimeSeriesStore, activity *SessionActivity, live *LiveBroker, alerts *AlertEngine, webhooks *WebhookDispatcher, mqtt *MQTTPublisher, privacy *IngestPrivacy) *UploadService {
+	return &UploadService{
+		store:          store,
+		activity:       activity,
+		live:           live,
+		alerts:         alerts,
+		webhooks:       webhooks,
+		mqtt:           mqtt,
+		privacy:        privacy,
+		allowEmailAuth: os.Getenv("UPLOAD_ALLOW_EMAIL_AUTH") == "true",
+	}
 }
 
 type UploadRequest struct {
-	Data   models.UserDataRequest
-	Fields map[string]any
-	User   *models.User
+	Data      models.UserDataRequest
+	Fields    map[string]any
+	User      *models.User
+	SessionID string // session the upload belongs to, set by ensureSession
 }
 
 // ProcessUpload handles the main upload logic
@@ -33,12 +65,20 @@ func (s *UploadService) ProcessUpload(c *gin.Context) {
 	// Parse and validate request
 	request, err := s.parseRequest(c)
 	if err != nil {
+		if errors.Is(err, errUploadUnauthorized) || errors.Is(err, errUploadDeviceMismatch) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Ensure session exists
 	if err := s.ensureSession(request); err != nil {
+		if errors.Is(err, errUploadDeviceMismatch) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -60,16 +100,16 @@ func (s *UploadService) parseRequest(c *gin.Context) (*UploadRequest, error) {
 		return nil, err
 	}
 
-	if data.Email == "" || data.ID == "" || data.Session == 0 {
+	if (data.Email == "" && data.Key == "") || data.ID == "" || data.Session == 0 {
 		return nil, fmt.Errorf("missing required parameters")
 	}
 
-	user, err := models.UserGetByEmail(data.Email)
+	user, err := s.authenticate(data)
 	if err != nil {
-		return nil, fmt.Errorf("user not found")
+		return nil, err
 	}
 
-	fields := s.extractFields(data)
+	fields := s.extractFields(c.Request.URL.Query())
 
 	return &UploadRequest{
 		Data:   data,
@@ -78,41 +118,131 @@ func (s *UploadService) parseRequest(c *gin.Context) (*UploadRequest, error) {
 	}, nil
 }
 
-// ensureSession creates or finds the session
+// authenticate resolves the uploading user from the upload key sent in the key parameter or,
+// as configured in the Torque "User Email" setting, in the eml parameter.
+// Plain email authentication is only accepted when UPLOAD_ALLOW_EMAIL_AUTH is enabled.
+func (s *UploadService) authenticate(data models.UserDataRequest) (*models.User, error) {
+	key := data.Key
+	if key == "" {
+		key = data.Email
+	}
+
+	uploadKey, err := models.UploadKeyGetByKey(key)
+	if err != nil {
+		if !s.allowEmailAuth || data.Email == "" {
+			return nil, errUploadUnauthorized
+		}
+		user, err := models.UserGetByEmail(data.Email)
+		if err != nil {
+			return nil, errUploadUnauthorized
+		}
+		if err := s.checkDeviceOwner(data.ID, user.ID); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	if uploadKey.DeviceID != "" && uploadKey.DeviceID != data.ID {
+		return nil, errUploadDeviceMismatch
+	}
+
+	if err := s.checkDeviceOwner(data.ID, uploadKey.UserID); err != nil {
+		return nil, err
+	}
+
+	user, err := models.UserGetByID(uploadKey.UserID)
+	if err != nil {
+		return nil, errUploadUnauthorized
+	}
+
+	if now := time.Now(); uploadKey.LastUsedAt == nil || now.Sub(*uploadKey.LastUsedAt) >= uploadKeyLastUsedInterval {
+		if err := uploadKey.UpdateLastUsed(now); err != nil {
+			log.Printf("Upload key update error: %v", err)
+		}
+	}
+
+	return user, nil
+}
+
+// checkDeviceOwner refuses uploads for a device ID that is already registered to another user
+func (s *UploadService) checkDeviceOwner(deviceID string, userID uint) error {
+	device, err := models.DeviceFindByDeviceID(deviceID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if device.UserID != userID {
+		return errUploadDeviceMismatch
+	}
+	return nil
+}
+
+// ensureSession creates or finds the session and publishes the start of new sessions to the webhooks.
+// Sessions of other devices or users are not touched, their IDs are rejected with errUploadDeviceMismatch.
+// Uploads of a Torque session that was split or merged are routed to the session covering their time.
 func (s *UploadService) ensureSession(request *UploadRequest) error {
 	dataTime := time.Unix(request.Data.Time/1000, (request.Data.Time%1000)*int64(time.Millisecond))
-	sessionID := strconv.FormatInt(request.Data.Session, 10)
 
-	_, _, err := models.SessionFindOrCreate(
+	sessionID, err := models.SessionAliasResolve(strconv.FormatInt(request.Data.Session, 10), request.Data.ID, request.User.ID, dataTime)
+	if err != nil {
+		return err
+	}
+	request.SessionID = sessionID
+
+	session, created, err := models.SessionFindOrCreate(
 		sessionID,
 		request.Data.ID,
 		request.User.ID,
 		request.Data.V,
 		dataTime,
 	)
+	if errors.Is(err, models.ErrSessionOwner) {
+		return errUploadDeviceMismatch
+	}
+	if err != nil {
+		return err
+	}
+
+	if created {
+		s.webhooks.SessionStarted(session)
+	}
+	return nil
+}
 
-	return err
+// dataFieldKeyPattern matches the keys of data fields, a k followed by the hex PID, e.g. kd, kff1001 or k22f40c
+var dataFieldKeyPattern = regexp.MustCompile(`^k[0-9a-f]+$`)
+
+// isDataFieldKey checks if a (lowercase) query parameter is a data field
+func isDataFieldKey(key string) bool {
+	return dataFieldKeyPattern.MatchString(key)
 }
 
-// extractFields extracts all relevant fields from the request data using reflection
-func (s *UploadService) extractFields(data models.UserDataRequest) map[string]any {
+// extractFields extracts all non-core parameters of the request.
+// Data fields are accepted for any PID and must be numeric, invalid values are dropped.
+// Other parameters, e.g. notices, profile values and field definitions, are kept as strings.
+func (s *UploadService) extractFields(query url.Values) map[string]any {
 	fields := map[string]any{}
-	t := reflect.TypeOf(data)
-	v := reflect.ValueOf(data)
 
-	for i := 0; i < t.NumField(); i++ {
-		field := t.Field(i)
-		if tag := field.Tag.Get("form"); tag != "" {
-			// Skip core fields that are handled separately
-			if s.isCoreField(tag) {
-				continue
-			}
+	for name, values := range query {
+		// Skip core fields that are handled separately
+		if len(values) == 0 || values[0] == "" || s.isCoreField(name) {
+			continue
+		}
 
-			fieldValue := v.Field(i)
-			if value := s.extractFieldValue(fieldValue); value != nil {
-				fields[tag] = value
+		value := values[0]
+		if key := strings.ToLower(name); isDataFieldKey(key) {
+			number, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(number) || math.IsInf(number, 0) {
+				log.Printf("Invalid value for %s dropped: %q", name, value)
+				continue
 			}
+			fields[key] = number
+			continue
 		}
+
+		fields[name] = value
 	}
 
 	return fields
@@ -120,7 +250,7 @@ func (s *UploadService) extractFields(data models.UserDataRequest) map[string]an
 
 // isCoreField checks if a field is a core field that should be skipped
 func (s *UploadService) isCoreField(tag string) bool {
-	coreFields := []string{"eml", "v", "session", "id", "time", "lat", "lon"}
+	coreFields := []string{"eml", "key", "v", "session", "id", "time", "lat", "lon"}
 	for _, coreField := range coreFields {
 		if tag == coreField {
 			return true
@@ -129,24 +259,6 @@ func (s *UploadService) isCoreField(tag string) bool {
 	return false
 }
 
-// extractFieldValue extracts the actual value from a reflect.Value
-func (s *UploadService) extractFieldValue(fieldValue reflect.Value) any {
-	if fieldValue.Kind() == reflect.Ptr && !fieldValue.IsNil() {
-		if fieldValue.Elem().Kind() == reflect.Float64 {
-			return fieldValue.Elem().Float()
-		} else if fieldValue.Elem().Kind() == reflect.Int64 {
-			return fieldValue.Elem().Int()
-		} else if fieldValue.Elem().Kind() == reflect.String {
-			return fieldValue.Elem().String()
-		}
-	} else if fieldValue.Kind() == reflect.String && fieldValue.String() != "" {
-		return fieldValue.String()
-	} else if fieldValue.Kind() == reflect.Int64 && fieldValue.Int() != 0 {
-		return fieldValue.Int()
-	}
-	return nil
-}
-
 // determineCallType determines what type of call this is based on the fields
 func (s *UploadService) determineCallType(fields map[string]any) string {
 	// Check for notice type
@@ -181,7 +293,7 @@ func (s *UploadService) determineCallType(fields map[string]any) string {
 
 	// Check for actual data
 	for key := range fields {
-		if strings.HasPrefix(key, "kff") {
+		if isDataFieldKey(key) {
 			return "data"
 		}
 	}
@@ -207,45 +319,102 @@ func (s *UploadService) handleByType(c *gin.Context, request *UploadRequest, cal
 	}
 }
 
-// handleNoticeData processes notice-related data
+// handleNoticeData stores a notice in the event log of the session and records the trouble codes it mentions,
+// publishing the codes reported for the first time to the webhooks. A notice uploaded again is recorded once.
 func (s *UploadService) handleNoticeData(request *UploadRequest) error {
 	_, _, err := models.DeviceFindOrCreate(request.Data.ID, request.User.ID)
 	if err != nil {
 		log.Printf("Device creation error: %v", err)
 		return err
 	}
+
+	if request.Data.Notice == "" && request.Data.NoticeClass == "" {
+		return nil
+	}
+
+	eventTime := time.Now()
+	if request.Data.Time != 0 {
+		eventTime = time.UnixMilli(request.Data.Time)
+	}
+
+	event := models.SessionEvent{
+		SessionID: request.SessionID,
+		UserID:    request.User.ID,
+		DeviceID:  request.Data.ID,
+		Time:      eventTime,
+		Class:     request.Data.NoticeClass,
+		Text:      request.Data.Notice,
+	}
+	dtcs, recorded, err := models.SessionEventRecord(&event)
+	if err != nil {
+		log.Printf("Session event record error: %v", err)
+		return err
+	}
+	if !recorded {
+		log.Printf("Session event of session %s at %s already recorded", event.SessionID, event.Time)
+	}
+	for _, dtc := range dtcs {
+		s.webhooks.DTCNew(dtc, event.SessionID)
+	}
+
 	return nil
 }
 
-// handleProfileData processes profile-related data and updates device information
+// handleProfileData stores a snapshot of the vehicle profile linked to the session, assigns both to the vehicle
+// matching the profile name, and updates the profile of the device if the snapshot is the latest one
 func (s *UploadService) handleProfileData(request *UploadRequest) error {
-	profile := models.Device{
-		DeviceID: request.Data.ID,
-		UserID:   request.User.ID,
+	if _, _, err := models.DeviceFindOrCreate(request.Data.ID, request.User.ID); err != nil {
+		log.Printf("Device creation error: %v", err)
+		return err
 	}
 
-	// Map fields to device profile using reflection
-	if err := s.mapFieldsToProfile(&profile, request.Fields); err != nil {
+	var data models.VehicleProfileData
+	if err := s.mapFieldsToProfile(&data, request.Fields); err != nil {
 		return err
 	}
 
-	if err := models.DeviceCreateOrUpdate(&profile); err != nil {
-		log.Printf("Profile creation/update error: %v", err)
+	profileTime := time.Now()
+	if request.Data.Time != 0 {
+		profileTime = time.UnixMilli(request.Data.Time)
+	}
+
+	profile := models.VehicleProfile{
+		DeviceID:           request.Data.ID,
+		UserID:             request.User.ID,
+		SessionID:          request.SessionID,
+		Version:            request.Data.V,
+		Time:               profileTime,
+		VehicleProfileData: data,
+	}
+	latest, err := models.VehicleProfileCreate(&profile)
+	if err != nil {
+		log.Printf("Vehicle profile creation error: %v", err)
+		return err
+	}
+	if _, err := models.VehicleAssignProfile(&profile); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Printf("Vehicle assignment error: %v", err)
 		return err
 	}
+	if !latest {
+		return nil
+	}
 
-	// Update session with vehicle profile
-	//sessionID := strconv.FormatInt(request.Data.Session, 10)
-	//if err := models.SessionUpdateVehicleProfile(sessionID, profile.ID); err != nil {
-	//	log.Printf("Session update error: %v", err)
-	//	return err
-	//}
+	device := models.Device{
+		DeviceID:           request.Data.ID,
+		UserID:             request.User.ID,
+		Version:            request.Data.V,
+		VehicleProfileData: data,
+	}
+	if err := models.DeviceCreateOrUpdate(&device); err != nil {
+		log.Printf("Profile creation/update error: %v", err)
+		return err
+	}
 
 	return nil
 }
 
-// mapFieldsToProfile maps request fields to device profile using reflection
-func (s *UploadService) mapFieldsToProfile(profile *models.Device, fields map[string]any) error {
+// mapFieldsToProfile maps request fields to the vehicle profile using reflection
+func (s *UploadService) mapFieldsToProfile(profile *models.VehicleProfileData, fields map[string]any) error {
 	t := reflect.TypeOf(*profile)
 	v := reflect.ValueOf(profile).Elem()
 
@@ -263,7 +432,8 @@ func (s *UploadService) setFieldValue(t reflect.Type, v reflect.Value, key strin
 	for i := 0; i < t.NumField(); i++ {
 		field := t.Field(i)
 		if tag := field.Tag.Get("gorm"); tag != "" {
-			if strings.Contains(tag, "column:"+key) {
+			// Match the whole column name, profileVe must not match profileVehicleType
+			if slices.Contains(strings.Split(tag, ";"), "column:"+key) {
 				fieldValue := v.Field(i)
 				if fieldValue.CanSet() {
 					return s.assignValue(fieldValue, value)
@@ -274,30 +444,38 @@ func (s *UploadService) setFieldValue(t reflect.Type, v reflect.Value, key strin
 	return nil
 }
 
-// assignValue assigns a value to a reflect.Value based on its type
+// assignValue parses a profile value sent by Torque and assigns it to a reflect.Value based on its type
 func (s *UploadService) assignValue(fieldValue reflect.Value, value any) error {
+	valueStr, ok := value.(string)
+	if !ok {
+		return fmt.Errorf("invalid value type for profile field")
+	}
+
 	switch fieldValue.Kind() {
 	case reflect.Float64:
-		if floatVal, ok := value.(float64); ok {
-			fieldValue.SetFloat(floatVal)
+		floatVal, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil {
+			return err
 		}
+		fieldValue.SetFloat(floatVal)
 	case reflect.Int64:
-		if intVal, ok := value.(int64); ok {
-			fieldValue.SetInt(intVal)
+		// Integer values like the odometer may be sent with decimals
+		floatVal, err := strconv.ParseFloat(valueStr, 64)
+		if err != nil {
+			return err
 		}
+		fieldValue.SetInt(int64(floatVal))
 	case reflect.String:
-		if stringVal, ok := value.(string); ok {
-			fieldValue.SetString(stringVal)
-		}
+		fieldValue.SetString(valueStr)
 	default:
-		panic("unhandled default case")
+		return fmt.Errorf("unsupported profile field type: %s", fieldValue.Kind())
 	}
 	return nil
 }
 
 // handleDefaultUnits processes default unit definitions
 func (s *UploadService) handleDefaultUnits(request *UploadRequest) error {
-	sessionID := strconv.FormatInt(request.Data.Session, 10)
+	sessionID := request.SessionID
 
 	for key, value := range request.Fields {
 		if !strings.HasPrefix(key, "defaultUnit") {
@@ -337,7 +515,7 @@ func (s *UploadService) processDefaultUnit(sessionID string, userID uint, fieldK
 
 // handleFieldDefinitions processes field definition data
 func (s *UploadService) handleFieldDefinitions(request *UploadRequest) error {
-	sessionID := strconv.FormatInt(request.Data.Session, 10)
+	sessionID := request.SessionID
 	fieldKeysMap := make(map[string]models.SessionField)
 
 	// Group fields by base key
@@ -404,54 +582,117 @@ func (s *UploadService) setSessionFieldValue(field *models.SessionField, fieldTy
 	return nil
 }
 
-// handleActualData processes actual sensor data and writes to InfluxDB
+// uploadSample is a sample of a session accepted by the upload path
+type uploadSample struct {
+	User      *models.User
+	DeviceID  string
+	SessionID string
+	Version   int
+	Time      time.Time
+	Fields    map[string]any // data fields keyed as uploaded by Torque, e.g. kd or kff1001
+	Lat       float64        // position parameters, used when the GPS fields are missing
+	Lon       float64
+}
+
+// handleActualData processes actual sensor data, queues it for the time-series store, evaluates the alert rules on it
+// and publishes it to MQTT
 func (s *UploadService) handleActualData(c *gin.Context, request *UploadRequest) error {
 	dataFields := s.extractDataFields(request.Fields)
 	if len(dataFields) == 0 {
 		return nil
 	}
 
-	dataTime := time.Unix(request.Data.Time/1000, (request.Data.Time%1000)*int64(time.Millisecond))
-	sessionID := strconv.FormatInt(request.Data.Session, 10)
-
-	// Create InfluxDB point
-	point := influxdb2.NewPoint(
-		"gorque_data",
-		map[string]string{
-			"id":      request.Data.ID,
-			"session": sessionID,
-			"v":       strconv.FormatInt(int64(request.Data.V), 10),
-			"eml":     request.Data.Email,
-		},
-		dataFields,
-		dataTime,
-	)
-
-	// Write to InfluxDB
-	if err := models.DBInfluxWriteAPI.WritePoint(context.Background(), point); err != nil {
-		log.Printf("Influx write error: %v", err)
+	sample := uploadSample{
+		User:      request.User,
+		DeviceID:  request.Data.ID,
+		SessionID: request.SessionID,
+		Version:   request.Data.V,
+		Time:      time.Unix(request.Data.Time/1000, (request.Data.Time%1000)*int64(time.Millisecond)),
+		Fields:    dataFields,
+		Lat:       request.Data.Lat,
+		Lon:       request.Data.Lon,
+	}
+	if err := s.acceptSample(&sample); err != nil {
 		return err
 	}
 
-	if err := models.DBInfluxWriteAPI.Flush(context.Background()); err != nil {
-		log.Printf("Influx flush error: %v", err)
+	s.alerts.Evaluate(sample)
+	if s.mqtt != nil {
+		s.mqtt.Publish(sample)
+	}
+	return nil
+}
+
+// acceptSample masks the position of a sample within the ingest privacy zones of the user, queues it for
+// the time-series store and passes it on to the session activity and the live streams
+func (s *UploadService) acceptSample(sample *uploadSample) error {
+	if err := s.privacy.Mask(sample); err != nil {
+		log.Printf("Privacy zone lookup error for user %d: %v", sample.User.ID, err)
 		return err
 	}
+	if len(sample.Fields) == 0 {
+		// Only the masked position was uploaded
+		return nil
+	}
+
+	point := models.TimeSeriesPoint{
+		DeviceID:  sample.DeviceID,
+		SessionID: sample.SessionID,
+		Tags: map[string]string{
+			"v":   strconv.Itoa(sample.Version),
+			"eml": sample.User.Email,
+		},
+		Fields: sample.Fields,
+		Time:   sample.Time,
+	}
 
-	// Update session activity
-	if err := models.SessionUpdateActivityAndRecords(sessionID, dataTime); err != nil {
-		log.Printf("Session update error: %v", err)
+	if err := s.store.Write(context.Background(), point); err != nil {
+		log.Printf("Time-series write error: %v", err)
 		return err
 	}
 
+	// Session activity is written periodically rather than per sample
+	s.activity.Record(sample.SessionID, sample.Time)
+
+	topic := LiveTopic{UserID: sample.User.ID, DeviceID: sample.DeviceID, SessionID: sample.SessionID}
+	if s.live.HasSubscribers(topic) {
+		s.live.Publish(topic, s.liveSample(*sample))
+	}
+
 	return nil
 }
 
-// extractDataFields extracts only the data fields (those starting with 'k')
+// liveSample builds the sample pushed to live subscribers from the GPS position and the data fields.
+// The subscribers pick the variables of their dashboard, which may differ per user and device.
+func (s *UploadService) liveSample(sample uploadSample) LiveSample {
+	live := LiveSample{
+		Time:   sample.Time.UnixMilli(),
+		Values: make(map[string]float64),
+	}
+
+	for key, value := range sample.Fields {
+		if number, ok := value.(float64); ok {
+			live.Values[string(models.UserDataCodeFromFieldKey(key))] = number
+		}
+	}
+
+	// The GPS fields are preferred, the lat and lon parameters are only sent by some Torque versions
+	lat, latOK := sample.Fields["kff1006"].(float64)
+	lon, lonOK := sample.Fields["kff1005"].(float64)
+	if latOK && lonOK {
+		live.Lat, live.Lon = &lat, &lon
+	} else if sample.Lat != 0 || sample.Lon != 0 {
+		live.Lat, live.Lon = &sample.Lat, &sample.Lon
+	}
+
+	return live
+}
+
+// extractDataFields extracts only the data fields (k followed by the hex PID)
 func (s *UploadService) extractDataFields(fields map[string]any) map[string]any {
 	dataFields := map[string]any{}
 	for key, value := range fields {
-		if strings.HasPrefix(key, "k") {
+		if isDataFieldKey(key) {
 			dataFields[key] = value
 		}
 	}
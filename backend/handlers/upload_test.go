@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"github.com/aafeher/gorque/models"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestUpload(t *testing.T) {
+	r := newTestRouter()
+	owner := newTestUser(t)
+	other := newTestUser(t)
+
+	// The profile upload registers the device to the owner
+	profile := url.Values{
+		"key": {owner.uploadKey}, "id": {"upload-dev"}, "session": {"1714564800000"}, "time": {"1714564800000"},
+		"v": {"8"}, "profileName": {"Car"},
+	}
+	if recorder := serveTest(r, "/upload", profile, ""); recorder.Code != http.StatusOK {
+		t.Fatalf("profile upload: status %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	tests := []struct {
+		name       string
+		query      url.Values
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "data",
+			query:      url.Values{"key": {owner.uploadKey}, "id": {"upload-dev"}, "session": {"1714564800000"}, "time": {"1714564801000"}, "v": {"8"}, "kd": {"42"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "OK!",
+		},
+		{
+			name:       "key in the email parameter",
+			query:      url.Values{"eml": {owner.uploadKey}, "id": {"upload-dev"}, "session": {"1714564800000"}, "time": {"1714564802000"}, "v": {"8"}, "kd": {"43"}},
+			wantStatus: http.StatusOK,
+			wantBody:   "OK!",
+		},
+		{
+			name:       "missing session",
+			query:      url.Values{"key": {owner.uploadKey}, "id": {"upload-dev"}, "time": {"1714564801000"}, "kd": {"42"}},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `{"error":"missing required parameters"}`,
+		},
+		{
+			name:       "invalid key",
+			query:      url.Values{"key": {"gq_invalid"}, "id": {"upload-dev"}, "session": {"1714564800000"}, "time": {"1714564801000"}, "kd": {"42"}},
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `{"error":"invalid upload key"}`,
+		},
+		{
+			name:       "device of another user",
+			query:      url.Values{"key": {other.uploadKey}, "id": {"upload-dev"}, "session": {"1714564800000"}, "time": {"1714564801000"}, "kd": {"42"}},
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `{"error":"device ID does not match upload key"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := serveTest(r, "/upload", test.query, "")
+			if recorder.Code != test.wantStatus {
+				t.Errorf("status = %d, want %d", recorder.Code, test.wantStatus)
+			}
+			if body := recorder.Body.String(); body != test.wantBody {
+				t.Errorf("body = %s, want %s", body, test.wantBody)
+			}
+		})
+	}
+
+	// Only the accepted data uploads are stored
+	start := time.UnixMilli(1714564800000)
+	points, err := models.QueryAll(context.Background(), testStore, models.TimeSeriesQuery{
+		DeviceID: "upload-dev", SessionID: "1714564800000", Start: start, Stop: start.Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(points) != 2 {
+		t.Fatalf("stored points = %d, want 2", len(points))
+	}
+	for i, want := range []float64{42, 43} {
+		if got := points[i].Fields["kd"]; got != want {
+			t.Errorf("point %d: kd = %v, want %v", i, got, want)
+		}
+	}
+
+	session, err := models.SessionGetBySessionID("1714564800000", owner.user.ID)
+	if err != nil {
+		t.Fatalf("session of the owner not created: %v", err)
+	}
+	if session.DeviceID != "upload-dev" {
+		t.Errorf("session device = %s, want upload-dev", session.DeviceID)
+	}
+}
+
+func TestUploadNoticeRecordedOnce(t *testing.T) {
+	r := newTestRouter()
+	user := newTestUser(t)
+
+	notice := url.Values{
+		"key": {user.uploadKey}, "id": {"notice-dev"}, "session": {"1714582800000"}, "time": {"1714582801000"},
+		"v": {"8"}, "notice": {"Fault codes: P0301 P0420"}, "noticeClass": {"dtc"},
+	}
+	// Torque uploads the notice again if it did not get the response
+	for i := 0; i < 2; i++ {
+		if recorder := serveTest(r, "/upload", notice, ""); recorder.Code != http.StatusOK {
+			t.Fatalf("notice upload %d: status %d: %s", i, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	events, err := models.SessionEventListGetBySessionID("1714582800000", user.user.ID)
+	if err != nil {
+		t.Fatalf("SessionEventListGetBySessionID: %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("events = %d, want 1", len(events))
+	}
+
+	dtcs, err := models.DeviceDTCListGetByDeviceID(user.user.ID, "notice-dev")
+	if err != nil {
+		t.Fatalf("DeviceDTCListGetByDeviceID: %v", err)
+	}
+	if len(dtcs) != 2 {
+		t.Fatalf("trouble codes = %d, want 2", len(dtcs))
+	}
+	for _, dtc := range dtcs {
+		if dtc.Count != 1 {
+			t.Errorf("%s count = %d, want 1", dtc.Code, dtc.Count)
+		}
+	}
+}
+
+func TestUploadSessionOfAnotherUser(t *testing.T) {
+	r := newTestRouter()
+	owner := newTestUser(t)
+	other := newTestUser(t)
+	uploadTestSamples(t, r, owner, "owner-dev", 1714579200000, 2)
+
+	tests := []struct {
+		name     string
+		user     testUser
+		deviceID string
+	}{
+		{name: "key of another user", user: other, deviceID: "other-dev"},
+		{name: "another device of the owner", user: owner, deviceID: "owner-dev-2"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := url.Values{
+				"key": {test.user.uploadKey}, "id": {test.deviceID}, "session": {"1714579200000"}, "time": {"1714579205000"},
+				"v": {"8"}, "kd": {"99"},
+			}
+			recorder := serveTest(r, "/upload", query, "")
+			if recorder.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d", recorder.Code, http.StatusUnauthorized)
+			}
+			if want := `{"error":"device ID does not match upload key"}`; recorder.Body.String() != want {
+				t.Errorf("body = %s, want %s", recorder.Body.String(), want)
+			}
+
+			start := time.UnixMilli(1714579200000)
+			points, err := models.QueryAll(context.Background(), testStore, models.TimeSeriesQuery{
+				DeviceID: test.deviceID, SessionID: "1714579200000", Start: start, Stop: start.Add(time.Minute),
+			})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if len(points) != 0 {
+				t.Errorf("points stored in the session of the owner = %d, want 0", len(points))
+			}
+		})
+	}
+
+	sessionActivity.flush()
+	session, err := models.SessionGetBySessionID("1714579200000", owner.user.ID)
+	if err != nil {
+		t.Fatalf("SessionGetBySessionID: %v", err)
+	}
+	if session.DeviceID != "owner-dev" || session.TotalRecords != 2 || !session.EndTime.Equal(time.UnixMilli(1714579201000)) {
+		t.Errorf("session of the owner changed: device %s, %d records, end %v", session.DeviceID, session.TotalRecords, session.EndTime)
+	}
+}
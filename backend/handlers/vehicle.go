@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of the vehicle details
+const (
+	vehicleMaxName = 100
+	vehicleMaxMake = 50
+)
+
+// vinPattern matches vehicle identification numbers, 17 characters without I, O and Q
+var vinPattern = regexp.MustCompile(`^[A-HJ-NPR-Z0-9]{17}$`)
+
+// vehicleRequest is the JSON body of the vehicle create and update requests
+type vehicleRequest struct {
+	Name          string `json:"name"`
+	TorqueProfile string `json:"torqueProfile"`
+	VIN           string `json:"vin"`
+	Make          string `json:"make"`
+	Model         string `json:"model"`
+	Year          int    `json:"year"`
+}
+
+// GetVehicleList returns the vehicles of the authenticated user ordered by name.
+func GetVehicleList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	vehicles, err := models.VehicleListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vehicles": vehicles,
+	})
+}
+
+// GetVehicle returns the vehicle identified by the ID in the request URL, with the trip statistics of its sessions
+// summarized across all devices in the user's preferred units.
+func GetVehicle(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	vehicle, ok := getVehicle(c, user.ID)
+	if !ok {
+		return
+	}
+
+	stats, err := vehicle.GetStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vehicle": vehicle,
+		"stats":   stats.InUnits(user.UnitPreferences()),
+	})
+}
+
+// CreateVehicle creates a vehicle of the authenticated user from the JSON body.
+// Sessions uploaded with the Torque profile given in torqueProfile are assigned to it.
+func CreateVehicle(c *gin.Context) {
+	var body vehicleRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	vehicle := models.Vehicle{UserID: user.ID}
+	if !applyVehicleRequest(c, &vehicle, body) {
+		return
+	}
+
+	if err := models.VehicleCreate(&vehicle); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"vehicle": vehicle,
+	})
+}
+
+// UpdateVehicle replaces the details of the vehicle identified by the ID in the request URL with the JSON body.
+func UpdateVehicle(c *gin.Context) {
+	var body vehicleRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	vehicle, ok := getVehicle(c, user.ID)
+	if !ok {
+		return
+	}
+	if !applyVehicleRequest(c, &vehicle, body) {
+		return
+	}
+
+	if err := models.VehicleUpdateDetails(&vehicle); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"vehicle": vehicle,
+	})
+}
+
+// DeleteVehicle deletes the vehicle identified by the ID in the request URL. Its sessions are kept unassigned.
+func DeleteVehicle(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	vehicle, ok := getVehicle(c, user.ID)
+	if !ok {
+		return
+	}
+
+	if err := vehicle.Delete(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Vehicle deleted successfully"})
+}
+
+// GetVehicleSessions returns the sessions of the vehicle identified by the ID in the request URL, recorded with
+// any device, like the session list of a device. The same filter query parameters are accepted.
+func GetVehicleSessions(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	vehicle, ok := getVehicle(c, user.ID)
+	if !ok {
+		return
+	}
+
+	filter, ok := parseSessionFilter(c)
+	if !ok {
+		return
+	}
+
+	sessions, err := vehicle.GetSessions(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	respondSessionList(c, user, sessions)
+}
+
+// GetVehicleProfiles returns the vehicle profile snapshots of the vehicle identified by the ID in the request URL,
+// sent by any device, in chronological order. The optional from and to query parameters limit the time range.
+func GetVehicleProfiles(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	vehicle, ok := getVehicle(c, user.ID)
+	if !ok {
+		return
+	}
+
+	from, to, ok := parseTimeRange(c)
+	if !ok {
+		return
+	}
+
+	profiles, err := models.VehicleProfileListGetByVehicleID(user.ID, vehicle.ID, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles,
+	})
+}
+
+// getVehicle retrieves the vehicle of the user identified by the ID in the request URL,
+// responding with an error if it is not found
+func getVehicle(c *gin.Context, userID uint) (models.Vehicle, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid vehicle ID"})
+		return models.Vehicle{}, false
+	}
+
+	vehicle, err := models.VehicleGetByID(uint(id), userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "vehicle not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return vehicle, false
+	}
+	return vehicle, true
+}
+
+// applyVehicleRequest validates the vehicle details of a request and sets them on the vehicle,
+// responding with an error if they are invalid. The Torque profile must not be used by another vehicle of the user.
+func applyVehicleRequest(c *gin.Context, vehicle *models.Vehicle, body vehicleRequest) bool {
+	body.Name = strings.TrimSpace(body.Name)
+	body.TorqueProfile = strings.TrimSpace(body.TorqueProfile)
+	body.VIN = strings.ToUpper(strings.TrimSpace(body.VIN))
+	body.Make = strings.TrimSpace(body.Make)
+	body.Model = strings.TrimSpace(body.Model)
+
+	if body.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return false
+	}
+	if len(body.Name) > vehicleMaxName || len(body.TorqueProfile) > vehicleMaxName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name too long (max %d characters)", vehicleMaxName)})
+		return false
+	}
+	if len(body.Make) > vehicleMaxMake || len(body.Model) > vehicleMaxMake {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("make or model too long (max %d characters)", vehicleMaxMake)})
+		return false
+	}
+	if body.VIN != "" && !vinPattern.MatchString(body.VIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid VIN, 17 letters and digits expected"})
+		return false
+	}
+	if body.Year != 0 && (body.Year < 1886 || body.Year > time.Now().Year()+1) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid year"})
+		return false
+	}
+
+	if body.TorqueProfile != "" {
+		other, err := models.VehicleGetByTorqueProfile(vehicle.UserID, body.TorqueProfile)
+		if err == nil && other.ID != vehicle.ID {
+			c.JSON(http.StatusConflict, gin.H{"error": "Torque profile is already used by another vehicle"})
+			return false
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+
+	vehicle.Name = body.Name
+	vehicle.TorqueProfile = body.TorqueProfile
+	vehicle.VIN = body.VIN
+	vehicle.Make = body.Make
+	vehicle.Model = body.Model
+	vehicle.Year = body.Year
+	return true
+}
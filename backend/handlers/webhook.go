@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Limits of the webhooks and the delivery log
+const (
+	webhookMaxName             = 100
+	webhookMaxURL              = 2048
+	webhookDeliveryDefaultList = 50
+	webhookDeliveryMaxList     = 500
+)
+
+// webhookSecretPrefix marks gorque webhook secrets
+const webhookSecretPrefix = "whsec_"
+
+// webhookRequest is the JSON body of the webhook create and update requests
+type webhookRequest struct {
+	Name    string   `json:"name"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Enabled *bool    `json:"enabled"` // defaults to true
+}
+
+// GetWebhookList returns the webhooks of the authenticated user with the events they can subscribe to.
+// Secrets are never returned.
+func GetWebhookList(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	webhooks, err := models.WebhookListGetByUserID(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhooks": webhooks,
+		"events":   models.WebhookEvents,
+	})
+}
+
+// CreateWebhook creates a webhook of the authenticated user from the JSON body.
+// The events are posted as JSON with the HMAC-SHA256 of the body, keyed with the webhook secret,
+// in the X-Gorque-Signature header. The secret is part of the response only once.
+func CreateWebhook(c *gin.Context) {
+	var body webhookRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	webhook := models.Webhook{UserID: user.ID}
+	if !applyWebhookRequest(c, &webhook, body) {
+		return
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "webhook secret generation failed"})
+		return
+	}
+	webhook.Secret = secret
+
+	if err := models.WebhookCreate(&webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+// UpdateWebhook replaces the settings of the webhook identified by the ID in the request URL with the JSON body.
+// The secret is kept.
+func UpdateWebhook(c *gin.Context) {
+	var body webhookRequest
+	if err := c.BindJSON(&body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "bad request"})
+		return
+	}
+
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	webhook, ok := getWebhook(c, user.ID)
+	if !ok {
+		return
+	}
+	if !applyWebhookRequest(c, &webhook, body) {
+		return
+	}
+
+	if err := models.WebhookUpdate(&webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"webhook": webhook,
+	})
+}
+
+// DeleteWebhook deletes the webhook identified by the ID in the request URL with its delivery log.
+// Pending deliveries are not attempted anymore.
+func DeleteWebhook(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	webhook, ok := getWebhook(c, user.ID)
+	if !ok {
+		return
+	}
+
+	if err := webhook.Delete(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// TestWebhook posts a ping event to the webhook identified by the ID in the request URL,
+// regardless of its events and whether it is enabled. The result shows up in the delivery log.
+func TestWebhook(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	webhook, ok := getWebhook(c, user.ID)
+	if !ok {
+		return
+	}
+
+	delivery, err := webhookDispatcher.Test(webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"delivery": delivery,
+	})
+}
+
+// GetWebhookDeliveries returns the delivery log of the webhook identified by the ID in the request URL, most recent first.
+// At most limit deliveries are returned, 50 by default.
+func GetWebhookDeliveries(c *gin.Context) {
+	user, ok := GetUserFromContext(c)
+	if !ok {
+		return
+	}
+
+	webhook, ok := getWebhook(c, user.ID)
+	if !ok {
+		return
+	}
+
+	limit := webhookDeliveryDefaultList
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > webhookDeliveryMaxList {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid limit, 1 to %d expected", webhookDeliveryMaxList)})
+			return
+		}
+	}
+
+	deliveries, err := models.WebhookDeliveryListGetByWebhookID(webhook.ID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deliveries": deliveries,
+	})
+}
+
+// getWebhook retrieves the webhook of the user identified by the ID in the request URL,
+// responding with an error if the ID is invalid or the webhook does not exist
+func getWebhook(c *gin.Context, userID uint) (models.Webhook, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook ID"})
+		return models.Webhook{}, false
+	}
+
+	webhook, err := models.WebhookGetByID(userID, uint(id))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// applyWebhookRequest validates the settings of a webhook request and sets them on the webhook,
+// responding with an error if they are invalid
+func applyWebhookRequest(c *gin.Context, webhook *models.Webhook, body webhookRequest) bool {
+	body.Name = strings.TrimSpace(body.Name)
+	body.URL = strings.TrimSpace(body.URL)
+
+	if len(body.Name) > webhookMaxName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("name too long (max %d characters)", webhookMaxName)})
+		return false
+	}
+	target, err := url.Parse(body.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" || len(body.URL) > webhookMaxURL {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid url, http or https URL expected"})
+		return false
+	}
+	if err := webhookDispatcher.CheckURL(c.Request.Context(), target); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid url host: %v", err)})
+		return false
+	}
+	events := []string{}
+	for _, event := range body.Events {
+		if !slices.Contains(models.WebhookEvents, event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("unknown event %q", event)})
+			return false
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "at least one event is required"})
+		return false
+	}
+
+	webhook.Name = body.Name
+	webhook.URL = body.URL
+	webhook.Events = events
+	webhook.IsEnabled = body.Enabled == nil || *body.Enabled
+	return true
+}
+
+// generateWebhookSecret returns a new random webhook secret.
+func generateWebhookSecret() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return webhookSecretPrefix + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,345 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aafeher/gorque/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// webhookQueueSize is the number of events waiting for their webhooks to be looked up, further events are dropped
+const webhookQueueSize = 256
+
+// webhookMaxConcurrent limits the webhook requests in flight
+const webhookMaxConcurrent = 8
+
+// errWebhookAddressNotAllowed rejects webhook hosts on addresses of the server's own networks
+var errWebhookAddressNotAllowed = errors.New("loopback, link-local and private addresses are not allowed")
+
+// webhookPayload is the JSON body posted to the webhooks
+type webhookPayload struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	Data  any       `json:"data"`
+}
+
+// webhookEvent is an event waiting for the webhooks of the user subscribed to it
+type webhookEvent struct {
+	userID  uint
+	payload webhookPayload
+}
+
+// WebhookDispatcher posts the events of the users to their webhooks in the background.
+// Every delivery is logged, failed attempts are retried with exponential backoff. Deliveries pending
+// when the server stops are resumed on the next start.
+// Webhooks are not posted to loopback, link-local and private addresses, unless the private networks are allowed,
+// the address is checked on every connection and redirects are not followed.
+type WebhookDispatcher struct {
+	client       *http.Client
+	allowPrivate bool // allows loopback and private addresses, link-local ones are never allowed
+	events       chan webhookEvent
+	slots        chan struct{} // limits the requests in flight
+	maxAttempts  int
+	retryDelay   time.Duration // delay before the first retry, doubled for every further one
+	ctx          context.Context
+	cancel       context.CancelFunc
+	running      sync.WaitGroup
+}
+
+// NewWebhookDispatcher creates a new webhook dispatcher, resumes the pending deliveries and starts dispatching in the background
+func NewWebhookDispatcher(timeout time.Duration, maxAttempts int, retryDelay time.Duration, allowPrivate bool) *WebhookDispatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	wd := &WebhookDispatcher{
+		allowPrivate: allowPrivate,
+		events:       make(chan webhookEvent, webhookQueueSize),
+		slots:        make(chan struct{}, webhookMaxConcurrent),
+		maxAttempts:  max(maxAttempts, 1),
+		retryDelay:   retryDelay,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+
+	// The resolved address is checked right before connecting, so a host cannot resolve to another address
+	// after its URL was accepted. The webhooks are posted directly, a proxy would be dialed instead of them.
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			if !wd.addressAllowed(addrPort.Addr()) {
+				return errWebhookAddressNotAllowed
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	wd.client = &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		// A redirect is a failed delivery, it could lead to an address that is not allowed
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	wd.resume()
+
+	wd.running.Add(1)
+	go wd.run()
+
+	return wd
+}
+
+// SessionStarted publishes the start of a session
+func (wd *WebhookDispatcher) SessionStarted(session models.Session) {
+	wd.publish(session.UserID, models.WebhookEventSessionStarted, gin.H{"session": session})
+}
+
+// SessionEnded publishes the end of a session
+func (wd *WebhookDispatcher) SessionEnded(session models.Session) {
+	wd.publish(session.UserID, models.WebhookEventSessionEnded, gin.H{"session": session})
+}
+
+// DTCNew publishes a trouble code reported by a device for the first time
+func (wd *WebhookDispatcher) DTCNew(dtc models.DeviceDTC, sessionID string) {
+	wd.publish(dtc.UserID, models.WebhookEventDTCNew, gin.H{"dtc": dtc, "sessionId": sessionID})
+}
+
+// AlertTriggered publishes an alert triggered by a rule
+func (wd *WebhookDispatcher) AlertTriggered(alert models.AlertEvent) {
+	wd.publish(alert.UserID, models.WebhookEventAlertTriggered, gin.H{"alert": alert})
+}
+
+// StatsComputed publishes the trip statistics calculated for a session, in the user's preferred units
+func (wd *WebhookDispatcher) StatsComputed(user *models.User, session models.Session, stat models.SessionStat) {
+	wd.publish(user.ID, models.WebhookEventStatsComputed, gin.H{"session": session, "stats": stat.InUnits(user.UnitPreferences())})
+}
+
+// Test queues a ping event to the webhook, regardless of its events and whether it is enabled
+func (wd *WebhookDispatcher) Test(webhook models.Webhook) (models.WebhookDelivery, error) {
+	return wd.queue(webhook, webhookPayload{
+		Event: models.WebhookEventPing,
+		Time:  time.Now(),
+		Data:  gin.H{"webhookId": webhook.ID},
+	})
+}
+
+// Close stops the dispatcher. Deliveries waiting for a retry are left pending and resumed on the next start.
+func (wd *WebhookDispatcher) Close() {
+	wd.cancel()
+	wd.running.Wait()
+}
+
+// publish queues an event for the webhooks of the user subscribed to it without blocking the caller.
+// The event is dropped if the queue is full.
+func (wd *WebhookDispatcher) publish(userID uint, event string, data any) {
+	select {
+	case wd.events <- webhookEvent{userID: userID, payload: webhookPayload{Event: event, Time: time.Now(), Data: data}}:
+	default:
+		log.Printf("Webhook queue full, %s event of user %d dropped", event, userID)
+	}
+}
+
+// run queues the deliveries of the published events until the dispatcher is closed
+func (wd *WebhookDispatcher) run() {
+	defer wd.running.Done()
+
+	for {
+		select {
+		case event := <-wd.events:
+			webhooks, err := models.WebhookListGetSubscribed(event.userID, event.payload.Event)
+			if err != nil {
+				log.Printf("Webhook lookup error: %v", err)
+				continue
+			}
+			for _, webhook := range webhooks {
+				if _, err := wd.queue(webhook, event.payload); err != nil {
+					log.Printf("Webhook delivery creation error for webhook %d: %v", webhook.ID, err)
+				}
+			}
+		case <-wd.ctx.Done():
+			return
+		}
+	}
+}
+
+// queue logs a new delivery of the payload to the webhook and starts delivering it
+func (wd *WebhookDispatcher) queue(webhook models.Webhook, payload webhookPayload) (models.WebhookDelivery, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+
+	now := time.Now()
+	delivery := models.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		UserID:        webhook.UserID,
+		Event:         payload.Event,
+		Payload:       string(body),
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: &now,
+	}
+	if err := models.WebhookDeliveryCreate(&delivery); err != nil {
+		return delivery, err
+	}
+
+	wd.running.Add(1)
+	go wd.deliver(delivery)
+
+	return delivery, nil
+}
+
+// resume starts delivering the deliveries left pending when the server stopped
+func (wd *WebhookDispatcher) resume() {
+	deliveries, err := models.WebhookDeliveryListGetPending()
+	if err != nil {
+		log.Printf("Pending webhook delivery lookup error: %v", err)
+		return
+	}
+
+	for _, delivery := range deliveries {
+		wd.running.Add(1)
+		go wd.deliver(delivery)
+	}
+}
+
+// deliver posts the delivery to its webhook until it succeeds or runs out of attempts
+func (wd *WebhookDispatcher) deliver(delivery models.WebhookDelivery) {
+	defer wd.running.Done()
+
+	for {
+		if delivery.NextAttemptAt != nil {
+			timer := time.NewTimer(time.Until(*delivery.NextAttemptAt))
+			select {
+			case <-timer.C:
+			case <-wd.ctx.Done():
+				timer.Stop()
+				return
+			}
+		}
+
+		// The webhook is reloaded for every attempt, its URL or secret may have changed in the meantime
+		webhook, err := models.WebhookGetByID(delivery.UserID, delivery.WebhookID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+		if err == nil {
+			select {
+			case wd.slots <- struct{}{}:
+			case <-wd.ctx.Done():
+				return
+			}
+			delivery.ResponseStatus, err = wd.post(webhook, delivery)
+			<-wd.slots
+		}
+		if wd.ctx.Err() != nil {
+			// Interrupted by the shutdown, the attempt is repeated on the next start
+			return
+		}
+
+		delivery.Attempts++
+		delivery.Error = ""
+		delivery.NextAttemptAt = nil
+		switch {
+		case err == nil:
+			now := time.Now()
+			delivery.Status = models.WebhookDeliverySucceeded
+			delivery.DeliveredAt = &now
+		case delivery.Attempts >= wd.maxAttempts:
+			delivery.Status = models.WebhookDeliveryFailed
+			delivery.Error = err.Error()
+		default:
+			next := time.Now().Add(wd.retryDelay << (delivery.Attempts - 1))
+			delivery.Error = err.Error()
+			delivery.NextAttemptAt = &next
+		}
+
+		if err := models.WebhookDeliveryUpdateAttempt(&delivery); err != nil {
+			log.Printf("Webhook delivery update error for delivery %d: %v", delivery.ID, err)
+		}
+		if delivery.Status != models.WebhookDeliveryPending {
+			return
+		}
+	}
+}
+
+// post sends the payload of the delivery to the webhook, signed with its secret.
+// Returns the HTTP status of the response, responses other than 2xx are errors.
+func (wd *WebhookDispatcher) post(webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	request, err := http.NewRequestWithContext(wd.ctx, http.MethodPost, webhook.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "gorque-webhook")
+	request.Header.Set("X-Gorque-Event", delivery.Event)
+	request.Header.Set("X-Gorque-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	request.Header.Set("X-Gorque-Signature", "sha256="+webhookSignature(webhook.Secret, delivery.Payload))
+
+	response, err := wd.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64*1024))
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("unexpected response status %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// CheckURL checks that the host of a webhook URL resolves to addresses the webhooks may be posted to
+func (wd *WebhookDispatcher) CheckURL(ctx context.Context, target *url.URL) error {
+	addresses, err := net.DefaultResolver.LookupNetIP(ctx, "ip", target.Hostname())
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if !wd.addressAllowed(address) {
+			return errWebhookAddressNotAllowed
+		}
+	}
+	return nil
+}
+
+// addressAllowed reports whether webhooks may be posted to the address
+func (wd *WebhookDispatcher) addressAllowed(address netip.Addr) bool {
+	address = address.Unmap()
+	switch {
+	case !address.IsValid(), address.IsUnspecified(), address.IsMulticast(),
+		address.IsLinkLocalUnicast(), address.IsLinkLocalMulticast(), address.IsInterfaceLocalMulticast():
+		return false
+	case address.IsLoopback(), address.IsPrivate():
+		return wd.allowPrivate
+	}
+	return true
+}
+
+// webhookSignature returns the hex encoded HMAC-SHA256 of the payload with the secret of the webhook
+func webhookSignature(secret string, payload string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/aafeher/gorque/models"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestWebhookDispatcherAddressAllowed(t *testing.T) {
+	tests := []struct {
+		address          string
+		wantAllowed      bool
+		wantAllowPrivate bool // allowed when the private networks are
+	}{
+		{address: "93.184.215.14", wantAllowed: true, wantAllowPrivate: true},
+		{address: "2606:2800:21f:cb07:6820:80da:af6b:8b2c", wantAllowed: true, wantAllowPrivate: true},
+		{address: "127.0.0.1", wantAllowPrivate: true},
+		{address: "::1", wantAllowPrivate: true},
+		{address: "::ffff:127.0.0.1", wantAllowPrivate: true},
+		{address: "10.1.2.3", wantAllowPrivate: true},
+		{address: "172.16.0.1", wantAllowPrivate: true},
+		{address: "192.168.1.10", wantAllowPrivate: true},
+		{address: "fd12:3456::1", wantAllowPrivate: true},
+		{address: "169.254.169.254"},
+		{address: "fe80::1"},
+		{address: "0.0.0.0"},
+		{address: "224.0.0.1"},
+	}
+
+	blocking := &WebhookDispatcher{}
+	allowing := &WebhookDispatcher{allowPrivate: true}
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			address := netip.MustParseAddr(test.address)
+			if got := blocking.addressAllowed(address); got != test.wantAllowed {
+				t.Errorf("allowed = %v, want %v", got, test.wantAllowed)
+			}
+			if got := allowing.addressAllowed(address); got != test.wantAllowPrivate {
+				t.Errorf("allowed with private networks = %v, want %v", got, test.wantAllowPrivate)
+			}
+		})
+	}
+}
+
+func TestWebhookDispatcherPost(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+	redirect := httptest.NewServer(http.RedirectHandler(receiver.URL, http.StatusTemporaryRedirect))
+	defer redirect.Close()
+
+	tests := []struct {
+		name         string
+		url          string
+		allowPrivate bool
+		wantStatus   int
+		wantBlocked  bool
+	}{
+		{name: "loopback rejected", url: receiver.URL, wantBlocked: true},
+		{name: "loopback allowed", url: receiver.URL, allowPrivate: true, wantStatus: http.StatusNoContent},
+		{name: "redirect not followed", url: redirect.URL, allowPrivate: true, wantStatus: http.StatusTemporaryRedirect},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			wd := NewWebhookDispatcher(5*time.Second, 1, time.Second, test.allowPrivate)
+			defer wd.Close()
+
+			webhook := models.Webhook{URL: test.url, Secret: "whsec_test"}
+			status, err := wd.post(webhook, models.WebhookDelivery{Event: "test", Payload: `{}`})
+			if status != test.wantStatus {
+				t.Errorf("status = %d, want %d", status, test.wantStatus)
+			}
+			if blocked := errors.Is(err, errWebhookAddressNotAllowed); blocked != test.wantBlocked {
+				t.Errorf("blocked = %v (%v), want %v", blocked, err, test.wantBlocked)
+			}
+			if test.wantStatus != http.StatusNoContent && err == nil {
+				t.Error("failed delivery reported as succeeded")
+			}
+		})
+	}
+}
@@ -1,6 +1,10 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/aafeher/gorque/handlers"
 	"github.com/aafeher/gorque/middlewares"
 	"github.com/aafeher/gorque/models"
@@ -8,6 +12,8 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
@@ -17,6 +23,25 @@ func main() {
 
 	models.ConnectDatabase()
 
+	store, err := models.NewTimeSeriesStore()
+	if err != nil {
+		log.Fatalf("Failed to initialize time-series store: %v", err)
+	}
+
+	handlers.Init(store)
+
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err := runImport(os.Args[2:])
+		handlers.Shutdown()
+		store.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	handlers.NewSessionReaper(store, models.GetEnvDuration("SESSION_IDLE_TIMEOUT", 15*time.Minute), time.Minute)
+
 	r := gin.Default()
 
 	r.GET("/health", func(c *gin.Context) {
@@ -37,18 +62,156 @@ func main() {
 	api.GET("/profile", handlers.GetProfile)
 	api.PUT("/profile/name", handlers.UpdateProfileName)
 	api.PUT("/profile/password", handlers.UpdateProfilePassword)
+	api.GET("/profile/units", handlers.GetProfileUnits)
+	api.PUT("/profile/units", handlers.UpdateProfileUnits)
 	api.POST("/refresh", handlers.RefreshToken)
 
 	api.GET("/configuration", handlers.GetConfiguration)
 
+	api.GET("/dashboard", handlers.GetDashboardList)
+	api.POST("/dashboard", handlers.CreateDashboard)
+	api.GET("/dashboard/:id", handlers.GetDashboard)
+	api.PUT("/dashboard/:id", handlers.UpdateDashboard)
+	api.DELETE("/dashboard/:id", handlers.DeleteDashboard)
+
+	api.GET("/upload-key", handlers.GetUploadKeyList)
+	api.POST("/upload-key", handlers.CreateUploadKey)
+	api.DELETE("/upload-key/:id", handlers.RevokeUploadKey)
+
 	api.GET("/device", handlers.GetDeviceList)
+	api.PUT("/device/:id", handlers.UpdateDevice)
+	api.DELETE("/device/:id", handlers.DeleteDevice)
+	api.POST("/device/:id/transfer", handlers.TransferDevice)
+	api.GET("/device/:id/dtc", handlers.GetDeviceDTCList)
+	api.GET("/device/:id/profiles", handlers.GetDeviceProfiles)
+	api.GET("/device-transfer", handlers.GetDeviceTransferList)
+	api.POST("/device-transfer/:id/accept", handlers.AcceptDeviceTransfer)
+	api.DELETE("/device-transfer/:id", handlers.DeleteDeviceTransfer)
+	api.GET("/alert-rule", handlers.GetAlertRuleList)
+	api.POST("/alert-rule", handlers.CreateAlertRule)
+	api.PUT("/alert-rule/:id", handlers.UpdateAlertRule)
+	api.DELETE("/alert-rule/:id", handlers.DeleteAlertRule)
+	api.GET("/alert", handlers.GetAlertList)
+	api.GET("/geofence", handlers.GetGeofenceList)
+	api.POST("/geofence", handlers.CreateGeofence)
+	api.PUT("/geofence/:id", handlers.UpdateGeofence)
+	api.DELETE("/geofence/:id", handlers.DeleteGeofence)
+	api.GET("/geofence-event", handlers.GetGeofenceEventList)
+	api.GET("/privacy-zone", handlers.GetPrivacyZoneList)
+	api.POST("/privacy-zone", handlers.CreatePrivacyZone)
+	api.PUT("/privacy-zone/:id", handlers.UpdatePrivacyZone)
+	api.DELETE("/privacy-zone/:id", handlers.DeletePrivacyZone)
+	api.GET("/share", handlers.GetShareLinkList)
+	api.DELETE("/share/:id", handlers.RevokeShareLink)
+	api.GET("/webhook", handlers.GetWebhookList)
+	api.POST("/webhook", handlers.CreateWebhook)
+	api.PUT("/webhook/:id", handlers.UpdateWebhook)
+	api.DELETE("/webhook/:id", handlers.DeleteWebhook)
+	api.POST("/webhook/:id/test", handlers.TestWebhook)
+	api.GET("/webhook/:id/deliveries", handlers.GetWebhookDeliveries)
+	api.GET("/vehicle", handlers.GetVehicleList)
+	api.POST("/vehicle", handlers.CreateVehicle)
+	api.GET("/vehicle/:id", handlers.GetVehicle)
+	api.PUT("/vehicle/:id", handlers.UpdateVehicle)
+	api.DELETE("/vehicle/:id", handlers.DeleteVehicle)
+	api.GET("/vehicle/:id/sessions", handlers.GetVehicleSessions)
+	api.GET("/vehicle/:id/profiles", handlers.GetVehicleProfiles)
 	api.GET("/session", handlers.GetSessionList)
+	api.PUT("/session/:id", handlers.UpdateSession)
+	api.DELETE("/session/:id", handlers.DeleteSession)
+	api.PUT("/session/:id/tags", handlers.UpdateSessionTags)
+	api.PUT("/session/:id/vehicle", handlers.UpdateSessionVehicle)
+	api.POST("/session/:id/split", handlers.SplitSession)
+	api.POST("/session/:id/merge", handlers.MergeSession)
+	api.GET("/session/:id/events", handlers.GetSessionEvents)
+	api.GET("/session/:id/alerts", handlers.GetSessionAlerts)
+	api.GET("/session/:id/geofences", handlers.GetSessionGeofences)
+	api.POST("/session/:id/geofences", handlers.DetectSessionGeofences)
+	api.GET("/session/:id/export", handlers.ExportSession)
+	api.POST("/session/:id/share", handlers.CreateShareLink)
+	api.GET("/session/:id/live", handlers.GetSessionLive)
+	api.GET("/session/:id/stats", handlers.GetSessionStats)
+	api.POST("/session/:id/stats", handlers.RecalculateSessionStats)
 	api.GET("/data", handlers.GetData)
+	api.POST("/import", handlers.ImportTorqueLog)
 
 	r.GET("/upload", handlers.Upload)
 
-	err := r.Run(":8080")
+	// Share links are authorized by their token, not by a user login
+	share := r.Group("/share")
+	share.Use(middlewares.CORS())
+	share.Use(middlewares.RateLimitMiddleware(60, time.Minute, 5*time.Minute))
+	share.GET("/:token", handlers.GetSharedSession)
+
+	server := &http.Server{
+		Addr:    ":8080",
+		Handler: r,
+	}
+	server.RegisterOnShutdown(handlers.CloseLiveStreams)
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a termination signal, then flush the buffered uploads before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown error: %v", err)
+	}
+
+	handlers.Shutdown()
+	store.Close()
+}
+
+// runImport imports Torque CSV trip logs given on the command line:
+// gorque import -user EMAIL -device DEVICE_ID [-tz TIME_ZONE] FILE...
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	email := flags.String("user", "", "email of the user owning the device")
+	deviceID := flags.String("device", "", "Torque device ID the logs belong to")
+	tz := flags.String("tz", "", "IANA time zone of the device times, derived from the GPS times if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" || *deviceID == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("user, device and at least one file are required")
+	}
+
+	user, err := models.UserGetByEmail(*email)
 	if err != nil {
-		return
+		return fmt.Errorf("user %s not found", *email)
+	}
+
+	var location *time.Location
+	if *tz != "" {
+		if location, err = time.LoadLocation(*tz); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, path := range flags.Args() {
+		result, err := handlers.ImportTorqueCSVFile(user, *deviceID, path, location)
+		if err != nil {
+			log.Printf("%s: import failed: %v", path, err)
+			failed++
+			continue
+		}
+		log.Printf("%s: imported %d samples as session %s, skipped %d rows and columns %v",
+			path, result.Samples, result.SessionID, result.SkippedRows, result.SkippedColumns)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed to import", failed, flags.NArg())
 	}
+	return nil
 }
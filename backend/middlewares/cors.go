@@ -11,7 +11,7 @@ import (
 func CORS() gin.HandlerFunc {
 
 	corsConfig := cors.Config{
-		AllowMethods:     []string{"GET", "POST", "PUT"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
 		AllowHeaders:     []string{"Authorization", "Content-Type"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
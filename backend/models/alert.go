@@ -0,0 +1,169 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// AlertRule is a condition on the uploaded data of the user's devices, e.g. a coolant temperature above 105°C.
+// The rule triggers an alert once its expression held for MinDuration seconds and clears it when the expression,
+// with the thresholds relaxed by the hysteresis, no longer holds. Alerts are sent to the notification channels of the rule.
+type AlertRule struct {
+	ID          uint      `gorm:"primarykey;autoIncrement"`
+	UserID      uint      `gorm:"column:user_id;index:idx_alert_rule_user_id;not null"`
+	DeviceID    string    `gorm:"column:device_id;index:idx_alert_rule_device_id"` // empty for all devices of the user
+	Name        string    `gorm:"column:name;not null"`
+	Expression  string    `gorm:"column:expression;not null"`
+	Hysteresis  float64   `gorm:"column:hysteresis;not null"`
+	MinDuration int       `gorm:"column:min_duration;not null"` // seconds
+	Channels    []string  `gorm:"column:channels;serializer:json"`
+	IsEnabled   bool      `gorm:"column:is_enabled;not null"`
+	CreatedAt   time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*AlertRule) TableName() string {
+	return "alert_rules"
+}
+
+// AlertEvent is an alert triggered by a rule during a session.
+// Time is the time of the sample that triggered it, the condition held since ConditionSince.
+type AlertEvent struct {
+	ID             uint               `gorm:"primarykey;autoIncrement"`
+	RuleID         uint               `gorm:"column:rule_id;index:idx_alert_event_rule_id;not null"`
+	UserID         uint               `gorm:"column:user_id;index:idx_alert_event_user_id;not null"`
+	DeviceID       string             `gorm:"column:device_id;index:idx_alert_event_device_id;not null"`
+	SessionID      string             `gorm:"column:session_id;index:idx_alert_event_session_id;not null"`
+	RuleName       string             `gorm:"column:rule_name"`                    // name of the rule when it triggered
+	Expression     string             `gorm:"column:expression"`                   // expression of the rule when it triggered
+	Values         map[string]float64 `gorm:"column:field_values;serializer:json"` // values of the fields of the expression, keyed by UserDataCode
+	ConditionSince time.Time          `gorm:"column:condition_since;not null"`
+	Time           time.Time          `gorm:"column:time;index:idx_alert_event_time;not null"`
+	ClearedAt      *time.Time         `gorm:"column:cleared_at"`
+	CreatedAt      time.Time          `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*AlertEvent) TableName() string {
+	return "alert_events"
+}
+
+// AlertEventFilter narrows the alert events listed, zero values do not filter
+type AlertEventFilter struct {
+	DeviceID  string
+	SessionID string
+	RuleID    uint
+	From      *time.Time
+	To        *time.Time
+	Active    bool // only alerts not cleared yet
+}
+
+// AlertRuleCreate inserts a new alert rule record into the database.
+func AlertRuleCreate(rule *AlertRule) error {
+	return DBSQLite.Create(rule).Error
+}
+
+// AlertRuleGetByID retrieves an alert rule of the user by its ID.
+func AlertRuleGetByID(userID uint, id uint) (AlertRule, error) {
+	var rule AlertRule
+	err := DBSQLite.Where("id = ? AND user_id = ?", id, userID).First(&rule).Error
+	return rule, err
+}
+
+// AlertRuleListGetByUserID retrieves the alert rules of a user ordered by name.
+func AlertRuleListGetByUserID(userID uint) ([]AlertRule, error) {
+	var rules []AlertRule
+	err := DBSQLite.Where("user_id = ?", userID).Order("name ASC, id ASC").Find(&rules).Error
+	return rules, err
+}
+
+// AlertRuleListGetEnabled retrieves the enabled alert rules of a user.
+func AlertRuleListGetEnabled(userID uint) ([]AlertRule, error) {
+	var rules []AlertRule
+	err := DBSQLite.Where("user_id = ? AND is_enabled = ?", userID, true).Order("id ASC").Find(&rules).Error
+	return rules, err
+}
+
+// AlertRuleUpdate updates the settings of an alert rule.
+func AlertRuleUpdate(rule *AlertRule) error {
+	rule.UpdatedAt = time.Now()
+	return DBSQLite.Model(rule).
+		Select("device_id", "name", "expression", "hysteresis", "min_duration", "channels", "is_enabled", "updated_at").
+		Updates(rule).Error
+}
+
+// Delete removes the alert rule. The alerts it triggered are kept, the active ones are cleared.
+func (rule *AlertRule) Delete() error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := alertEventClearByRuleID(tx, rule.ID, time.Now()); err != nil {
+			return err
+		}
+		return tx.Delete(rule).Error
+	})
+}
+
+// AlertEventCreate inserts a new alert event record into the database.
+func AlertEventCreate(event *AlertEvent) error {
+	return DBSQLite.Create(event).Error
+}
+
+// AlertEventGetActive retrieves the alert of a rule that is active in the session.
+func AlertEventGetActive(ruleID uint, sessionID string) (AlertEvent, error) {
+	var event AlertEvent
+	err := DBSQLite.Where("rule_id = ? AND session_id = ? AND cleared_at IS NULL", ruleID, sessionID).
+		Order("time DESC").
+		First(&event).Error
+	return event, err
+}
+
+// AlertEventClear marks an alert cleared at the given time.
+func AlertEventClear(id uint, clearedAt time.Time) error {
+	return DBSQLite.Model(&AlertEvent{}).
+		Where("id = ? AND cleared_at IS NULL", id).
+		Update("cleared_at", clearedAt).Error
+}
+
+// AlertEventClearBySessionID clears the alerts still active in a session at the given time, e.g. when the session ends.
+func AlertEventClearBySessionID(sessionID string, clearedAt time.Time) error {
+	return DBSQLite.Model(&AlertEvent{}).
+		Where("session_id = ? AND cleared_at IS NULL", sessionID).
+		Update("cleared_at", clearedAt).Error
+}
+
+// alertEventClearByRuleID clears the alerts of a rule still active at the given time
+func alertEventClearByRuleID(tx *gorm.DB, ruleID uint, clearedAt time.Time) error {
+	return tx.Model(&AlertEvent{}).
+		Where("rule_id = ? AND cleared_at IS NULL", ruleID).
+		Update("cleared_at", clearedAt).Error
+}
+
+// AlertEventListGetByUserID retrieves the alerts of a user matching the filter, most recent first.
+// At most limit alerts are returned.
+func AlertEventListGetByUserID(userID uint, filter AlertEventFilter, limit int) ([]AlertEvent, error) {
+	query := DBSQLite.Where("user_id = ?", userID)
+	if filter.DeviceID != "" {
+		query = query.Where("device_id = ?", filter.DeviceID)
+	}
+	if filter.SessionID != "" {
+		query = query.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.RuleID != 0 {
+		query = query.Where("rule_id = ?", filter.RuleID)
+	}
+	if filter.From != nil {
+		query = query.Where("time >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("time <= ?", *filter.To)
+	}
+	if filter.Active {
+		query = query.Where("cleared_at IS NULL")
+	}
+
+	var events []AlertEvent
+	err := query.Order("time DESC, id DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
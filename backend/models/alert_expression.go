@@ -0,0 +1,383 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// alertExpressionMaxLength limits the length of alert rule expressions
+const alertExpressionMaxLength = 500
+
+// alertFieldPattern matches the data fields of alert expressions, e.g. k05, k5 or kff1238
+var alertFieldPattern = regexp.MustCompile(`^k[0-9a-f]+$`)
+
+// AlertExpression is a parsed alert rule condition over the data fields of the uploads,
+// e.g. "k05 > 105" or "kff1238 < 11.8 or k42 < 11.8".
+//
+// Fields are referenced by their UserDataCode and compared with <, <=, >, >=, == and !=,
+// numbers can be combined with +, -, * and /, conditions with and, or and not (or &&, || and !).
+type AlertExpression struct {
+	root   *alertNode
+	fields []string // field keys referenced by the expression, as uploaded by Torque
+}
+
+// alertNode is a node of a parsed alert expression
+type alertNode struct {
+	op       string // "number", "field", or the operator
+	number   float64
+	field    string // field key, as uploaded by Torque
+	operands []*alertNode
+}
+
+// isCondition reports whether the node evaluates to a truth value rather than a number
+func (node *alertNode) isCondition() bool {
+	switch node.op {
+	case "or", "and", "not", "<", "<=", ">", ">=", "==", "!=":
+		return true
+	}
+	return false
+}
+
+// ParseAlertExpression parses the condition of an alert rule.
+func ParseAlertExpression(text string) (*AlertExpression, error) {
+	if len(text) > alertExpressionMaxLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", alertExpressionMaxLength)
+	}
+
+	tokens, err := tokenizeAlertExpression(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("expression is empty")
+	}
+
+	parser := alertParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(tokens) {
+		return nil, fmt.Errorf("unexpected %q", tokens[parser.position])
+	}
+	if !root.isCondition() {
+		return nil, fmt.Errorf("expression must be a condition, e.g. k05 > 105")
+	}
+
+	return &AlertExpression{root: root, fields: parser.fields}, nil
+}
+
+// Fields returns the keys of the data fields referenced by the expression, as uploaded by Torque.
+func (expression *AlertExpression) Fields() []string {
+	return expression.fields
+}
+
+// Evaluate evaluates the condition on the data fields of a sample.
+//
+// While the alert is active, the thresholds of the comparisons are relaxed by the hysteresis,
+// so e.g. "k05 > 105" with a hysteresis of 3 holds until the value drops to 102.
+// Returns false for known if the result depends on fields missing from the sample.
+func (expression *AlertExpression) Evaluate(fields map[string]any, hysteresis float64, active bool) (result bool, known bool) {
+	relax := 0.0
+	if active {
+		relax = hysteresis
+	}
+	return expression.root.condition(fields, relax)
+}
+
+// condition evaluates a condition node with three-valued logic, the comparisons are relaxed by relax
+func (node *alertNode) condition(fields map[string]any, relax float64) (bool, bool) {
+	switch node.op {
+	case "not":
+		// The negated comparisons hold longer if they are tightened
+		result, known := node.operands[0].condition(fields, -relax)
+		return !result, known
+	case "and", "or":
+		// A false operand decides an and, a true operand an or, even if the other is unknown
+		decisive := node.op == "or"
+		known := true
+		for _, operand := range node.operands {
+			result, operandKnown := operand.condition(fields, relax)
+			if operandKnown && result == decisive {
+				return decisive, true
+			}
+			known = known && operandKnown
+		}
+		return !decisive, known
+	}
+
+	left, leftKnown := node.operands[0].value(fields)
+	right, rightKnown := node.operands[1].value(fields)
+	if !leftKnown || !rightKnown {
+		return false, false
+	}
+
+	switch node.op {
+	case "<":
+		return left < right+relax, true
+	case "<=":
+		return left <= right+relax, true
+	case ">":
+		return left > right-relax, true
+	case ">=":
+		return left >= right-relax, true
+	case "==":
+		return left == right, true
+	default:
+		return left != right, true
+	}
+}
+
+// value evaluates a numeric node, returns false if a field is missing or on a division by zero
+func (node *alertNode) value(fields map[string]any) (float64, bool) {
+	switch node.op {
+	case "number":
+		return node.number, true
+	case "field":
+		value, ok := fields[node.field].(float64)
+		return value, ok
+	case "negate":
+		value, ok := node.operands[0].value(fields)
+		return -value, ok
+	}
+
+	left, leftKnown := node.operands[0].value(fields)
+	right, rightKnown := node.operands[1].value(fields)
+	if !leftKnown || !rightKnown {
+		return 0, false
+	}
+
+	switch node.op {
+	case "+":
+		return left + right, true
+	case "-":
+		return left - right, true
+	case "*":
+		return left * right, true
+	default:
+		if right == 0 {
+			return 0, false
+		}
+		return left / right, true
+	}
+}
+
+// tokenizeAlertExpression splits an alert expression into numbers, words and operators
+func tokenizeAlertExpression(text string) ([]string, error) {
+	var tokens []string
+	for position := 0; position < len(text); {
+		char := rune(text[position])
+		switch {
+		case unicode.IsSpace(char):
+			position++
+		case unicode.IsDigit(char) || char == '.':
+			end := position
+			for end < len(text) && (unicode.IsDigit(rune(text[end])) || text[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, text[position:end])
+			position = end
+		case unicode.IsLetter(char) || char == '_':
+			end := position
+			for end < len(text) && (unicode.IsLetter(rune(text[end])) || unicode.IsDigit(rune(text[end])) || text[end] == '_') {
+				end++
+			}
+			tokens = append(tokens, strings.ToLower(text[position:end]))
+			position = end
+		default:
+			operator := ""
+			for _, candidate := range []string{"<=", ">=", "==", "!=", "&&", "||", "<", ">", "!", "+", "-", "*", "/", "(", ")"} {
+				if strings.HasPrefix(text[position:], candidate) {
+					operator = candidate
+					break
+				}
+			}
+			if operator == "" {
+				return nil, fmt.Errorf("unexpected character %q", char)
+			}
+			tokens = append(tokens, operator)
+			position += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+// alertParser is a recursive descent parser of alert expressions.
+// From the lowest precedence: or, and, not, comparisons, + and -, * and /, unary minus.
+type alertParser struct {
+	tokens   []string
+	position int
+	fields   []string
+}
+
+// peek returns the next token, or an empty string at the end of the expression
+func (parser *alertParser) peek() string {
+	if parser.position < len(parser.tokens) {
+		return parser.tokens[parser.position]
+	}
+	return ""
+}
+
+// accept consumes the next token if it is one of the given tokens and returns it
+func (parser *alertParser) accept(tokens ...string) (string, bool) {
+	token := parser.peek()
+	if token != "" && slices.Contains(tokens, token) {
+		parser.position++
+		return token, true
+	}
+	return "", false
+}
+
+// parseOr parses conditions joined by or
+func (parser *alertParser) parseOr() (*alertNode, error) {
+	return parser.parseLogical("or", []string{"or", "||"}, parser.parseAnd)
+}
+
+// parseAnd parses conditions joined by and
+func (parser *alertParser) parseAnd() (*alertNode, error) {
+	return parser.parseLogical("and", []string{"and", "&&"}, parser.parseNot)
+}
+
+// parseLogical parses operands joined by a logical operator into a single node
+func (parser *alertParser) parseLogical(op string, tokens []string, parseOperand func() (*alertNode, error)) (*alertNode, error) {
+	operand, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	node := &alertNode{op: op, operands: []*alertNode{operand}}
+	for {
+		if _, ok := parser.accept(tokens...); !ok {
+			break
+		}
+		if operand, err = parseOperand(); err != nil {
+			return nil, err
+		}
+		node.operands = append(node.operands, operand)
+	}
+
+	if len(node.operands) == 1 {
+		return node.operands[0], nil
+	}
+	for _, operand := range node.operands {
+		if !operand.isCondition() {
+			return nil, fmt.Errorf("%s expects conditions, e.g. k05 > 105", op)
+		}
+	}
+	return node, nil
+}
+
+// parseNot parses an optionally negated condition
+func (parser *alertParser) parseNot() (*alertNode, error) {
+	if _, ok := parser.accept("not", "!"); !ok {
+		return parser.parseComparison()
+	}
+	operand, err := parser.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	if !operand.isCondition() {
+		return nil, fmt.Errorf("not expects a condition, e.g. not k05 > 105")
+	}
+	return &alertNode{op: "not", operands: []*alertNode{operand}}, nil
+}
+
+// parseComparison parses a comparison of two numeric operands, or a single operand
+func (parser *alertParser) parseComparison() (*alertNode, error) {
+	left, err := parser.parseArithmetic(parser.parseProduct, "+", "-")
+	if err != nil {
+		return nil, err
+	}
+	op, ok := parser.accept("<", "<=", ">", ">=", "==", "!=")
+	if !ok {
+		return left, nil
+	}
+	right, err := parser.parseArithmetic(parser.parseProduct, "+", "-")
+	if err != nil {
+		return nil, err
+	}
+	if left.isCondition() || right.isCondition() {
+		return nil, fmt.Errorf("%s expects numbers", op)
+	}
+	return &alertNode{op: op, operands: []*alertNode{left, right}}, nil
+}
+
+// parseProduct parses operands joined by * and /
+func (parser *alertParser) parseProduct() (*alertNode, error) {
+	return parser.parseArithmetic(parser.parseUnary, "*", "/")
+}
+
+// parseArithmetic parses operands joined by left-associative arithmetic operators
+func (parser *alertParser) parseArithmetic(parseOperand func() (*alertNode, error), ops ...string) (*alertNode, error) {
+	left, err := parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := parser.accept(ops...)
+		if !ok {
+			return left, nil
+		}
+		right, err := parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		if left.isCondition() || right.isCondition() {
+			return nil, fmt.Errorf("%s expects numbers", op)
+		}
+		left = &alertNode{op: op, operands: []*alertNode{left, right}}
+	}
+}
+
+// parseUnary parses an optionally negated number, field or parenthesized expression
+func (parser *alertParser) parseUnary() (*alertNode, error) {
+	if _, ok := parser.accept("-"); ok {
+		operand, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if operand.isCondition() {
+			return nil, fmt.Errorf("- expects a number")
+		}
+		return &alertNode{op: "negate", operands: []*alertNode{operand}}, nil
+	}
+	return parser.parsePrimary()
+}
+
+// parsePrimary parses a number, a field or a parenthesized expression
+func (parser *alertParser) parsePrimary() (*alertNode, error) {
+	token := parser.peek()
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case token == "(":
+		parser.position++
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := parser.accept(")"); !ok {
+			return nil, fmt.Errorf("missing )")
+		}
+		return node, nil
+	case unicode.IsDigit(rune(token[0])) || token[0] == '.':
+		number, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", token)
+		}
+		parser.position++
+		return &alertNode{op: "number", number: number}, nil
+	case alertFieldPattern.MatchString(token):
+		parser.position++
+		key := UserDataCode(token).FieldKey()
+		if !slices.Contains(parser.fields, key) {
+			parser.fields = append(parser.fields, key)
+		}
+		return &alertNode{op: "field", field: key}, nil
+	default:
+		return nil, fmt.Errorf("unknown field %q, data fields are referenced by their code, e.g. k05 or kff1238", token)
+	}
+}
@@ -0,0 +1,156 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// Dashboard is a chart setup of the session view defined by a user.
+// A dashboard with a DeviceID is used for the sessions of that device, the one without for all other devices.
+type Dashboard struct {
+	ID        uint             `gorm:"primarykey;autoIncrement"`
+	UserID    uint             `gorm:"column:user_id;uniqueIndex:idx_dashboard_unique_device;not null" json:"-"`
+	DeviceID  string           `gorm:"column:device_id;uniqueIndex:idx_dashboard_unique_device;not null;default:''"`
+	Name      string           `gorm:"column:name"`
+	CreatedAt time.Time        `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time        `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+	Charts    []DashboardChart `gorm:"foreignKey:DashboardID"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*Dashboard) TableName() string {
+	return "dashboards"
+}
+
+// DashboardChart is a chart of a dashboard, charts are shown in the order of their position
+type DashboardChart struct {
+	ID          uint                     `gorm:"primarykey;autoIncrement"`
+	DashboardID uint                     `gorm:"column:dashboard_id;index:idx_dashboard_chart_dashboard_id;not null" json:"-"`
+	Position    int                      `gorm:"column:position"`
+	Title       string                   `gorm:"column:title"`
+	Type        string                   `gorm:"column:type"`
+	YAxisTitle  string                   `gorm:"column:y_axis_title"`
+	Variables   []DashboardChartVariable `gorm:"foreignKey:ChartID"`
+}
+
+func (*DashboardChart) TableName() string {
+	return "dashboard_charts"
+}
+
+// DashboardChartVariable is a field plotted on a dashboard chart
+type DashboardChartVariable struct {
+	ID       uint         `gorm:"primarykey;autoIncrement"`
+	ChartID  uint         `gorm:"column:chart_id;index:idx_dashboard_chart_variable_chart_id;not null" json:"-"`
+	Position int          `gorm:"column:position"`
+	Key      UserDataCode `gorm:"column:key;not null"`
+	Name     string       `gorm:"column:name"`
+	Unit     string       `gorm:"column:unit"`
+}
+
+func (*DashboardChartVariable) TableName() string {
+	return "dashboard_chart_variables"
+}
+
+// preloadDashboardCharts loads the charts and their variables in the order of their position
+func preloadDashboardCharts(db *gorm.DB) *gorm.DB {
+	return db.
+		Preload("Charts", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, id ASC")
+		}).
+		Preload("Charts.Variables", func(db *gorm.DB) *gorm.DB {
+			return db.Order("position ASC, id ASC")
+		})
+}
+
+// DashboardCreate inserts a new dashboard with its charts and variables into the database.
+func DashboardCreate(dashboard *Dashboard) error {
+	return DBSQLite.Create(dashboard).Error
+}
+
+// DashboardGetByID retrieves a dashboard of the user with its charts.
+func DashboardGetByID(id uint, userID uint) (Dashboard, error) {
+	var dashboard Dashboard
+	err := preloadDashboardCharts(DBSQLite).Where("id = ? AND user_id = ?", id, userID).First(&dashboard).Error
+	return dashboard, err
+}
+
+// DashboardGetByDeviceID retrieves the dashboard of the user defined for the device, or the general one for an empty device ID.
+func DashboardGetByDeviceID(userID uint, deviceID string) (Dashboard, error) {
+	var dashboard Dashboard
+	err := preloadDashboardCharts(DBSQLite).Where("user_id = ? AND device_id = ?", userID, deviceID).First(&dashboard).Error
+	return dashboard, err
+}
+
+// DashboardGetForDevice retrieves the dashboard used for the sessions of a device:
+// the one defined for the device, otherwise the general one of the user.
+func DashboardGetForDevice(userID uint, deviceID string) (Dashboard, error) {
+	var dashboard Dashboard
+	err := preloadDashboardCharts(DBSQLite).
+		Where("user_id = ? AND device_id IN ?", userID, []string{deviceID, ""}).
+		Order("device_id DESC").
+		First(&dashboard).Error
+	return dashboard, err
+}
+
+// DashboardListGetByUserID retrieves all dashboards of a user with their charts, the general one first.
+func DashboardListGetByUserID(userID uint) ([]Dashboard, error) {
+	var dashboards []Dashboard
+	err := preloadDashboardCharts(DBSQLite).Where("user_id = ?", userID).Order("device_id ASC").Find(&dashboards).Error
+	return dashboards, err
+}
+
+// DashboardCountByUserID returns the number of dashboards of a user.
+func DashboardCountByUserID(userID uint) (int64, error) {
+	var count int64
+	err := DBSQLite.Model(&Dashboard{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// DashboardReplace updates the name and the device of a dashboard and replaces its charts.
+func DashboardReplace(dashboard *Dashboard) error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := deleteDashboardCharts(tx, dashboard.ID); err != nil {
+			return err
+		}
+
+		err := tx.Model(dashboard).
+			Select("name", "device_id", "updated_at").
+			Updates(Dashboard{Name: dashboard.Name, DeviceID: dashboard.DeviceID, UpdatedAt: time.Now()}).Error
+		if err != nil {
+			return err
+		}
+
+		for i := range dashboard.Charts {
+			dashboard.Charts[i].DashboardID = dashboard.ID
+		}
+		if len(dashboard.Charts) == 0 {
+			return nil
+		}
+		return tx.Create(&dashboard.Charts).Error
+	})
+}
+
+// DashboardDelete deletes a dashboard of the user with its charts.
+// Returns gorm.ErrRecordNotFound if the user has no dashboard with the given ID.
+func DashboardDelete(id uint, userID uint) error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		var dashboard Dashboard
+		if err := tx.Where("id = ? AND user_id = ?", id, userID).First(&dashboard).Error; err != nil {
+			return err
+		}
+		if err := deleteDashboardCharts(tx, dashboard.ID); err != nil {
+			return err
+		}
+		return tx.Delete(&dashboard).Error
+	})
+}
+
+// deleteDashboardCharts deletes the charts of a dashboard with their variables
+func deleteDashboardCharts(tx *gorm.DB, dashboardID uint) error {
+	chartIDs := tx.Model(&DashboardChart{}).Select("id").Where("dashboard_id = ?", dashboardID)
+	if err := tx.Where("chart_id IN (?)", chartIDs).Delete(&DashboardChartVariable{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("dashboard_id = ?", dashboardID).Delete(&DashboardChart{}).Error
+}
@@ -6,6 +6,9 @@ import (
 	"time"
 )
 
+// ErrDeviceOwner is returned for a device ID that is registered to another user
+var ErrDeviceOwner = errors.New("device belongs to another user")
+
 type Device struct {
 	ID        uint      `gorm:"primarykey;autoIncrement"`
 	DeviceID  string    `gorm:"column:device_id;uniqueIndex:idx_device_unique_device_id;not null"`
@@ -14,22 +17,15 @@ type Device struct {
 	CreatedAt time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
 	UpdatedAt time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
 
-	ProfileBoostAdjust  float64 `gorm:"column:profileBoostAdjust"`
-	ProfileDisplacement float64 `gorm:"column:profileDisplacement"`
-	ProfileDragCoeff    float64 `gorm:"column:profileDragCoeff"`
-	ProfileFuelCost     float64 `gorm:"column:profileFuelCost"`
-	ProfileFuelType     int64   `gorm:"column:profileFuelType"`
-	ProfileMPGAdjust    float64 `gorm:"column:profileMPGAdjust"`
-	ProfileName         string  `gorm:"column:profileName"`
-	ProfileOBDAdjust    float64 `gorm:"column:profileOBDAdjust"`
-	ProfileOdometer     int64   `gorm:"column:profileOdometer"`
-	ProfileTankCapacity float64 `gorm:"column:profileTankCapacity"`
-	ProfileTankUsed     float64 `gorm:"column:profileTankUsed"`
-	ProfileVe           float64 `gorm:"column:profileVe"`
-	ProfileVehicleType  int64   `gorm:"column:profileVehicleType"`
+	VehicleProfileData // the latest vehicle profile sent by Torque
 
 	LastSeen time.Time `gorm:"column:last_seen"`
 
+	DisplayName string `gorm:"column:display_name"` // name set by the user, shown instead of the Torque profile name
+	Icon        string `gorm:"column:icon"`
+	Color       string `gorm:"column:color"` // hex color, e.g. #4f46e5
+	IsArchived  bool   `gorm:"column:is_archived;index:idx_device_is_archived;default:0"`
+
 	User User `gorm:"foreignKey:UserID;references:ID"`
 }
 
@@ -47,7 +43,7 @@ func DeviceCreate(device *Device) error {
 
 // DeviceCreateOrUpdate creates a new device record or updates an existing one with profile data.
 // It takes a device object and either inserts it or updates the existing record.
-// Returns any error that occurred during the operation.
+// Returns ErrDeviceOwner if the device is registered to another user, or any error that occurred during the operation.
 func DeviceCreateOrUpdate(device *Device) error {
 	var existingDevice Device
 
@@ -63,6 +59,10 @@ func DeviceCreateOrUpdate(device *Device) error {
 		return result.Error
 	}
 
+	if existingDevice.UserID != device.UserID {
+		return ErrDeviceOwner
+	}
+
 	// Device exists, update profile fields
 	updates := map[string]interface{}{
 		"profileBoostAdjust":  device.ProfileBoostAdjust,
@@ -78,7 +78,8 @@ func DeviceCreateOrUpdate(device *Device) error {
 		"profileTankUsed":     device.ProfileTankUsed,
 		"profileVe":           device.ProfileVe,
 		"profileVehicleType":  device.ProfileVehicleType,
-		"v":                   device.Version,
+		"profileWeight":       device.ProfileWeight,
+		"version":             device.Version,
 	}
 
 	return DBSQLite.Model(&existingDevice).Updates(updates).Error
@@ -86,7 +87,7 @@ func DeviceCreateOrUpdate(device *Device) error {
 
 // DeviceFindOrCreate finds an existing device by deviceID or creates a new one with the provided details.
 // Returns the device, a boolean indicating whether a new record was created (true) or an existing one was found (false),
-// and any error that occurred.
+// and any error that occurred. A device registered to another user is not returned, ErrDeviceOwner is.
 func DeviceFindOrCreate(deviceID string, userID uint) (Device, bool, error) {
 	var device Device
 
@@ -103,6 +104,9 @@ func DeviceFindOrCreate(deviceID string, userID uint) (Device, bool, error) {
 	if result.Error != nil {
 		return device, false, result.Error
 	}
+	if device.UserID != userID {
+		return Device{}, false, ErrDeviceOwner
+	}
 
 	// Return whether this was a create operation (rows affected > 0)
 	return device, result.RowsAffected > 0, nil
@@ -115,15 +119,181 @@ func DeviceGetByDeviceID(userID uint, deviceID string) (Device, error) {
 	return device, err
 }
 
-// DeviceListGetByUserID retrieves all devices associated with a specific user ID.
-func DeviceListGetByUserID(userID uint) ([]Device, error) {
+// DeviceFindByDeviceID retrieves a device by its device ID regardless of the owning user.
+func DeviceFindByDeviceID(deviceID string) (Device, error) {
+	var device Device
+	err := DBSQLite.Where("device_id = ?", deviceID).First(&device).Error
+	return device, err
+}
+
+// DeviceListGetByUserID retrieves the devices associated with a specific user ID.
+// Archived devices are only included if includeArchived is set.
+func DeviceListGetByUserID(userID uint, includeArchived bool) ([]Device, error) {
 	var devices []Device
-	err := DBSQLite.Where("user_id = ?", userID).Find(&devices).Error
+	query := DBSQLite.Where("user_id = ?", userID)
+	if !includeArchived {
+		query = query.Where("is_archived = ?", false)
+	}
+	err := query.Find(&devices).Error
 	return devices, err
 }
 
-// GetSessions retrieves all sessions associated with this device and its user,
+// DeviceUpdateDetails updates the display name, the icon, the color and the archived mark of a device.
+// Nil values are left unchanged.
+func DeviceUpdateDetails(deviceID string, displayName *string, icon *string, color *string, isArchived *bool) error {
+	updates := map[string]interface{}{}
+	if displayName != nil {
+		updates["display_name"] = *displayName
+	}
+	if icon != nil {
+		updates["icon"] = *icon
+	}
+	if color != nil {
+		updates["color"] = *color
+	}
+	if isArchived != nil {
+		updates["is_archived"] = *isArchived
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return DBSQLite.Model(&Device{}).Where("device_id = ?", deviceID).Updates(updates).Error
+}
+
+// Delete removes the device with all of its sessions, trouble codes, vehicle profiles, dashboards and pending transfer,
+// and revokes the upload keys bound to it.
+func (device *Device) Delete(store TimeSeriesStore) error {
+	if err := device.deleteSessions(store); err != nil {
+		return err
+	}
+
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&DeviceDTC{}, &VehicleProfile{}, &DeviceTransfer{}} {
+			if err := tx.Where("device_id = ?", device.DeviceID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := device.releaseUserSetup(tx); err != nil {
+			return err
+		}
+		return tx.Delete(device).Error
+	})
+}
+
+// Transfer moves the device to another user, e.g. when the car is sold, and removes its pending transfer.
+// With keepHistory, its sessions, trouble codes and vehicle profiles are moved with it, otherwise they are deleted first.
+// The dashboards and alert rules defined for the device, the alerts, geofence events and share links of its sessions, the upload keys
+// bound to it, the vehicles and the geofences belong to the previous owner: the dashboards, rules, alerts, geofence events and share links
+// are deleted, the keys revoked, the sessions unassigned from the vehicles and geofences and the titles generated from the geofences cleared.
+func (device *Device) Transfer(store TimeSeriesStore, userID uint, keepHistory bool) error {
+	if !keepHistory {
+		if err := device.deleteSessions(store); err != nil {
+			return err
+		}
+	}
+
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if !keepHistory {
+			for _, model := range []interface{}{&DeviceDTC{}, &VehicleProfile{}} {
+				if err := tx.Where("device_id = ?", device.DeviceID).Delete(model).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Where("device_id = ?", device.DeviceID).Delete(&DeviceTransfer{}).Error; err != nil {
+			return err
+		}
+
+		sessionIDs := tx.Model(&Session{}).Select("session_id").Where("device_id = ? AND user_id = ?", device.DeviceID, device.UserID)
+
+		// The alerts were triggered by the rules, the geofence events by the geofences of the previous owner,
+		// the links were shared by the previous owner
+		for _, model := range []interface{}{&AlertEvent{}, &GeofenceEvent{}, &ShareLink{}} {
+			if err := tx.Where("session_id IN (?)", sessionIDs).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		err := tx.Model(&Session{}).
+			Where("device_id = ? AND user_id = ? AND is_auto_title = ?", device.DeviceID, device.UserID, true).
+			Updates(map[string]interface{}{"title": "", "is_auto_title": false}).Error
+		if err != nil {
+			return err
+		}
+		// The records of the sessions are moved first, the subquery selects the sessions by their current owner
+		for _, model := range []interface{}{&SessionField{}, &SessionStat{}, &SessionEvent{}, &SessionTag{}, &SessionAlias{}} {
+			if err := tx.Model(model).Where("session_id IN (?)", sessionIDs).Update("user_id", userID).Error; err != nil {
+				return err
+			}
+		}
+		// The vehicles and the geofences stay with the previous owner
+		err = tx.Model(&Session{}).
+			Where("device_id = ? AND user_id = ?", device.DeviceID, device.UserID).
+			Updates(map[string]interface{}{"user_id": userID, "vehicle_id": nil, "start_geofence_id": nil, "end_geofence_id": nil}).Error
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&DeviceDTC{}).Where("device_id = ?", device.DeviceID).Update("user_id", userID).Error; err != nil {
+			return err
+		}
+		err = tx.Model(&VehicleProfile{}).
+			Where("device_id = ?", device.DeviceID).
+			Updates(map[string]interface{}{"user_id": userID, "vehicle_id": nil}).Error
+		if err != nil {
+			return err
+		}
+		if err := device.releaseUserSetup(tx); err != nil {
+			return err
+		}
+
+		if err := tx.Model(device).Update("user_id", userID).Error; err != nil {
+			return err
+		}
+		device.UserID = userID
+		return nil
+	})
+}
+
+// deleteSessions deletes the sessions of the device and its owner with their data
+func (device *Device) deleteSessions(store TimeSeriesStore) error {
+	sessions, err := device.GetSessions(SessionFilter{})
+	if err != nil {
+		return err
+	}
+	for i := range sessions {
+		if err := sessions[i].Delete(store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseUserSetup deletes the dashboards and alert rules the owner defined for the device and revokes the upload keys bound to it
+func (device *Device) releaseUserSetup(tx *gorm.DB) error {
+	var dashboardIDs []uint
+	err := tx.Model(&Dashboard{}).Where("user_id = ? AND device_id = ?", device.UserID, device.DeviceID).Pluck("id", &dashboardIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, dashboardID := range dashboardIDs {
+		if err := deleteDashboardCharts(tx, dashboardID); err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("id IN ?", dashboardIDs).Delete(&Dashboard{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("user_id = ? AND device_id = ?", device.UserID, device.DeviceID).Delete(&AlertRule{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&UploadKey{}).
+		Where("user_id = ? AND device_id = ? AND revoked_at IS NULL", device.UserID, device.DeviceID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// GetSessions retrieves the sessions associated with this device and its user that match the filter,
 // ordered by end_time in descending order.
-func (device *Device) GetSessions() ([]Session, error) {
-	return SessionListGetByUserAndDeviceID(device.UserID, device.DeviceID)
+func (device *Device) GetSessions(filter SessionFilter) ([]Session, error) {
+	return SessionListGetByUserAndDeviceID(device.UserID, device.DeviceID, filter)
 }
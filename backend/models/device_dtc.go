@@ -0,0 +1,87 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dtcPattern matches diagnostic trouble codes, e.g. P0420 or U0100
+var dtcPattern = regexp.MustCompile(`\b[PCBU][0-3][0-9A-F]{3}\b`)
+
+// DeviceDTC is a diagnostic trouble code reported by a device, with the first and the last time it was seen.
+type DeviceDTC struct {
+	ID             uint      `gorm:"primarykey;autoIncrement"`
+	DeviceID       string    `gorm:"column:device_id;uniqueIndex:idx_device_dtc_unique_code;not null"`
+	UserID         uint      `gorm:"column:user_id;index:idx_device_dtc_user_id;not null"`
+	Code           string    `gorm:"column:code;uniqueIndex:idx_device_dtc_unique_code;not null"`
+	FirstSeenAt    time.Time `gorm:"column:first_seen_at;not null"`
+	LastSeenAt     time.Time `gorm:"column:last_seen_at;not null"`
+	FirstSessionID string    `gorm:"column:first_session_id"`
+	LastSessionID  string    `gorm:"column:last_session_id"`
+	Count          int       `gorm:"column:count;default:0"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*DeviceDTC) TableName() string {
+	return "device_dtcs"
+}
+
+// ParseDTCCodes returns the distinct diagnostic trouble codes mentioned in a notice text.
+func ParseDTCCodes(text string) []string {
+	var codes []string
+	for _, code := range dtcPattern.FindAllString(strings.ToUpper(text), -1) {
+		if !slices.Contains(codes, code) {
+			codes = append(codes, code)
+		}
+	}
+	return codes
+}
+
+// deviceDTCRecord records within the transaction that the device reported the code during the session.
+// The first report creates the record, later ones update the last seen time and the count.
+// Returns the record and whether it was created.
+func deviceDTCRecord(tx *gorm.DB, userID uint, deviceID string, sessionID string, code string, seenAt time.Time) (DeviceDTC, bool, error) {
+	var dtc DeviceDTC
+	err := tx.Where("device_id = ? AND code = ?", deviceID, code).First(&dtc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		dtc = DeviceDTC{
+			DeviceID:       deviceID,
+			UserID:         userID,
+			Code:           code,
+			FirstSeenAt:    seenAt,
+			LastSeenAt:     seenAt,
+			FirstSessionID: sessionID,
+			LastSessionID:  sessionID,
+			Count:          1,
+		}
+		return dtc, true, tx.Create(&dtc).Error
+	}
+	if err != nil {
+		return dtc, false, err
+	}
+
+	updates := map[string]interface{}{"count": dtc.Count + 1}
+	if seenAt.After(dtc.LastSeenAt) {
+		updates["last_seen_at"] = seenAt
+		updates["last_session_id"] = sessionID
+	}
+	if seenAt.Before(dtc.FirstSeenAt) {
+		updates["first_seen_at"] = seenAt
+		updates["first_session_id"] = sessionID
+	}
+	return dtc, false, tx.Model(&dtc).Updates(updates).Error
+}
+
+// DeviceDTCListGetByDeviceID retrieves the trouble codes of a device of the user, most recently seen first.
+func DeviceDTCListGetByDeviceID(userID uint, deviceID string) ([]DeviceDTC, error) {
+	var dtcs []DeviceDTC
+	err := DBSQLite.Where("user_id = ? AND device_id = ?", userID, deviceID).
+		Order("last_seen_at DESC").
+		Find(&dtcs).Error
+	return dtcs, err
+}
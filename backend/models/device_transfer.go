@@ -0,0 +1,62 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// DeviceTransfer is a pending transfer of a device to another user, e.g. when the car is sold.
+// The device and its history stay with the owner until the recipient accepts the transfer.
+// A device has at most one pending transfer, a new request replaces it.
+type DeviceTransfer struct {
+	ID          uint      `gorm:"primarykey;autoIncrement"`
+	DeviceID    string    `gorm:"column:device_id;uniqueIndex:idx_device_transfer_unique_device_id;not null"`
+	FromUserID  uint      `gorm:"column:from_user_id;index:idx_device_transfer_from_user_id;not null"`
+	ToUserID    uint      `gorm:"column:to_user_id;index:idx_device_transfer_to_user_id;not null"`
+	KeepHistory bool      `gorm:"column:keep_history;not null"` // the sessions, trouble codes and vehicle profiles are transferred, deleted otherwise
+	CreatedAt   time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+
+	FromUser User `gorm:"foreignKey:FromUserID;references:ID" json:"-"`
+	ToUser   User `gorm:"foreignKey:ToUserID;references:ID" json:"-"`
+}
+
+func (*DeviceTransfer) TableName() string {
+	return "device_transfers"
+}
+
+// DeviceTransferCreate inserts a pending transfer, replacing the pending transfer of the device if there is one.
+func DeviceTransferCreate(transfer *DeviceTransfer) error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("device_id = ?", transfer.DeviceID).Delete(&DeviceTransfer{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(transfer).Error
+	})
+}
+
+// DeviceTransferListGetByUserID retrieves the pending transfers of the user's devices and to the user, newest first.
+func DeviceTransferListGetByUserID(userID uint) ([]DeviceTransfer, error) {
+	var transfers []DeviceTransfer
+	err := DBSQLite.Where("from_user_id = ? OR to_user_id = ?", userID, userID).Order("created_at DESC, id DESC").Find(&transfers).Error
+	return transfers, err
+}
+
+// DeviceTransferGetByID retrieves a pending transfer by its ID if the user is its sender or recipient.
+func DeviceTransferGetByID(userID uint, id uint) (DeviceTransfer, error) {
+	var transfer DeviceTransfer
+	err := DBSQLite.Where("id = ? AND (from_user_id = ? OR to_user_id = ?)", id, userID, userID).First(&transfer).Error
+	return transfer, err
+}
+
+// DeviceTransferDelete cancels or declines a pending transfer the user is the sender or the recipient of.
+// Returns gorm.ErrRecordNotFound if the user has no pending transfer with the given ID.
+func DeviceTransferDelete(userID uint, id uint) error {
+	result := DBSQLite.Where("id = ? AND (from_user_id = ? OR to_user_id = ?)", id, userID, userID).Delete(&DeviceTransfer{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package models
+
+import "math"
+
+// lttb reduces a series to at most threshold points with the Largest-Triangle-Three-Buckets algorithm,
+// which keeps the visual shape of the series. The first and last points are always kept.
+func lttb(times []int64, values []float64, threshold int) ([]int64, []float64) {
+	if threshold >= len(times) || threshold < 3 {
+		return times, values
+	}
+
+	sampledTimes := make([]int64, 0, threshold)
+	sampledValues := make([]float64, 0, threshold)
+	sampledTimes = append(sampledTimes, times[0])
+	sampledValues = append(sampledValues, values[0])
+
+	bucketSize := float64(len(times)-2) / float64(threshold-2)
+	selected := 0
+
+	for bucket := 0; bucket < threshold-2; bucket++ {
+		// Average of the next bucket, the third corner of the triangle
+		nextStart := int(float64(bucket+1)*bucketSize) + 1
+		nextEnd := min(int(float64(bucket+2)*bucketSize)+1, len(times))
+		var avgTime, avgValue float64
+		for i := nextStart; i < nextEnd; i++ {
+			avgTime += float64(times[i])
+			avgValue += values[i]
+		}
+		count := float64(nextEnd - nextStart)
+		avgTime /= count
+		avgValue /= count
+
+		// Point of the current bucket forming the largest triangle with the previously selected point
+		start := int(float64(bucket)*bucketSize) + 1
+		end := int(float64(bucket+1)*bucketSize) + 1
+		prevTime, prevValue := float64(times[selected]), values[selected]
+		maxArea := -1.0
+		for i := start; i < end; i++ {
+			area := math.Abs((prevTime-avgTime)*(values[i]-prevValue) - (prevTime-float64(times[i]))*(avgValue-prevValue))
+			if area > maxArea {
+				maxArea = area
+				selected = i
+			}
+		}
+
+		sampledTimes = append(sampledTimes, times[selected])
+		sampledValues = append(sampledValues, values[selected])
+	}
+
+	sampledTimes = append(sampledTimes, times[len(times)-1])
+	sampledValues = append(sampledValues, values[len(values)-1])
+
+	return sampledTimes, sampledValues
+}
+
+// decimate keeps at most threshold evenly spaced items, including the first and the last one.
+func decimate[T any](items []T, threshold int) []T {
+	if threshold >= len(items) || threshold < 2 {
+		return items
+	}
+
+	decimated := make([]T, 0, threshold)
+	step := float64(len(items)-1) / float64(threshold-1)
+	for i := 0; i < threshold; i++ {
+		decimated = append(decimated, items[int(math.Round(float64(i)*step))])
+	}
+	return decimated
+}
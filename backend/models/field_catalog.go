@@ -0,0 +1,68 @@
+package models
+
+import "strings"
+
+// FieldMetadata describes a stored field for display.
+type FieldMetadata struct {
+	Key       string       `json:"key"`  // key of the stored field, e.g. kd
+	Code      UserDataCode `json:"code"` // zero padded code, e.g. k0d
+	ShortName string       `json:"shortName"`
+	FullName  string       `json:"fullName"`
+	Unit      string       `json:"unit"` // unit of the stored values
+}
+
+// FieldCatalog resolves the names and units of the fields of a session.
+// Names and units sent by Torque for the session take precedence over the built-in UserDataItems.
+type FieldCatalog struct {
+	sessionFields map[string]SessionField // keyed by SessionField.FieldKey, e.g. 0d or ff1201
+}
+
+// FieldCatalogGetBySessionID loads the field definitions uploaded for a session.
+func FieldCatalogGetBySessionID(sessionID string) (FieldCatalog, error) {
+	var fields []SessionField
+	if err := DBSQLite.Where("session_id = ?", sessionID).Find(&fields).Error; err != nil {
+		return FieldCatalog{}, err
+	}
+
+	catalog := FieldCatalog{sessionFields: make(map[string]SessionField, len(fields))}
+	for _, field := range fields {
+		catalog.sessionFields[strings.ToLower(field.FieldKey)] = field
+	}
+	return catalog, nil
+}
+
+// Metadata returns the description of a stored field.
+// Custom PIDs are described by the definitions sent by Torque, fields without any are named after their key.
+func (catalog FieldCatalog) Metadata(key string) FieldMetadata {
+	code := UserDataCodeFromFieldKey(key)
+	metadata := FieldMetadata{
+		Key:       key,
+		Code:      code,
+		ShortName: key,
+		FullName:  key,
+	}
+
+	item, known := UserDataItems[code]
+	if known {
+		metadata.ShortName = item.ShortName
+		metadata.FullName = item.FullName
+		metadata.Unit = item.Unit
+	}
+
+	if field, exists := catalog.sessionFields[strings.TrimPrefix(string(code), "k")]; exists {
+		if field.ShortName != "" {
+			metadata.ShortName = field.ShortName
+		}
+		if field.FullName != "" {
+			metadata.FullName = field.FullName
+		}
+		if field.DefaultUnit != "" {
+			metadata.Unit = field.DefaultUnit
+		} else if !known && field.Unit != "" {
+			// Custom PIDs have no default unit, their values are in the unit they were defined with
+			metadata.Unit = field.Unit
+		}
+	}
+
+	return metadata
+}
@@ -0,0 +1,314 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"math"
+	"time"
+)
+
+// Shapes of the geofences
+const (
+	GeofenceTypeCircle  = "circle"
+	GeofenceTypePolygon = "polygon"
+)
+
+// Types of the geofence events
+const (
+	GeofenceEventEnter = "enter"
+	GeofenceEventExit  = "exit"
+)
+
+// geofenceConfirmPoints is the number of consecutive positions on the other side of a geofence boundary
+// needed for an enter or exit, so GPS jitter at the boundary does not record spurious events
+const geofenceConfirmPoints = 2
+
+// Geofence is a named area of the user, e.g. Home, Work or Garage, either a circle or a polygon.
+type Geofence struct {
+	ID        uint        `gorm:"primarykey;autoIncrement"`
+	UserID    uint        `gorm:"column:user_id;index:idx_geofence_user_id;not null"`
+	Name      string      `gorm:"column:name;not null"`
+	Type      string      `gorm:"column:type;not null"`
+	Latitude  float64     `gorm:"column:latitude"`                // center of a circle
+	Longitude float64     `gorm:"column:longitude"`               // center of a circle
+	Radius    float64     `gorm:"column:radius"`                  // radius of a circle in meters
+	Polygon   [][]float64 `gorm:"column:polygon;serializer:json"` // [lat, lon] vertices of a polygon
+	CreatedAt time.Time   `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time   `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*Geofence) TableName() string {
+	return "geofences"
+}
+
+// GeofenceEvent is the enter or exit of a geofence by a device during a session.
+// Time and position are those of the first sample on the other side of the boundary.
+type GeofenceEvent struct {
+	ID           uint      `gorm:"primarykey;autoIncrement"`
+	GeofenceID   uint      `gorm:"column:geofence_id;index:idx_geofence_event_geofence_id;not null"`
+	UserID       uint      `gorm:"column:user_id;index:idx_geofence_event_user_id;not null"`
+	DeviceID     string    `gorm:"column:device_id;index:idx_geofence_event_device_id;not null"`
+	SessionID    string    `gorm:"column:session_id;index:idx_geofence_event_session_id;not null"`
+	GeofenceName string    `gorm:"column:geofence_name"` // name of the geofence when the event was recorded
+	Type         string    `gorm:"column:type;not null"`
+	Time         time.Time `gorm:"column:time;index:idx_geofence_event_time;not null"`
+	Latitude     float64   `gorm:"column:latitude"`
+	Longitude    float64   `gorm:"column:longitude"`
+	CreatedAt    time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*GeofenceEvent) TableName() string {
+	return "geofence_events"
+}
+
+// GeofenceEventFilter narrows the geofence events listed, zero values do not filter
+type GeofenceEventFilter struct {
+	DeviceID   string
+	SessionID  string
+	GeofenceID uint
+	From       *time.Time
+	To         *time.Time
+}
+
+// Contains reports whether the position is inside the geofence.
+func (geofence *Geofence) Contains(lat, lon float64) bool {
+	if geofence.Type == GeofenceTypeCircle {
+		return haversineKm(geofence.Latitude, geofence.Longitude, lat, lon)*1000 <= geofence.Radius
+	}
+
+	// Ray casting, the polygons are small enough to treat the coordinates as planar
+	inside := false
+	for i, j := 0, len(geofence.Polygon)-1; i < len(geofence.Polygon); j, i = i, i+1 {
+		a, b := geofence.Polygon[i], geofence.Polygon[j]
+		if (a[0] > lat) != (b[0] > lat) && lon < (b[1]-a[1])*(lat-a[0])/(b[0]-a[0])+a[1] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// area returns the approximate area of the geofence in square meters
+func (geofence *Geofence) area() float64 {
+	if geofence.Type == GeofenceTypeCircle {
+		return math.Pi * geofence.Radius * geofence.Radius
+	}
+
+	// Shoelace formula on an equirectangular projection around the first vertex
+	metersPerDegree := earthRadiusKm * 1000 * math.Pi / 180
+	cosLat := math.Cos(geofence.Polygon[0][0] * math.Pi / 180)
+	var sum float64
+	for i, j := 0, len(geofence.Polygon)-1; i < len(geofence.Polygon); j, i = i, i+1 {
+		a, b := geofence.Polygon[i], geofence.Polygon[j]
+		sum += (b[1]*cosLat*metersPerDegree)*(a[0]*metersPerDegree) - (a[1]*cosLat*metersPerDegree)*(b[0]*metersPerDegree)
+	}
+	return math.Abs(sum) / 2
+}
+
+// GeofenceCreate inserts a new geofence record into the database.
+func GeofenceCreate(geofence *Geofence) error {
+	return DBSQLite.Create(geofence).Error
+}
+
+// GeofenceGetByID retrieves a geofence of the user by its ID.
+func GeofenceGetByID(userID uint, id uint) (Geofence, error) {
+	var geofence Geofence
+	err := DBSQLite.Where("id = ? AND user_id = ?", id, userID).First(&geofence).Error
+	return geofence, err
+}
+
+// GeofenceListGetByUserID retrieves the geofences of a user ordered by name.
+func GeofenceListGetByUserID(userID uint) ([]Geofence, error) {
+	var geofences []Geofence
+	err := DBSQLite.Where("user_id = ?", userID).Order("name ASC, id ASC").Find(&geofences).Error
+	return geofences, err
+}
+
+// GeofenceUpdate updates the name and the shape of a geofence.
+// Sessions recorded earlier keep their events and start and end geofences until they are detected again.
+func GeofenceUpdate(geofence *Geofence) error {
+	geofence.UpdatedAt = time.Now()
+	return DBSQLite.Model(geofence).
+		Select("name", "type", "latitude", "longitude", "radius", "polygon", "updated_at").
+		Updates(geofence).Error
+}
+
+// Delete removes the geofence. Its events are kept, the sessions starting or ending in it lose the reference.
+func (geofence *Geofence) Delete() error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		for _, column := range []string{"start_geofence_id", "end_geofence_id"} {
+			if err := tx.Model(&Session{}).Where(column+" = ?", geofence.ID).Update(column, nil).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(geofence).Error
+	})
+}
+
+// GeofenceEventListGetBySessionID retrieves the geofence events of a session of the user in chronological order.
+func GeofenceEventListGetBySessionID(sessionID string, userID uint) ([]GeofenceEvent, error) {
+	var events []GeofenceEvent
+	err := DBSQLite.Where("session_id = ? AND user_id = ?", sessionID, userID).
+		Order("time ASC, id ASC").
+		Find(&events).Error
+	return events, err
+}
+
+// GeofenceEventListGetByUserID retrieves the geofence events of a user matching the filter, most recent first.
+// At most limit events are returned.
+func GeofenceEventListGetByUserID(userID uint, filter GeofenceEventFilter, limit int) ([]GeofenceEvent, error) {
+	query := DBSQLite.Where("user_id = ?", userID)
+	if filter.DeviceID != "" {
+		query = query.Where("device_id = ?", filter.DeviceID)
+	}
+	if filter.SessionID != "" {
+		query = query.Where("session_id = ?", filter.SessionID)
+	}
+	if filter.GeofenceID != 0 {
+		query = query.Where("geofence_id = ?", filter.GeofenceID)
+	}
+	if filter.From != nil {
+		query = query.Where("time >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("time <= ?", *filter.To)
+	}
+
+	var events []GeofenceEvent
+	err := query.Order("time DESC, id DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// geofenceCrossing is a position on the other side of a geofence boundary waiting to be confirmed
+type geofenceCrossing struct {
+	time     time.Time
+	lat, lon float64
+	points   int
+}
+
+// DetectGeofences finds the geofences of the user entered and exited during this session from its GPS track,
+// and the geofences it started and ended in, the smallest one if several contain the position.
+// The events of the session are replaced. An empty or generated title is set to the name of the trip, e.g. Home → Work.
+func (session *Session) DetectGeofences(store TimeSeriesStore) ([]GeofenceEvent, error) {
+	geofences, err := GeofenceListGetByUserID(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]GeofenceEvent, 0)
+	inside := make([]bool, len(geofences))
+	crossings := make([]*geofenceCrossing, len(geofences))
+	var first, last []float64
+
+	options := SessionDataOptions{Fields: []string{statFieldLatitude, statFieldLongitude}}
+	err = session.EachSessionPoint(store, options, func(point TimeSeriesPoint) error {
+		lat, lon, ok := pointPosition(point)
+		if !ok || (lat == 0 && lon == 0) {
+			return nil
+		}
+		if first == nil {
+			first = []float64{lat, lon}
+			for i := range geofences {
+				inside[i] = geofences[i].Contains(lat, lon)
+			}
+		}
+		last = []float64{lat, lon}
+
+		for i := range geofences {
+			if geofences[i].Contains(lat, lon) == inside[i] {
+				crossings[i] = nil
+				continue
+			}
+			if crossings[i] == nil {
+				crossings[i] = &geofenceCrossing{time: point.Time, lat: lat, lon: lon}
+			}
+			crossings[i].points++
+			if crossings[i].points < geofenceConfirmPoints {
+				continue
+			}
+
+			inside[i] = !inside[i]
+			event := GeofenceEvent{
+				GeofenceID:   geofences[i].ID,
+				UserID:       session.UserID,
+				DeviceID:     session.DeviceID,
+				SessionID:    session.SessionID,
+				GeofenceName: geofences[i].Name,
+				Type:         GeofenceEventExit,
+				Time:         crossings[i].time,
+				Latitude:     crossings[i].lat,
+				Longitude:    crossings[i].lon,
+			}
+			if inside[i] {
+				event.Type = GeofenceEventEnter
+			}
+			events = append(events, event)
+			crossings[i] = nil
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var start, end *Geofence
+	if first != nil {
+		start = smallestGeofence(geofences, first[0], first[1])
+		end = smallestGeofence(geofences, last[0], last[1])
+	}
+
+	updates := map[string]interface{}{"start_geofence_id": nil, "end_geofence_id": nil}
+	session.StartGeofenceID, session.EndGeofenceID = nil, nil
+	if start != nil {
+		updates["start_geofence_id"], session.StartGeofenceID = start.ID, &start.ID
+	}
+	if end != nil {
+		updates["end_geofence_id"], session.EndGeofenceID = end.ID, &end.ID
+	}
+	if session.Title == "" || session.IsAutoTitle {
+		session.Title = geofenceTripTitle(start, end)
+		session.IsAutoTitle = session.Title != ""
+		updates["title"], updates["is_auto_title"] = session.Title, session.IsAutoTitle
+	}
+
+	err = DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", session.SessionID).Delete(&GeofenceEvent{}).Error; err != nil {
+			return err
+		}
+		if len(events) > 0 {
+			if err := tx.Create(&events).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&Session{}).Where("session_id = ?", session.SessionID).Updates(updates).Error
+	})
+	return events, err
+}
+
+// smallestGeofence returns the smallest geofence containing the position, nil if none does
+func smallestGeofence(geofences []Geofence, lat, lon float64) *Geofence {
+	var smallest *Geofence
+	for i := range geofences {
+		if geofences[i].Contains(lat, lon) && (smallest == nil || geofences[i].area() < smallest.area()) {
+			smallest = &geofences[i]
+		}
+	}
+	return smallest
+}
+
+// geofenceTripTitle names a trip after the geofences it started and ended in, empty if neither is known
+func geofenceTripTitle(start, end *Geofence) string {
+	switch {
+	case start != nil && end != nil && start.ID == end.ID:
+		return start.Name + " round trip"
+	case start != nil && end != nil:
+		return start.Name + " → " + end.Name
+	case start != nil:
+		return "From " + start.Name
+	case end != nil:
+		return "To " + end.Name
+	}
+	return ""
+}
@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"maps"
+	"time"
+)
+
+// Modes of the privacy zones
+const (
+	PrivacyZoneModeDrop = "drop" // positions within the zone are removed
+	PrivacyZoneModeSnap = "snap" // positions within the zone are moved to its centre
+)
+
+// privacyFields are the GPS fields masked within the privacy zones. The altitude is removed in both modes.
+var privacyFields = []string{statFieldLatitude, statFieldLongitude, "kff1010"}
+
+// PrivacyZone is a circle around a sensitive location of the user, e.g. home, where the GPS position is masked
+// in the session data, exports and shares. Snapping reveals the centre, so it should not be the location itself.
+// With DropAtIngest the positions are also masked before the samples are stored, so they cannot be recovered.
+type PrivacyZone struct {
+	ID           uint      `gorm:"primarykey;autoIncrement"`
+	UserID       uint      `gorm:"column:user_id;index:idx_privacy_zone_user_id;not null"`
+	Name         string    `gorm:"column:name;not null"`
+	Latitude     float64   `gorm:"column:latitude;not null"`
+	Longitude    float64   `gorm:"column:longitude;not null"`
+	Radius       float64   `gorm:"column:radius;not null"` // meters
+	Mode         string    `gorm:"column:mode;not null"`
+	DropAtIngest bool      `gorm:"column:drop_at_ingest;not null"`
+	CreatedAt    time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*PrivacyZone) TableName() string {
+	return "privacy_zones"
+}
+
+// PrivacyZones are the privacy zones applied to the data of a user
+type PrivacyZones []PrivacyZone
+
+// PrivacyZoneCreate inserts a new privacy zone record into the database.
+func PrivacyZoneCreate(zone *PrivacyZone) error {
+	return DBSQLite.Create(zone).Error
+}
+
+// PrivacyZoneGetByID retrieves a privacy zone of the user by its ID.
+func PrivacyZoneGetByID(userID uint, id uint) (PrivacyZone, error) {
+	var zone PrivacyZone
+	err := DBSQLite.Where("id = ? AND user_id = ?", id, userID).First(&zone).Error
+	return zone, err
+}
+
+// PrivacyZoneListGetByUserID retrieves the privacy zones of a user ordered by name.
+func PrivacyZoneListGetByUserID(userID uint) (PrivacyZones, error) {
+	var zones PrivacyZones
+	err := DBSQLite.Where("user_id = ?", userID).Order("name ASC, id ASC").Find(&zones).Error
+	return zones, err
+}
+
+// PrivacyZoneListGetIngest retrieves the privacy zones of a user applied before the samples are stored.
+func PrivacyZoneListGetIngest(userID uint) (PrivacyZones, error) {
+	var zones PrivacyZones
+	err := DBSQLite.Where("user_id = ? AND drop_at_ingest = ?", userID, true).Order("id ASC").Find(&zones).Error
+	return zones, err
+}
+
+// PrivacyZoneUpdate updates the settings of a privacy zone.
+func PrivacyZoneUpdate(zone *PrivacyZone) error {
+	zone.UpdatedAt = time.Now()
+	return DBSQLite.Model(zone).
+		Select("name", "latitude", "longitude", "radius", "mode", "drop_at_ingest", "updated_at").
+		Updates(zone).Error
+}
+
+// Delete removes the privacy zone. Positions dropped at ingest are not restored.
+func (zone *PrivacyZone) Delete() error {
+	return DBSQLite.Delete(zone).Error
+}
+
+// ZoneAt returns the privacy zone masking the position, nil if none contains it.
+// A dropping zone takes precedence over a snapping one.
+func (zones PrivacyZones) ZoneAt(lat, lon float64) *PrivacyZone {
+	var found *PrivacyZone
+	for i := range zones {
+		if haversineKm(zones[i].Latitude, zones[i].Longitude, lat, lon)*1000 > zones[i].Radius {
+			continue
+		}
+		if zones[i].Mode == PrivacyZoneModeDrop {
+			return &zones[i]
+		}
+		if found == nil {
+			found = &zones[i]
+		}
+	}
+	return found
+}
+
+// MaskFields masks the GPS fields of a data point within the privacy zones. The fields are returned unchanged
+// outside the zones, otherwise a copy is returned with the position dropped or snapped to the centre of the zone.
+func (zones PrivacyZones) MaskFields(fields map[string]interface{}) map[string]interface{} {
+	if len(zones) == 0 {
+		return fields
+	}
+	lat, latOK := statValue(fields, statFieldLatitude)
+	lon, lonOK := statValue(fields, statFieldLongitude)
+	if !latOK || !lonOK {
+		return fields
+	}
+	zone := zones.ZoneAt(lat, lon)
+	if zone == nil {
+		return fields
+	}
+
+	masked := maps.Clone(fields)
+	for _, field := range privacyFields {
+		delete(masked, field)
+	}
+	if zone.Mode == PrivacyZoneModeSnap {
+		masked[statFieldLatitude] = zone.Latitude
+		masked[statFieldLongitude] = zone.Longitude
+	}
+	return masked
+}
+
+// query calls fn for every point matching the query in chronological order with the GPS fields masked.
+// Averaging would mix the positions within the zones into the windows, so the raw points are masked first
+// and averaged afterwards, one window at a time. The averages are masked again, as positions around a zone
+// can average to one within it.
+func (zones PrivacyZones) query(store TimeSeriesStore, query TimeSeriesQuery, fn func(point TimeSeriesPoint) error) error {
+	masked := func(point TimeSeriesPoint) error {
+		point.Fields = zones.MaskFields(point.Fields)
+		return fn(point)
+	}
+	if len(zones) == 0 || query.Every == 0 {
+		return store.Query(context.Background(), query, masked)
+	}
+
+	raw := query
+	raw.Every = 0
+	var window []TimeSeriesPoint
+	emit := func() error {
+		for _, point := range aggregateWindows(window, query) {
+			if err := masked(point); err != nil {
+				return err
+			}
+		}
+		window = window[:0]
+		return nil
+	}
+
+	err := store.Query(context.Background(), raw, func(point TimeSeriesPoint) error {
+		if len(window) > 0 && !query.windowStart(point.Time).Equal(query.windowStart(window[0].Time)) {
+			if err := emit(); err != nil {
+				return err
+			}
+		}
+		point.Fields = zones.MaskFields(point.Fields)
+		window = append(window, point)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return emit()
+}
@@ -0,0 +1,92 @@
+package models
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPrivacyZonesQuery(t *testing.T) {
+	home := func(mode string) PrivacyZones {
+		return PrivacyZones{{Name: "Home", Latitude: 47.5, Longitude: 19.05, Radius: 100, Mode: mode}}
+	}
+	position := func(lat float64, speed float64) map[string]interface{} {
+		return map[string]interface{}{statFieldLatitude: lat, statFieldLongitude: 19.05, "kff1010": 120.0, "kd": speed}
+	}
+
+	// Two points at home and two outside in the first window, one at home in the second
+	store := NewMemoryTimeSeriesStore()
+	err := store.Write(context.Background(),
+		memoryTestPoint(0, position(47.5, 10)),
+		memoryTestPoint(1, position(47.5, 20)),
+		memoryTestPoint(2, position(47.51, 30)),
+		memoryTestPoint(3, position(47.51, 40)),
+		memoryTestPoint(10, position(47.5, 50)),
+	)
+	if err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		zones      PrivacyZones
+		wantFields []map[string]interface{} // fields of the windows
+	}{
+		{
+			name:  "without zones",
+			zones: nil,
+			wantFields: []map[string]interface{}{
+				{statFieldLatitude: 47.505, statFieldLongitude: 19.05, "kff1010": 120.0, "kd": 25.0},
+				{statFieldLatitude: 47.5, statFieldLongitude: 19.05, "kff1010": 120.0, "kd": 50.0},
+			},
+		},
+		{
+			name:  "dropped positions are not averaged",
+			zones: home(PrivacyZoneModeDrop),
+			wantFields: []map[string]interface{}{
+				{statFieldLatitude: 47.51, statFieldLongitude: 19.05, "kff1010": 120.0, "kd": 25.0},
+				{"kd": 50.0},
+			},
+		},
+		{
+			name:  "snapped positions are averaged",
+			zones: home(PrivacyZoneModeSnap),
+			wantFields: []map[string]interface{}{
+				{statFieldLatitude: 47.505, statFieldLongitude: 19.05, "kff1010": 120.0, "kd": 25.0},
+				{statFieldLatitude: 47.5, statFieldLongitude: 19.05, "kd": 50.0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query := memoryTestQuery(0, 20)
+			query.Every = 10 * time.Second
+
+			var got []map[string]interface{}
+			err := test.zones.query(store, query, func(point TimeSeriesPoint) error {
+				got = append(got, point.Fields)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("query: %v", err)
+			}
+
+			if len(got) != len(test.wantFields) {
+				t.Fatalf("windows = %v, want %v", got, test.wantFields)
+			}
+			for i, want := range test.wantFields {
+				if len(got[i]) != len(want) {
+					t.Errorf("window %d = %v, want %v", i, got[i], want)
+					continue
+				}
+				for field, value := range want {
+					if number, ok := got[i][field].(float64); !ok || math.Abs(number-value.(float64)) > 1e-9 {
+						t.Errorf("window %d: %s = %v, want %v", i, field, got[i][field], value)
+					}
+				}
+			}
+		})
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"errors"
 	"gorm.io/gorm"
+	"maps"
 	"math"
-	"os"
+	"slices"
 	"strings"
 	"time"
 )
 
+// ErrSessionOwner is returned when a session ID is taken by a session of another device or user
+var ErrSessionOwner = errors.New("session belongs to another device")
+
 type Session struct {
 	ID        uint      `gorm:"primarykey;autoIncrement"`
 	SessionID string    `gorm:"column:session_id;uniqueIndex:idx_sessions_unique_session_id;not null"`
@@ -25,6 +29,17 @@ type Session struct {
 	TotalRecords    int        `gorm:"column:total_records;default:0"`
 	IsActive        bool       `gorm:"column:is_active;default:1"`
 
+	Title       string `gorm:"column:title"`
+	IsAutoTitle bool   `gorm:"column:is_auto_title;default:0"` // the title was generated from the start and end geofences
+	Notes       string `gorm:"column:notes"`
+	IsFavourite bool   `gorm:"column:is_favourite;index:idx_sessions_is_favourite;default:0"`
+
+	StartGeofenceID *uint `gorm:"column:start_geofence_id;index:idx_sessions_start_geofence_id"` // smallest geofence containing the first position
+	EndGeofenceID   *uint `gorm:"column:end_geofence_id;index:idx_sessions_end_geofence_id"`     // smallest geofence containing the last position
+
+	VehicleProfileID *uint `gorm:"column:vehicle_profile_id"` // latest vehicle profile sent during the session
+	VehicleID        *uint `gorm:"column:vehicle_id;index:idx_sessions_vehicle_id"`
+
 	Device Device `gorm:"foreignKey:DeviceID;references:DeviceID"`
 	User   User   `gorm:"foreignKey:UserID;references:ID"`
 }
@@ -35,6 +50,7 @@ func (*Session) TableName() string {
 
 // SessionFindOrCreate finds an existing session by sessionID or creates a new one with the provided details.
 // Returns the session and a boolean indicating whether a new record was created (true) or an existing one was found (false).
+// Returns ErrSessionOwner if the session ID is taken by a session of another device or user.
 func SessionFindOrCreate(sessionID string, deviceID string, userID uint, version int, startTime time.Time) (Session, bool, error) {
 	var session Session
 
@@ -42,7 +58,7 @@ func SessionFindOrCreate(sessionID string, deviceID string, userID uint, version
 	err := DBSQLite.Where("session_id = ?", sessionID).First(&session).Error
 	if err == nil {
 		// Session already exists
-		return session, false, nil
+		return session, false, session.checkOwner(deviceID, userID)
 	}
 
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
@@ -67,7 +83,7 @@ func SessionFindOrCreate(sessionID string, deviceID string, userID uint, version
 		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
 			// Another process created it in the meantime, try to fetch it
 			if findErr := DBSQLite.Where("session_id = ?", sessionID).First(&session).Error; findErr == nil {
-				return session, false, nil
+				return session, false, session.checkOwner(deviceID, userID)
 			}
 		}
 		return session, false, err
@@ -76,6 +92,14 @@ func SessionFindOrCreate(sessionID string, deviceID string, userID uint, version
 	return session, true, nil
 }
 
+// checkOwner returns ErrSessionOwner unless the session belongs to the device of the user
+func (session *Session) checkOwner(deviceID string, userID uint) error {
+	if session.DeviceID != deviceID || session.UserID != userID {
+		return ErrSessionOwner
+	}
+	return nil
+}
+
 // SessionGetByIdentifiers retrieves a session based on session ID, device ID, and user ID.
 // Returns the session and an error if the session was not found.
 func SessionGetByIdentifiers(sessionID string, deviceID string, userID uint) (Session, error) {
@@ -84,132 +108,392 @@ func SessionGetByIdentifiers(sessionID string, deviceID string, userID uint) (Se
 	return session, err
 }
 
-// SessionListGetByUserAndDeviceID retrieves all sessions associated with a specific user ID and device ID,
-// ordered by end_time in descending order.
-func SessionListGetByUserAndDeviceID(userID uint, deviceID string) ([]Session, error) {
+// SessionFilter narrows down session list queries. Zero values do not filter.
+type SessionFilter struct {
+	IsActive    *bool
+	IsFavourite *bool
+	Tag         string     // sessions tagged with Tag, case-insensitive
+	Geofences   []uint     // one geofence: sessions starting or ending in it, two: trips between them in either direction
+	From        *time.Time // sessions started at or after From
+	To          *time.Time // sessions started before To
+}
+
+// SessionGetBySessionID retrieves a session of the given user by its session ID.
+func SessionGetBySessionID(sessionID string, userID uint) (Session, error) {
+	var session Session
+	err := DBSQLite.Where("session_id = ? AND user_id = ?", sessionID, userID).First(&session).Error
+	return session, err
+}
+
+// SessionListGetByUserAndDeviceID retrieves all sessions associated with a specific user ID and device ID
+// that match the filter, ordered by end_time in descending order.
+func SessionListGetByUserAndDeviceID(userID uint, deviceID string, filter SessionFilter) ([]Session, error) {
+	var sessions []Session
+	query := filter.apply(DBSQLite.Where("user_id = ? AND device_id = ?", userID, deviceID), userID)
+	err := query.Order("end_time DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// SessionListGetByUserAndVehicleID retrieves all sessions of a user assigned to a vehicle, recorded with any device,
+// that match the filter, ordered by end_time in descending order.
+func SessionListGetByUserAndVehicleID(userID uint, vehicleID uint, filter SessionFilter) ([]Session, error) {
+	var sessions []Session
+	query := filter.apply(DBSQLite.Where("user_id = ? AND vehicle_id = ?", userID, vehicleID), userID)
+	err := query.Order("end_time DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// apply adds the conditions of the filter to a session query of the user
+func (filter SessionFilter) apply(query *gorm.DB, userID uint) *gorm.DB {
+	if filter.IsActive != nil {
+		query = query.Where("is_active = ?", *filter.IsActive)
+	}
+	if filter.IsFavourite != nil {
+		query = query.Where("is_favourite = ?", *filter.IsFavourite)
+	}
+	if filter.Tag != "" {
+		query = query.Where("session_id IN (?)", DBSQLite.Model(&SessionTag{}).
+			Select("session_id").
+			Where("user_id = ? AND tag = ? COLLATE NOCASE", userID, filter.Tag))
+	}
+	switch len(filter.Geofences) {
+	case 1:
+		query = query.Where("(start_geofence_id = ? OR end_geofence_id = ?)", filter.Geofences[0], filter.Geofences[0])
+	case 2:
+		query = query.Where("(start_geofence_id = ? AND end_geofence_id = ?) OR (start_geofence_id = ? AND end_geofence_id = ?)",
+			filter.Geofences[0], filter.Geofences[1], filter.Geofences[1], filter.Geofences[0])
+	}
+	if filter.From != nil {
+		query = query.Where("start_time >= ?", *filter.From)
+	}
+	if filter.To != nil {
+		query = query.Where("start_time < ?", *filter.To)
+	}
+	return query
+}
+
+// SessionUpdateVehicle assigns a session to a vehicle, or unassigns it for a nil vehicle ID.
+func SessionUpdateVehicle(sessionID string, vehicleID *uint) error {
+	return DBSQLite.Model(&Session{}).Where("session_id = ?", sessionID).Update("vehicle_id", vehicleID).Error
+}
+
+// SessionListGetIdle retrieves all active sessions that have not received an upload since the given time.
+func SessionListGetIdle(lastUploadBefore time.Time) ([]Session, error) {
 	var sessions []Session
-	err := DBSQLite.Where("user_id = ? AND device_id = ?", userID, deviceID).Order("end_time DESC").Find(&sessions).Error
+	err := DBSQLite.Where("is_active = ? AND updated_at < ?", true, lastUploadBefore).Find(&sessions).Error
 	return sessions, err
 }
 
-// SessionUpdateVehicleProfile updates a session's vehicle profile ID.
-// It takes a session ID and a vehicle profile ID, and updates the session record.
-// Returns an error if the update operation fails.
-//func SessionUpdateVehicleProfile(sessionID string, vehicleProfileID uint) error {
-//	result := DBSQLite.Model(&Session{}).
-//		Where("session_id = ?", sessionID).
-//		Update("vehicle_profile_id", vehicleProfileID)
-//
-//	return result.Error
-//}
+// SessionClose marks a session as finished. The end_time is kept as the time of the last data point;
+// the update_session_end_time trigger only fills it in when it is missing.
+func SessionClose(sessionID string) error {
+	result := DBSQLite.Model(&Session{}).
+		Where("session_id = ? AND is_active = ?", sessionID, true).
+		Update("is_active", false)
+
+	return result.Error
+}
+
+// SessionUpdateDetails updates the title, the notes and the favourite mark of a session.
+// Nil values are left unchanged.
+func SessionUpdateDetails(sessionID string, title *string, notes *string, isFavourite *bool) error {
+	updates := map[string]interface{}{}
+	if title != nil {
+		updates["title"] = *title
+		updates["is_auto_title"] = false
+	}
+	if notes != nil {
+		updates["notes"] = *notes
+	}
+	if isFavourite != nil {
+		updates["is_favourite"] = *isFavourite
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	return DBSQLite.Model(&Session{}).Where("session_id = ?", sessionID).Updates(updates).Error
+}
+
+// Delete removes the session with its field definitions, statistics, events, tags, aliases and share links, and its time-series data.
+// The vehicle profiles and trouble codes are kept as the history of the device, unlinked from the session.
+// Buffered samples are flushed first, so none of them are written after the deletion.
+func (session *Session) Delete(store TimeSeriesStore) error {
+	ctx := context.Background()
+	if err := FlushTimeSeries(ctx, store); err != nil {
+		return err
+	}
+
+	// The series is identified by its device and session tags, the time range only has to cover all of it
+	query := session.timeSeriesQuery()
+	query.Start = time.Unix(0, 0)
+	query.Stop = time.Now().Add(24 * time.Hour)
+	if session.EndTime != nil && session.EndTime.After(time.Now()) {
+		query.Stop = session.EndTime.Add(24 * time.Hour)
+	}
+	if err := store.Delete(ctx, query); err != nil {
+		return err
+	}
+
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&SessionField{}, &SessionStat{}, &SessionEvent{}, &SessionTag{}, &SessionAlias{}, &AlertEvent{}, &GeofenceEvent{}, &ShareLink{}} {
+			if err := tx.Where("session_id = ?", session.SessionID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&VehicleProfile{}).Where("session_id = ?", session.SessionID).Update("session_id", "").Error; err != nil {
+			return err
+		}
+		for _, column := range []string{"first_session_id", "last_session_id"} {
+			if err := tx.Model(&DeviceDTC{}).Where(column+" = ?", session.SessionID).Update(column, "").Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(session).Error
+	})
+}
+
+// sessionUpdateVehicleProfile links a session to the vehicle profile snapshot that arrived during it.
+func sessionUpdateVehicleProfile(tx *gorm.DB, sessionID string, vehicleProfileID uint) error {
+	return tx.Model(&Session{}).
+		Where("session_id = ?", sessionID).
+		Update("vehicle_profile_id", vehicleProfileID).Error
+}
 
-// SessionUpdateActivityAndRecords updates session fields such as end_time, is_active, and increments total_records by 1.
+// SessionUpdateActivityAndRecords updates session fields such as end_time, is_active, and increments total_records
+// by the number of records received since the last update.
 // It returns an error if the database operation fails.
-func SessionUpdateActivityAndRecords(sessionID string, endTime time.Time) error {
+func SessionUpdateActivityAndRecords(sessionID string, endTime time.Time, records int) error {
 	result := DBSQLite.Model(&Session{}).
 		Where("session_id = ?", sessionID).
 		Updates(map[string]interface{}{
 			"end_time":      &endTime,
 			"is_active":     true,
-			"total_records": gorm.Expr("total_records + ?", 1),
+			"total_records": gorm.Expr("total_records + ?", records),
+			"updated_at":    time.Now(),
 		})
 
 	return result.Error
 }
 
-// GetSessionData retrieves time-series data for this session from InfluxDB.
+// timeSeriesQuery returns the query selecting the time-series data of this session.
 // It includes data from 10 minutes before the session start time to 10 minutes after the session end time.
-// Returns data, GPS coordinates, and the center point of the captured coordinates.
-func (session *Session) GetSessionData() (map[string]map[string]interface{}, [][]float64, []float64, error) {
-	sessionStartTimeStr := session.StartTime.Add(-10 * time.Minute).Format(time.RFC3339)
-	sessionEndTimeStr := session.EndTime.Add(10 * time.Minute).Format(time.RFC3339)
-
-	query := `from(bucket:"` + os.Getenv("INFLUX_BUCKET") + `")
-    |> range(start: ` + sessionStartTimeStr + `, stop: ` + sessionEndTimeStr + `)
-    |> filter(fn: (r) => r._measurement == "gorque_data")
-    |> filter(fn: (r) => r.id == "` + session.DeviceID + `")
-    |> filter(fn: (r) => r.session == "` + session.SessionID + `")
-    |> sort(columns: ["_time"], desc: false)`
+func (session *Session) timeSeriesQuery() TimeSeriesQuery {
+	endTime := time.Now()
+	if session.EndTime != nil {
+		endTime = *session.EndTime
+	}
 
-	queryAPI := DBInflux.QueryAPI(os.Getenv("INFLUX_ORG"))
+	return TimeSeriesQuery{
+		DeviceID:  session.DeviceID,
+		SessionID: session.SessionID,
+		Start:     session.StartTime.Add(-10 * time.Minute),
+		Stop:      endTime.Add(10 * time.Minute),
+	}
+}
 
-	result, err := queryAPI.Query(context.Background(), query)
-	if err != nil {
-		return nil, nil, nil, err
+// windowForMaxPoints returns the averaging window length yielding at most maxPoints points
+// over the part of the query range covered by the session itself.
+func (session *Session) windowForMaxPoints(query TimeSeriesQuery, maxPoints int) time.Duration {
+	start := query.Start
+	if session.StartTime.After(start) {
+		start = session.StartTime
+	}
+	stop := query.Stop
+	if session.EndTime != nil && session.EndTime.Before(stop) {
+		stop = *session.EndTime
+	} else if now := time.Now(); now.Before(stop) {
+		stop = now
+	}
+	if !stop.After(start) {
+		return 0
 	}
 
-	var orderedTimes []time.Time
-	timeBasedData := make(map[string]map[string]interface{})
-	coords := make([][]float64, 0)
+	// Windows are aligned to the epoch, so the span may touch one window more than it fills
+	windows := time.Duration(max(maxPoints-1, 1))
+	return max((stop.Sub(start)+windows-1)/windows, time.Millisecond)
+}
 
-	for result.Next() {
-		record := result.Record()
-		recordTime := record.Time()
-		timeStr := record.Time().Format(time.RFC3339)
-		fieldName := record.Field()
-		fieldValue := record.Value()
+// GetSessionPoints retrieves the time-series data points of this session in chronological order.
+func (session *Session) GetSessionPoints(store TimeSeriesStore) ([]TimeSeriesPoint, error) {
+	return QueryAll(context.Background(), store, session.timeSeriesQuery())
+}
 
-		if _, exists := timeBasedData[timeStr]; !exists {
-			timeBasedData[timeStr] = make(map[string]interface{})
-			orderedTimes = append(orderedTimes, recordTime)
-		}
+// Downsampling methods of SessionDataOptions.
+const (
+	SessionDataMethodMean = "mean" // average per time window, computed by the time-series store
+	SessionDataMethodLTTB = "lttb" // Largest-Triangle-Three-Buckets, keeps the shape of each series
+)
 
-		timeBasedData[timeStr][fieldName] = fieldValue
+// SessionDataOptions selects and downsamples the time-series data returned by GetSessionData.
+type SessionDataOptions struct {
+	Fields    []string      // fields to return, all fields if empty
+	Start     time.Time     // start of the time window, the session window is used if zero
+	Stop      time.Time     // end of the time window, the session window is used if zero
+	Every     time.Duration // length of the averaging windows, overrides MaxPoints for the mean method
+	MaxPoints int           // maximum number of points per series, 0 disables downsampling
+	Method    string        // SessionDataMethodMean or SessionDataMethodLTTB
+	Privacy   PrivacyZones  // GPS positions within the zones are dropped or snapped
+}
+
+// SessionSeries holds the values of a single field in chronological order.
+type SessionSeries struct {
+	Field  string    `json:"field"`
+	Unit   string    `json:"unit"`  // set by ApplyUnits
+	Times  []int64   `json:"times"` // Unix time in milliseconds
+	Values []float64 `json:"values"`
+}
 
-		for key, value := range record.Values() {
-			if key != "_time" && key != "_value" && key != "_field" && key != "_measurement" {
-				timeBasedData[timeStr][key] = value
+// SessionData is the time-series data of a session prepared for charts and maps.
+type SessionData struct {
+	Series []SessionSeries `json:"series"`
+	Coords [][]float64     `json:"coords"` // [lat, lon] pairs in chronological order
+	Center []float64       `json:"center"` // center of the bounding box of the coordinates
+}
+
+// ApplyUnits converts the values of the series from the unit they were uploaded in to the preferred units,
+// and returns the descriptions of the series' fields with the resulting units.
+func (data *SessionData) ApplyUnits(catalog FieldCatalog, preferences UnitPreferences) []FieldMetadata {
+	fields := make([]FieldMetadata, 0, len(data.Series))
+	for i := range data.Series {
+		series := &data.Series[i]
+		metadata := catalog.Metadata(series.Field)
+
+		conversion := preferences.Conversion(metadata.Unit)
+		if !conversion.IsIdentity() {
+			for j, value := range series.Values {
+				series.Values[j] = conversion.Apply(value)
 			}
 		}
+		metadata.Unit = conversion.To
+		series.Unit = conversion.To
+		fields = append(fields, metadata)
 	}
+	return fields
+}
 
-	if result.Err() != nil {
-		return nil, nil, nil, result.Err()
+// dataQuery returns the time-series query selecting the data of this session described by the options.
+// LTTB downsampling cannot be done by the store, it is left to the caller.
+func (session *Session) dataQuery(options SessionDataOptions) TimeSeriesQuery {
+	query := session.timeSeriesQuery()
+	if !options.Start.IsZero() && options.Start.After(query.Start) {
+		query.Start = options.Start
 	}
-
-	type TimeEntry struct {
-		Time time.Time
-		Data map[string]interface{}
+	if !options.Stop.IsZero() && options.Stop.Before(query.Stop) {
+		query.Stop = options.Stop
+	}
+	query.Fields = slices.Clone(options.Fields)
+	if len(query.Fields) > 0 && len(options.Privacy) > 0 {
+		// The position decides whether the altitude is masked
+		query.Fields = withPositionFields(query.Fields)
 	}
+	if options.Method != SessionDataMethodLTTB {
+		query.Every = options.Every
+		if query.Every == 0 && options.MaxPoints > 0 {
+			query.Every = session.windowForMaxPoints(query, options.MaxPoints)
+		}
+	}
+	return query
+}
 
-	var orderedData []TimeEntry
-	for _, t := range orderedTimes {
-		timeStr := t.Format(time.RFC3339)
-		orderedData = append(orderedData, TimeEntry{
-			Time: t,
-			Data: timeBasedData[timeStr],
-		})
+// EachSessionPoint calls fn for every point of this session selected by the options in chronological order.
+// Unlike GetSessionData it does not keep the data in memory, so it suits exports of long sessions.
+// The mean method is applied by the store, or after masking the positions within the privacy zones. LTTB is not supported.
+func (session *Session) EachSessionPoint(store TimeSeriesStore, options SessionDataOptions, fn func(point TimeSeriesPoint) error) error {
+	return options.Privacy.query(store, session.dataQuery(options), fn)
+}
+
+// GetSessionData retrieves time-series data for this session from the time-series store.
+// By default it includes data from 10 minutes before the session start time to 10 minutes after the session end time.
+// Mean downsampling is done by the time-series store; LTTB is applied to the selected fields afterward.
+// Returns one series per numeric field, GPS coordinates, and the center point of the captured coordinates.
+// Positions within the privacy zones of the options are masked before they are averaged and the coordinates are built.
+func (session *Session) GetSessionData(store TimeSeriesStore, options SessionDataOptions) (SessionData, error) {
+	query := session.dataQuery(options)
+	if len(query.Fields) > 0 {
+		// The coordinates are always needed for the map
+		query.Fields = withPositionFields(query.Fields)
 	}
 
-	// Convert timeBasedData coordinates to coords in chronological order
+	seriesMap := make(map[string]*SessionSeries)
+	coords := make([][]float64, 0)
 	minLat := math.MaxFloat64
 	maxLat := -math.MaxFloat64
 	minLon := math.MaxFloat64
 	maxLon := -math.MaxFloat64
-	for _, entry := range orderedData {
-		data := entry.Data
-		if lat, ok := data["kff1006"].(float64); ok {
-			if lon, ok := data["kff1005"].(float64); ok {
-				coords = append(coords, []float64{lat, lon})
-				if lat < minLat {
-					minLat = lat
-				}
-				if lat > maxLat {
-					maxLat = lat
-				}
-				if lon < minLon {
-					minLon = lon
-				}
-				if lon > maxLon {
-					maxLon = lon
-				}
+
+	err := options.Privacy.query(store, query, func(point TimeSeriesPoint) error {
+		millis := point.Time.UnixMilli()
+		for field, value := range point.Fields {
+			number, ok := numericValue(value)
+			if !ok {
+				continue
+			}
+			series, exists := seriesMap[field]
+			if !exists {
+				series = &SessionSeries{Field: field}
+				seriesMap[field] = series
 			}
+			series.Times = append(series.Times, millis)
+			series.Values = append(series.Values, number)
+		}
+
+		if lat, lon, ok := pointPosition(point); ok {
+			coords = append(coords, []float64{lat, lon})
+			minLat = math.Min(minLat, lat)
+			maxLat = math.Max(maxLat, lat)
+			minLon = math.Min(minLon, lon)
+			maxLon = math.Max(maxLon, lon)
+		}
+		return nil
+	})
+	if err != nil {
+		return SessionData{}, err
+	}
+
+	fields := options.Fields
+	if len(fields) == 0 {
+		fields = slices.Sorted(maps.Keys(seriesMap))
+	}
+
+	data := SessionData{
+		Series: make([]SessionSeries, 0, len(fields)),
+		Coords: coords,
+		Center: []float64{0, 0},
+	}
+	for _, field := range fields {
+		series, exists := seriesMap[field]
+		if !exists {
+			continue
+		}
+		if options.Method == SessionDataMethodLTTB && options.MaxPoints > 0 {
+			series.Times, series.Values = lttb(series.Times, series.Values, options.MaxPoints)
 		}
+		data.Series = append(data.Series, *series)
+	}
+	if options.Method == SessionDataMethodLTTB && options.MaxPoints > 0 {
+		data.Coords = decimate(coords, options.MaxPoints)
 	}
+	if len(coords) > 0 {
+		data.Center = []float64{(minLat + maxLat) / 2, (minLon + maxLon) / 2}
+	}
+
+	return data, nil
+}
 
-	centerLat := (minLat + maxLat) / 2
-	centerLon := (minLon + maxLon) / 2
-	centerPoint := []float64{centerLat, centerLon}
+// withPositionFields returns the fields with the GPS coordinates added if missing.
+func withPositionFields(fields []string) []string {
+	for _, field := range []string{statFieldLatitude, statFieldLongitude} {
+		if !slices.Contains(fields, field) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
 
-	return timeBasedData, coords, centerPoint, nil
+// pointPosition returns the GPS position of a data point, if present.
+func pointPosition(point TimeSeriesPoint) (float64, float64, bool) {
+	lat, latOK := statValue(point.Fields, statFieldLatitude)
+	lon, lonOK := statValue(point.Fields, statFieldLongitude)
+	return lat, lon, latOK && lonOK
 }
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// SessionAlias routes the uploads of a Torque session to a session its data was split or merged into.
+// A Torque session may be routed to several sessions, the uploads go to the one covering their time.
+type SessionAlias struct {
+	ID        uint      `gorm:"primarykey;autoIncrement"`
+	AliasID   string    `gorm:"column:alias_id;uniqueIndex:idx_session_alias_unique;not null"` // session ID sent by Torque
+	SessionID string    `gorm:"column:session_id;uniqueIndex:idx_session_alias_unique;index:idx_session_alias_session_id;not null"`
+	UserID    uint      `gorm:"column:user_id;index:idx_session_alias_user_id;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*SessionAlias) TableName() string {
+	return "session_aliases"
+}
+
+// SessionAliasResolve returns the ID of the session the uploads of a Torque session at the given time belong to.
+// Sessions that were never split or merged are their own target, otherwise the session of the device
+// started last before dataTime is chosen among the session itself and its aliases.
+func SessionAliasResolve(uploadSessionID string, deviceID string, userID uint, dataTime time.Time) (string, error) {
+	var targets []string
+	err := DBSQLite.Model(&SessionAlias{}).
+		Where("alias_id = ? AND user_id = ?", uploadSessionID, userID).
+		Pluck("session_id", &targets).Error
+	if err != nil || len(targets) == 0 {
+		return uploadSessionID, err
+	}
+
+	var sessions []Session
+	err = DBSQLite.Where("session_id IN ? AND device_id = ? AND user_id = ?", append(targets, uploadSessionID), deviceID, userID).
+		Order("start_time ASC").
+		Find(&sessions).Error
+	if err != nil || len(sessions) == 0 {
+		return uploadSessionID, err
+	}
+
+	// Uploads before the first session belong to it, the others to the last session started before them
+	target := sessions[0]
+	for _, session := range sessions[1:] {
+		if session.StartTime.After(dataTime) {
+			break
+		}
+		target = session
+	}
+	return target.SessionID, nil
+}
+
+// addSessionAliases routes the uploads of the Torque sessions routed to the session, and of the session itself,
+// also to the target session
+func addSessionAliases(tx *gorm.DB, session *Session, targetSessionID string) error {
+	var aliasIDs []string
+	if err := tx.Model(&SessionAlias{}).Where("session_id = ?", session.SessionID).Pluck("alias_id", &aliasIDs).Error; err != nil {
+		return err
+	}
+
+	for _, aliasID := range append(aliasIDs, session.SessionID) {
+		alias := SessionAlias{AliasID: aliasID, SessionID: targetSessionID, UserID: session.UserID}
+		if err := tx.Where(SessionAlias{AliasID: aliasID, SessionID: targetSessionID}).FirstOrCreate(&alias).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveSessionAliases routes the uploads routed to the session, and of the session itself, to the target session instead
+func moveSessionAliases(tx *gorm.DB, session *Session, targetSessionID string) error {
+	if err := addSessionAliases(tx, session, targetSessionID); err != nil {
+		return err
+	}
+	if err := tx.Where("session_id = ?", session.SessionID).Delete(&SessionAlias{}).Error; err != nil {
+		return err
+	}
+	// The target is its own session, an alias to itself is redundant
+	return tx.Where("alias_id = ? AND session_id = ?", targetSessionID, targetSessionID).Delete(&SessionAlias{}).Error
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// SessionEvent is a notice sent by Torque during a session, e.g. a trip start or stop, a fault code or an alarm.
+// A notice is recorded once, Torque sends it again if the upload failed.
+type SessionEvent struct {
+	ID        uint      `gorm:"primarykey;autoIncrement"`
+	SessionID string    `gorm:"column:session_id;index:idx_session_event_session_id;uniqueIndex:idx_session_event_unique_notice;not null"`
+	UserID    uint      `gorm:"column:user_id;index:idx_session_event_user_id;not null"`
+	DeviceID  string    `gorm:"column:device_id;not null"`
+	Time      time.Time `gorm:"column:time;uniqueIndex:idx_session_event_unique_notice;not null"`
+	Class     string    `gorm:"column:class;uniqueIndex:idx_session_event_unique_notice"`
+	Text      string    `gorm:"column:text;uniqueIndex:idx_session_event_unique_notice"`
+	CreatedAt time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+
+	Session Session `gorm:"foreignKey:SessionID;references:SessionID" json:"-"`
+	User    User    `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*SessionEvent) TableName() string {
+	return "session_events"
+}
+
+// SessionEventRecord inserts a session event and records the trouble codes reported in its text for the device,
+// in one transaction. An event that is already recorded, e.g. a notice uploaded again, is skipped with its codes.
+// Returns the trouble codes reported for the first time and whether the event was recorded.
+func SessionEventRecord(event *SessionEvent) ([]DeviceDTC, bool, error) {
+	var newDTCs []DeviceDTC
+	recorded := false
+	err := DBSQLite.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(event)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		recorded = true
+
+		for _, code := range ParseDTCCodes(event.Text) {
+			dtc, created, err := deviceDTCRecord(tx, event.UserID, event.DeviceID, event.SessionID, code, event.Time)
+			if err != nil {
+				return err
+			}
+			if created {
+				newDTCs = append(newDTCs, dtc)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return newDTCs, recorded, nil
+}
+
+// removeDuplicateSessionEvents deletes the events recorded again before notices were unique,
+// so the unique index can be created
+func removeDuplicateSessionEvents() error {
+	if !DBSQLite.Migrator().HasTable(&SessionEvent{}) || DBSQLite.Migrator().HasIndex(&SessionEvent{}, "idx_session_event_unique_notice") {
+		return nil
+	}
+	return DBSQLite.Exec(`DELETE FROM session_events WHERE id NOT IN
+		(SELECT MIN(id) FROM session_events GROUP BY session_id, time, class, text)`).Error
+}
+
+// SessionEventListGetBySessionID retrieves the events of a session of the user in chronological order.
+func SessionEventListGetBySessionID(sessionID string, userID uint) ([]SessionEvent, error) {
+	var events []SessionEvent
+	err := DBSQLite.Where("session_id = ? AND user_id = ?", sessionID, userID).
+		Order("time ASC, id ASC").
+		Find(&events).Error
+	return events, err
+}
@@ -3,6 +3,7 @@ package models
 import (
 	"errors"
 	"gorm.io/gorm"
+	"strings"
 	"time"
 )
 
@@ -101,3 +102,13 @@ func SessionFieldUpdateDefaultUnit(sessionID string, fieldKey string, defaultUni
 
 	return result.Error
 }
+
+// SessionFieldGetLatestByUserAndKey finds the most recent definition of a field uploaded by the user in any session.
+// Returns gorm.ErrRecordNotFound if the user never uploaded a definition of the field.
+func SessionFieldGetLatestByUserAndKey(userID uint, fieldKey string) (SessionField, error) {
+	var sessionField SessionField
+	err := DBSQLite.Where("user_id = ? AND LOWER(field_key) = ?", userID, strings.ToLower(fieldKey)).
+		Order("created_at DESC, id DESC").
+		First(&sessionField).Error
+	return sessionField, err
+}
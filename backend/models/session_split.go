@@ -0,0 +1,422 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrSessionSplitNothing     = errors.New("no split point within the session data")
+	ErrSessionMergeDevice      = errors.New("sessions belong to different devices")
+	ErrSessionMergeNotAdjacent = errors.New("sessions are not adjacent")
+)
+
+// timeSeriesRewriteBatchSize is the number of points written to the time-series store at once when points are moved
+const timeSeriesRewriteBatchSize = 500
+
+// Split splits the session at the given times. The session keeps its data before the first split time,
+// the data after each split time is moved to a new session. Split times without data up to the next one are ignored.
+// Returns the resulting sessions in chronological order, their statistics and geofences are recalculated.
+func (session *Session) Split(store TimeSeriesStore, times []time.Time) ([]Session, error) {
+	points, err := session.flushedSessionPoints(store)
+	if err != nil {
+		return nil, err
+	}
+
+	times = slices.Clone(times)
+	slices.SortFunc(times, time.Time.Compare)
+	return session.split(store, points, times)
+}
+
+// SplitAtGaps splits the session wherever no data was uploaded for longer than gap,
+// e.g. when Torque kept one session across several trips.
+// Returns the resulting sessions in chronological order, their statistics and geofences are recalculated.
+func (session *Session) SplitAtGaps(store TimeSeriesStore, gap time.Duration) ([]Session, error) {
+	points, err := session.flushedSessionPoints(store)
+	if err != nil {
+		return nil, err
+	}
+
+	var times []time.Time
+	for i := 1; i < len(points); i++ {
+		if points[i].Time.Sub(points[i-1].Time) > gap {
+			times = append(times, points[i].Time)
+		}
+	}
+	return session.split(store, points, times)
+}
+
+// flushedSessionPoints retrieves the time-series data points of this session including the buffered ones
+func (session *Session) flushedSessionPoints(store TimeSeriesStore) ([]TimeSeriesPoint, error) {
+	if err := FlushTimeSeries(context.Background(), store); err != nil {
+		return nil, err
+	}
+	return session.GetSessionPoints(store)
+}
+
+// split splits the session at the sorted times, based on its data points in chronological order
+func (session *Session) split(store TimeSeriesStore, points []TimeSeriesPoint, times []time.Time) ([]Session, error) {
+	// A new segment starts at the first point at or after a split time
+	var segments [][]TimeSeriesPoint
+	next := 0
+	for _, point := range points {
+		boundary := false
+		for next < len(times) && !point.Time.Before(times[next]) {
+			next++
+			boundary = true
+		}
+		if boundary || len(segments) == 0 {
+			segments = append(segments, nil)
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], point)
+	}
+	if len(segments) < 2 {
+		return nil, ErrSessionSplitNothing
+	}
+
+	parts := make([]Session, len(segments))
+	for i, segment := range segments {
+		part := *session
+		if i > 0 {
+			sessionID, err := unusedSessionID(segment[0].Time)
+			if err != nil {
+				return nil, err
+			}
+			part = Session{
+				SessionID:        sessionID,
+				DeviceID:         session.DeviceID,
+				UserID:           session.UserID,
+				Version:          session.Version,
+				UploadFrequency:  session.UploadFrequency,
+				StartTime:        segment[0].Time,
+				VehicleProfileID: session.VehicleProfileID,
+				VehicleID:        session.VehicleID,
+			}
+		}
+		endTime := segment[len(segment)-1].Time
+		part.EndTime = &endTime
+		part.TotalRecords = len(segment)
+		part.IsActive = false
+		parts[i] = part
+	}
+
+	// The data is copied to the new sessions first and only deleted from the split session once the split is committed,
+	// a failed split deletes the copies again
+	ctx := context.Background()
+	for i := 1; i < len(parts); i++ {
+		if err := rewriteTimeSeriesPoints(ctx, store, segments[i], parts[i].SessionID); err != nil {
+			deleteSplitCopies(ctx, store, parts[1:i+1])
+			return nil, err
+		}
+	}
+
+	// Taken before the end time of the session is updated to that of the first part
+	moved := session.timeSeriesQuery()
+	moved.Start = parts[1].StartTime
+
+	err := DBSQLite.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(session).Updates(map[string]interface{}{
+			"end_time":      parts[0].EndTime,
+			"total_records": parts[0].TotalRecords,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		var fields []SessionField
+		if err := tx.Where("session_id = ?", session.SessionID).Find(&fields).Error; err != nil {
+			return err
+		}
+		var tags []SessionTag
+		if err := tx.Where("session_id = ?", session.SessionID).Find(&tags).Error; err != nil {
+			return err
+		}
+
+		// Latest first, so each part takes the events after its start that are left over by the later parts
+		for i := len(parts) - 1; i > 0; i-- {
+			part := &parts[i]
+			if err := tx.Create(part).Error; err != nil {
+				return err
+			}
+			// is_active defaults to true, a false value is skipped on create
+			if err := tx.Model(part).Update("is_active", false).Error; err != nil {
+				return err
+			}
+			if err := copySessionDetails(tx, fields, tags, part.SessionID); err != nil {
+				return err
+			}
+			for _, model := range []interface{}{&SessionEvent{}, &AlertEvent{}, &GeofenceEvent{}, &VehicleProfile{}} {
+				err := tx.Model(model).
+					Where("session_id = ? AND time >= ?", session.SessionID, part.StartTime).
+					Update("session_id", part.SessionID).Error
+				if err != nil {
+					return err
+				}
+			}
+			for _, column := range []string{"first", "last"} {
+				err := tx.Model(&DeviceDTC{}).
+					Where(column+"_session_id = ? AND "+column+"_seen_at >= ?", session.SessionID, part.StartTime).
+					Update(column+"_session_id", part.SessionID).Error
+				if err != nil {
+					return err
+				}
+			}
+			if err := addSessionAliases(tx, session, part.SessionID); err != nil {
+				return err
+			}
+		}
+
+		return tx.Where("session_id = ?", session.SessionID).Delete(&SessionStat{}).Error
+	})
+	if err != nil {
+		deleteSplitCopies(ctx, store, parts[1:])
+		return nil, err
+	}
+
+	if err := store.Delete(ctx, moved); err != nil {
+		return nil, err
+	}
+
+	// The parts are reloaded, so part 0 does not carry the geofences and the title of the whole session
+	for i := range parts {
+		part, err := SessionGetBySessionID(parts[i].SessionID, session.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := part.CalculateStats(store); err != nil {
+			return nil, err
+		}
+		if _, err := part.DetectGeofences(store); err != nil {
+			return nil, err
+		}
+		parts[i] = part
+	}
+	*session = parts[0]
+
+	return parts, nil
+}
+
+// deleteSplitCopies deletes the data copied to the parts of a split that failed
+func deleteSplitCopies(ctx context.Context, store TimeSeriesStore, parts []Session) {
+	for _, part := range parts {
+		if err := store.Delete(ctx, part.timeSeriesQuery()); err != nil {
+			log.Printf("Split cleanup error for session %s: %v", part.SessionID, err)
+		}
+	}
+}
+
+// SessionMerge merges two adjacent sessions of a device: the data of the later session is moved to the earlier one,
+// and the later session is deleted. Uploads of the later session are routed to the merged session afterwards.
+// Returns the merged session, its statistics and geofences are recalculated.
+func SessionMerge(store TimeSeriesStore, session Session, other Session) (Session, error) {
+	if session.DeviceID != other.DeviceID || session.UserID != other.UserID {
+		return Session{}, ErrSessionMergeDevice
+	}
+	first, second := session, other
+	if second.StartTime.Before(first.StartTime) {
+		first, second = second, first
+	}
+
+	var between int64
+	err := DBSQLite.Model(&Session{}).
+		Where("device_id = ? AND user_id = ? AND session_id NOT IN ? AND start_time >= ? AND start_time <= ?",
+			first.DeviceID, first.UserID, []string{first.SessionID, second.SessionID}, first.StartTime, second.StartTime).
+		Count(&between).Error
+	if err != nil {
+		return Session{}, err
+	}
+	if between > 0 {
+		return Session{}, ErrSessionMergeNotAdjacent
+	}
+
+	points, err := second.flushedSessionPoints(store)
+	if err != nil {
+		return Session{}, err
+	}
+	// Like on a split, the data of the later session is only deleted once the merge is committed
+	ctx := context.Background()
+	if err := rewriteTimeSeriesPoints(ctx, store, points, first.SessionID); err != nil {
+		deleteMergeCopies(ctx, store, first, points)
+		return Session{}, err
+	}
+	moved := second.timeSeriesQuery()
+
+	updates := map[string]interface{}{
+		"total_records": first.TotalRecords + len(points),
+		"is_favourite":  first.IsFavourite || second.IsFavourite,
+	}
+	if second.EndTime != nil && (first.EndTime == nil || second.EndTime.After(*first.EndTime)) {
+		updates["end_time"] = second.EndTime
+	}
+	if first.VehicleProfileID == nil && second.VehicleProfileID != nil {
+		updates["vehicle_profile_id"] = *second.VehicleProfileID
+	}
+	if first.VehicleID == nil && second.VehicleID != nil {
+		updates["vehicle_id"] = *second.VehicleID
+	}
+	if first.Title == "" {
+		updates["title"] = second.Title
+		updates["is_auto_title"] = second.IsAutoTitle
+	}
+	if second.Notes != "" {
+		updates["notes"] = strings.TrimSpace(first.Notes + "\n\n" + second.Notes)
+	}
+
+	err = DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&first).Updates(updates).Error; err != nil {
+			return err
+		}
+
+		// Field definitions and tags of the later session are only kept if the earlier one lacks them
+		var fieldKeys []string
+		if err := tx.Model(&SessionField{}).Where("session_id = ?", first.SessionID).Pluck("field_key", &fieldKeys).Error; err != nil {
+			return err
+		}
+		if err := moveSessionRecords(tx, &SessionField{}, "field_key", fieldKeys, second.SessionID, first.SessionID); err != nil {
+			return err
+		}
+		var tags []string
+		if err := tx.Model(&SessionTag{}).Where("session_id = ?", first.SessionID).Pluck("LOWER(tag)", &tags).Error; err != nil {
+			return err
+		}
+		if err := moveSessionRecords(tx, &SessionTag{}, "LOWER(tag)", tags, second.SessionID, first.SessionID); err != nil {
+			return err
+		}
+
+		for _, model := range []interface{}{&SessionEvent{}, &AlertEvent{}, &GeofenceEvent{}, &VehicleProfile{}} {
+			err := tx.Model(model).Where("session_id = ?", second.SessionID).Update("session_id", first.SessionID).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, column := range []string{"first_session_id", "last_session_id"} {
+			err := tx.Model(&DeviceDTC{}).Where(column+" = ?", second.SessionID).Update(column, first.SessionID).Error
+			if err != nil {
+				return err
+			}
+		}
+		// The links shared the later session only, they are not extended to the merged one
+		for _, model := range []interface{}{&SessionStat{}, &ShareLink{}} {
+			if err := tx.Where("session_id = ?", second.SessionID).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := moveSessionAliases(tx, &second, first.SessionID); err != nil {
+			return err
+		}
+		return tx.Delete(&second).Error
+	})
+	if err != nil {
+		deleteMergeCopies(ctx, store, first, points)
+		return Session{}, err
+	}
+	if err := store.Delete(ctx, moved); err != nil {
+		return Session{}, err
+	}
+
+	merged, err := SessionGetBySessionID(first.SessionID, first.UserID)
+	if err != nil {
+		return Session{}, err
+	}
+	if _, err := merged.CalculateStats(store); err != nil {
+		return Session{}, err
+	}
+	if _, err := merged.DetectGeofences(store); err != nil {
+		return Session{}, err
+	}
+	return merged, nil
+}
+
+// deleteMergeCopies deletes the points of the later session copied to the earlier one by a merge that failed
+func deleteMergeCopies(ctx context.Context, store TimeSeriesStore, first Session, points []TimeSeriesPoint) {
+	if len(points) == 0 {
+		return
+	}
+	copies := TimeSeriesQuery{
+		DeviceID:  first.DeviceID,
+		SessionID: first.SessionID,
+		Start:     points[0].Time,
+		Stop:      points[len(points)-1].Time.Add(time.Nanosecond),
+	}
+	if err := store.Delete(ctx, copies); err != nil {
+		log.Printf("Merge cleanup error for session %s: %v", first.SessionID, err)
+	}
+}
+
+// unusedSessionID returns an ID for a session starting at the given time, in the format of the Torque session IDs:
+// the start time in milliseconds, moved forward while it is taken by a session or routed to one
+func unusedSessionID(startTime time.Time) (string, error) {
+	for millis := startTime.UnixMilli(); ; millis++ {
+		sessionID := strconv.FormatInt(millis, 10)
+
+		var sessions, aliases int64
+		if err := DBSQLite.Model(&Session{}).Where("session_id = ?", sessionID).Count(&sessions).Error; err != nil {
+			return "", err
+		}
+		if err := DBSQLite.Model(&SessionAlias{}).Where("alias_id = ?", sessionID).Count(&aliases).Error; err != nil {
+			return "", err
+		}
+		if sessions == 0 && aliases == 0 {
+			return sessionID, nil
+		}
+	}
+}
+
+// rewriteTimeSeriesPoints writes copies of the points to the series of the target session and waits until they are stored
+func rewriteTimeSeriesPoints(ctx context.Context, store TimeSeriesStore, points []TimeSeriesPoint, targetSessionID string) error {
+	for start := 0; start < len(points); start += timeSeriesRewriteBatchSize {
+		batch := slices.Clone(points[start:min(start+timeSeriesRewriteBatchSize, len(points))])
+		for i := range batch {
+			batch[i].SessionID = targetSessionID
+		}
+		if err := store.Write(ctx, batch...); err != nil {
+			return err
+		}
+		if err := FlushTimeSeries(ctx, store); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copySessionDetails creates copies of the field definitions and the tags of a session for another session
+func copySessionDetails(tx *gorm.DB, fields []SessionField, tags []SessionTag, sessionID string) error {
+	if len(fields) > 0 {
+		copies := slices.Clone(fields)
+		for i := range copies {
+			copies[i].ID, copies[i].SessionID, copies[i].CreatedAt = 0, sessionID, time.Time{}
+		}
+		if err := tx.Create(&copies).Error; err != nil {
+			return err
+		}
+	}
+	if len(tags) > 0 {
+		copies := slices.Clone(tags)
+		for i := range copies {
+			copies[i].ID, copies[i].SessionID, copies[i].CreatedAt = 0, sessionID, time.Time{}
+		}
+		if err := tx.Create(&copies).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveSessionRecords moves the records of a session whose key is not among the existing keys to the target session,
+// and deletes the rest
+func moveSessionRecords(tx *gorm.DB, model interface{}, keyColumn string, existingKeys []string, sessionID string, targetSessionID string) error {
+	query := tx.Model(model).Where("session_id = ?", sessionID)
+	if len(existingKeys) > 0 {
+		query = query.Where(keyColumn+" NOT IN ?", existingKeys)
+	}
+	if err := query.Update("session_id", targetSessionID).Error; err != nil {
+		return err
+	}
+	return tx.Where("session_id = ?", sessionID).Delete(model).Error
+}
@@ -0,0 +1,238 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSessionSplitAtGaps(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 5, 2, 8, 0, 0, 0, time.UTC)
+	resumed := start.Add(10 * time.Minute)
+
+	user := User{Email: "split@example.com", Password: "-", Name: "Split"}
+	if err := UserCreate(&user); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+	home := Geofence{UserID: user.ID, Name: "Home", Type: GeofenceTypeCircle, Latitude: 47.5, Longitude: 19.05, Radius: 500}
+	work := Geofence{UserID: user.ID, Name: "Work", Type: GeofenceTypeCircle, Latitude: 47.6, Longitude: 19.2, Radius: 500}
+	for _, geofence := range []*Geofence{&home, &work} {
+		if err := GeofenceCreate(geofence); err != nil {
+			t.Fatalf("GeofenceCreate: %v", err)
+		}
+	}
+
+	// Four samples at home, then four at work after a gap
+	store := NewMemoryTimeSeriesStore()
+	var points []TimeSeriesPoint
+	for i := 0; i < 8; i++ {
+		at, lat, lon := start.Add(time.Duration(i)*time.Second), home.Latitude, home.Longitude
+		if i >= 4 {
+			at, lat, lon = resumed.Add(time.Duration(i-4)*time.Second), work.Latitude, work.Longitude
+		}
+		points = append(points, TimeSeriesPoint{
+			DeviceID:  "split-dev",
+			SessionID: "1714636800000",
+			Fields:    map[string]interface{}{statFieldLatitude: lat, statFieldLongitude: lon, statFieldSpeedOBD: 30.0},
+			Time:      at,
+		})
+	}
+	if err := store.Write(ctx, points...); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	session, _, err := SessionFindOrCreate("1714636800000", "split-dev", user.ID, 8, start)
+	if err != nil {
+		t.Fatalf("SessionFindOrCreate: %v", err)
+	}
+	if err := SessionUpdateActivityAndRecords(session.SessionID, points[7].Time, len(points)); err != nil {
+		t.Fatalf("SessionUpdateActivityAndRecords: %v", err)
+	}
+	if err := SessionClose(session.SessionID); err != nil {
+		t.Fatalf("SessionClose: %v", err)
+	}
+	if session, err = SessionGetBySessionID(session.SessionID, user.ID); err != nil {
+		t.Fatalf("SessionGetBySessionID: %v", err)
+	}
+	if _, err := session.DetectGeofences(store); err != nil {
+		t.Fatalf("DetectGeofences: %v", err)
+	}
+	if session.Title != "Home → Work" {
+		t.Fatalf("title before the split = %q, want Home → Work", session.Title)
+	}
+
+	for _, seenAt := range []time.Time{start.Add(time.Second), resumed.Add(time.Second)} {
+		profile := VehicleProfile{DeviceID: "split-dev", UserID: user.ID, SessionID: session.SessionID, Time: seenAt}
+		if _, err := VehicleProfileCreate(&profile); err != nil {
+			t.Fatalf("VehicleProfileCreate: %v", err)
+		}
+		if _, _, err := deviceDTCRecord(DBSQLite, user.ID, "split-dev", session.SessionID, "P0301", seenAt); err != nil {
+			t.Fatalf("deviceDTCRecord: %v", err)
+		}
+	}
+
+	parts, err := session.SplitAtGaps(store, time.Minute)
+	if err != nil {
+		t.Fatalf("SplitAtGaps: %v", err)
+	}
+	if len(parts) != 2 {
+		t.Fatalf("parts = %d, want 2", len(parts))
+	}
+	if parts[0].SessionID != session.SessionID {
+		t.Errorf("first part = %s, want the split session %s", parts[0].SessionID, session.SessionID)
+	}
+
+	wantTitles := []string{"Home round trip", "Work round trip"}
+	wantEnds := []uint{home.ID, work.ID}
+	for i, part := range parts {
+		stored, err := SessionGetBySessionID(part.SessionID, user.ID)
+		if err != nil {
+			t.Fatalf("part %d: %v", i, err)
+		}
+		for _, got := range []Session{part, stored} {
+			if got.Title != wantTitles[i] || !got.IsAutoTitle {
+				t.Errorf("part %d: title = %q (auto %v), want %q", i, got.Title, got.IsAutoTitle, wantTitles[i])
+			}
+			if got.EndGeofenceID == nil || *got.EndGeofenceID != wantEnds[i] {
+				t.Errorf("part %d: end geofence = %v, want %d", i, got.EndGeofenceID, wantEnds[i])
+			}
+		}
+
+		// Without an end time the query covers the moved data as well
+		stored.EndTime = nil
+		data, err := QueryAll(ctx, store, stored.timeSeriesQuery())
+		if err != nil {
+			t.Fatalf("part %d: Query: %v", i, err)
+		}
+		if len(data) != 4 {
+			t.Errorf("part %d: points = %d, want 4", i, len(data))
+		}
+	}
+
+	profiles, err := VehicleProfileListGetByDeviceID(user.ID, "split-dev", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("VehicleProfileListGetByDeviceID: %v", err)
+	}
+	if len(profiles) != 2 || profiles[0].SessionID != parts[0].SessionID || profiles[1].SessionID != parts[1].SessionID {
+		t.Errorf("vehicle profiles = %+v, want one per part", profiles)
+	}
+
+	dtcs, err := DeviceDTCListGetByDeviceID(user.ID, "split-dev")
+	if err != nil {
+		t.Fatalf("DeviceDTCListGetByDeviceID: %v", err)
+	}
+	if len(dtcs) != 1 || dtcs[0].FirstSessionID != parts[0].SessionID || dtcs[0].LastSessionID != parts[1].SessionID {
+		t.Errorf("trouble codes = %+v, want first seen in the first part and last in the second", dtcs)
+	}
+}
+
+// failingWriteStore fails the writes after the first accepted ones
+type failingWriteStore struct {
+	*MemoryTimeSeriesStore
+	accepted int // writes accepted before failing
+}
+
+func (store *failingWriteStore) Write(ctx context.Context, points ...TimeSeriesPoint) error {
+	if store.accepted == 0 {
+		return errors.New("store down")
+	}
+	store.accepted--
+	return store.MemoryTimeSeriesStore.Write(ctx, points...)
+}
+
+// mergeTestSession creates a closed session of the device with count points one second apart
+func mergeTestSession(t *testing.T, store TimeSeriesStore, userID uint, sessionID string, start time.Time, count int) Session {
+	t.Helper()
+	points := make([]TimeSeriesPoint, 0, count)
+	for i := 0; i < count; i++ {
+		points = append(points, TimeSeriesPoint{
+			DeviceID:  "merge-dev",
+			SessionID: sessionID,
+			Fields:    map[string]interface{}{statFieldSpeedOBD: float64(i)},
+			Time:      start.Add(time.Duration(i) * time.Second),
+		})
+	}
+	if err := store.Write(context.Background(), points...); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	session, _, err := SessionFindOrCreate(sessionID, "merge-dev", userID, 8, start)
+	if err != nil {
+		t.Fatalf("SessionFindOrCreate: %v", err)
+	}
+	if err := SessionUpdateActivityAndRecords(sessionID, points[count-1].Time, count); err != nil {
+		t.Fatalf("SessionUpdateActivityAndRecords: %v", err)
+	}
+	if err := SessionClose(sessionID); err != nil {
+		t.Fatalf("SessionClose: %v", err)
+	}
+	if session, err = SessionGetBySessionID(session.SessionID, userID); err != nil {
+		t.Fatalf("SessionGetBySessionID: %v", err)
+	}
+	return session
+}
+
+// mergeTestPoints returns the number of points of the session series until an hour after its start
+func mergeTestPoints(t *testing.T, store TimeSeriesStore, session Session) int {
+	t.Helper()
+	points, err := QueryAll(context.Background(), store, TimeSeriesQuery{
+		DeviceID: session.DeviceID, SessionID: session.SessionID, Start: session.StartTime, Stop: session.StartTime.Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	return len(points)
+}
+
+func TestSessionMerge(t *testing.T) {
+	user := User{Email: "merge@example.com", Password: "-", Name: "Merge"}
+	if err := UserCreate(&user); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		accepted   int // writes accepted by the store during the merge, -1 for all
+		wantMerged bool
+	}{
+		{name: "merged", accepted: -1, wantMerged: true},
+		{name: "failed write removes the copies and keeps the later session", accepted: 1},
+	}
+
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			memory := NewMemoryTimeSeriesStore()
+			start := time.Date(2024, 5, 3, 8+i, 0, 0, 0, time.UTC)
+			first := mergeTestSession(t, memory, user.ID, fmt.Sprint(start.UnixMilli()), start, 10)
+			// More points than a rewrite batch, so a failing store accepts part of them
+			laterStart := start.Add(5 * time.Minute)
+			second := mergeTestSession(t, memory, user.ID, fmt.Sprint(laterStart.UnixMilli()), laterStart, timeSeriesRewriteBatchSize+100)
+
+			var store TimeSeriesStore = memory
+			if test.accepted >= 0 {
+				store = &failingWriteStore{MemoryTimeSeriesStore: memory, accepted: test.accepted}
+			}
+			_, err := SessionMerge(store, first, second)
+			if test.wantMerged != (err == nil) {
+				t.Fatalf("SessionMerge: %v", err)
+			}
+
+			wantFirst, wantSecond := 10, timeSeriesRewriteBatchSize+100
+			if test.wantMerged {
+				wantFirst, wantSecond = wantFirst+wantSecond, 0
+			}
+			if got := mergeTestPoints(t, memory, first); got != wantFirst {
+				t.Errorf("points of the earlier session = %d, want %d", got, wantFirst)
+			}
+			if got := mergeTestPoints(t, memory, second); got != wantSecond {
+				t.Errorf("points of the later session = %d, want %d", got, wantSecond)
+			}
+			if _, err := SessionGetBySessionID(second.SessionID, user.ID); (err == nil) == test.wantMerged {
+				t.Errorf("later session kept = %v, want %v", err == nil, !test.wantMerged)
+			}
+		})
+	}
+}
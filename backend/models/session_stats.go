@@ -1,9 +1,26 @@
 package models
 
 import (
+	"errors"
+	"gorm.io/gorm"
+	"math"
 	"time"
 )
 
+// Field keys of the values used for trip statistics, as Torque sends them (without zero padding).
+const (
+	statFieldSpeedOBD    = "kd"
+	statFieldEngineRPM   = "kc"
+	statFieldCoolantTemp = "k5"
+	statFieldSpeedGPS    = "kff1001"
+	statFieldLongitude   = "kff1005"
+	statFieldLatitude    = "kff1006"
+	statFieldFuelUsed    = "kff1271"
+)
+
+// earthRadiusKm is the mean Earth radius used for distance calculations.
+const earthRadiusKm = 6371.0
+
 type SessionStat struct {
 	ID              uint      `gorm:"primarykey;autoIncrement"`
 	SessionID       string    `gorm:"column:session_id;index:idx_session_id;not null"`
@@ -15,15 +32,204 @@ type SessionStat struct {
 	AvgRPM          int       `gorm:"column:avg_rpm"`
 	FuelConsumed    float64   `gorm:"column:fuel_consumed"`
 	AvgConsumption  float64   `gorm:"column:avg_consumption"`
-	MaxTemperature  float64   `gorm:"column:max_temperature"`
+	MaxTemperature  *float64  `gorm:"column:max_temperature"` // nil if the session has no coolant temperature
 	TripDuration    int       `gorm:"column:trip_duration"`
 	DataPointsCount int       `gorm:"column:data_points_count"`
 	CalculatedAt    time.Time `gorm:"column:calculated_at;default:CURRENT_TIMESTAMP"`
 
-	Session Session `gorm:"foreignKey:SessionID;references:SessionID"`
-	User    User    `gorm:"foreignKey:UserID;references:ID"`
+	Units SessionStatUnits `gorm:"-"` // units of the values, stored in km, km/h, l, l/100km and °C
+
+	Session Session `gorm:"foreignKey:SessionID;references:SessionID" json:"-"`
+	User    User    `gorm:"foreignKey:UserID;references:ID" json:"-"`
 }
 
 func (SessionStat) TableName() string {
 	return "session_stats"
 }
+
+// SessionStatUnits are the units of the values of the trip statistics
+type SessionStatUnits struct {
+	Distance    string
+	Speed       string
+	Fuel        string
+	Consumption string
+	Temperature string
+}
+
+// storedSessionStatUnits are the units the trip statistics are calculated and stored in
+var storedSessionStatUnits = SessionStatUnits{
+	Distance:    "km",
+	Speed:       "km/h",
+	Fuel:        "l",
+	Consumption: "l/100km",
+	Temperature: "°C",
+}
+
+// InUnits returns the statistics converted to the preferred units. A missing temperature stays missing.
+func (stat SessionStat) InUnits(preferences UnitPreferences) SessionStat {
+	distance := preferences.Conversion(storedSessionStatUnits.Distance)
+	speed := preferences.Conversion(storedSessionStatUnits.Speed)
+	fuel := preferences.Conversion(storedSessionStatUnits.Fuel)
+	consumption := preferences.Conversion(storedSessionStatUnits.Consumption)
+	temperature := preferences.Conversion(storedSessionStatUnits.Temperature)
+
+	stat.TotalDistance = distance.Apply(stat.TotalDistance)
+	stat.MaxSpeed = speed.Apply(stat.MaxSpeed)
+	stat.AvgSpeed = speed.Apply(stat.AvgSpeed)
+	stat.FuelConsumed = fuel.Apply(stat.FuelConsumed)
+	stat.AvgConsumption = consumption.Apply(stat.AvgConsumption)
+	if stat.MaxTemperature != nil {
+		maxTemperature := temperature.Apply(*stat.MaxTemperature)
+		stat.MaxTemperature = &maxTemperature
+	}
+	stat.Units = SessionStatUnits{
+		Distance:    distance.To,
+		Speed:       speed.To,
+		Fuel:        fuel.To,
+		Consumption: consumption.To,
+		Temperature: temperature.To,
+	}
+	return stat
+}
+
+// SessionStatGetBySessionID retrieves the statistics of a session.
+// Returns gorm.ErrRecordNotFound if they have not been calculated yet.
+func SessionStatGetBySessionID(sessionID string) (SessionStat, error) {
+	var stat SessionStat
+	err := DBSQLite.Where("session_id = ?", sessionID).First(&stat).Error
+	return stat, err
+}
+
+// SessionStatListGetBySessionIDs retrieves the statistics of the given sessions, keyed by session ID.
+// Sessions without calculated statistics are missing from the result.
+func SessionStatListGetBySessionIDs(sessionIDs []string) (map[string]SessionStat, error) {
+	var stats []SessionStat
+	if err := DBSQLite.Where("session_id IN ?", sessionIDs).Find(&stats).Error; err != nil {
+		return nil, err
+	}
+
+	statMap := make(map[string]SessionStat, len(stats))
+	for _, stat := range stats {
+		statMap[stat.SessionID] = stat
+	}
+	return statMap, nil
+}
+
+// SessionStatSave creates the statistics record of a session or replaces the existing one.
+func SessionStatSave(stat *SessionStat) error {
+	existing, err := SessionStatGetBySessionID(stat.SessionID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	if err == nil {
+		stat.ID = existing.ID
+	}
+
+	stat.CalculatedAt = time.Now()
+	return DBSQLite.Save(stat).Error
+}
+
+// CalculateStats computes the trip summary of this session from its time-series data and stores it.
+// Distance is taken from the GPS track, or integrated from the speed if the session has no GPS data.
+// Speed prefers the OBD value (kd) and falls back to the GPS speed (kff1001).
+func (session *Session) CalculateStats(store TimeSeriesStore) (SessionStat, error) {
+	points, err := session.GetSessionPoints(store)
+	if err != nil {
+		return SessionStat{}, err
+	}
+
+	stat := SessionStat{
+		SessionID:       session.SessionID,
+		UserID:          session.UserID,
+		DataPointsCount: len(points),
+	}
+	if len(points) == 0 {
+		return stat, SessionStatSave(&stat)
+	}
+
+	var gpsDistance, speedDistance float64
+	var speedSum, rpmSum float64
+	var speedCount, rpmCount int
+	var prevLat, prevLon, prevSpeed float64
+	var prevTime time.Time
+	hasPrevCoord, hasPrevSpeed := false, false
+	minFuel, maxFuel := math.MaxFloat64, -math.MaxFloat64
+	maxTemperature := -math.MaxFloat64
+
+	for _, point := range points {
+		lat, latOK := statValue(point.Fields, statFieldLatitude)
+		lon, lonOK := statValue(point.Fields, statFieldLongitude)
+		if latOK && lonOK && (lat != 0 || lon != 0) {
+			if hasPrevCoord {
+				gpsDistance += haversineKm(prevLat, prevLon, lat, lon)
+			}
+			prevLat, prevLon, hasPrevCoord = lat, lon, true
+		}
+
+		speed, speedOK := statValue(point.Fields, statFieldSpeedOBD)
+		if !speedOK {
+			speed, speedOK = statValue(point.Fields, statFieldSpeedGPS)
+		}
+		if speedOK {
+			if hasPrevSpeed {
+				speedDistance += (prevSpeed + speed) / 2 * point.Time.Sub(prevTime).Hours()
+			}
+			prevSpeed, prevTime, hasPrevSpeed = speed, point.Time, true
+			speedSum += speed
+			speedCount++
+			stat.MaxSpeed = math.Max(stat.MaxSpeed, speed)
+		}
+
+		if rpm, ok := statValue(point.Fields, statFieldEngineRPM); ok {
+			rpmSum += rpm
+			rpmCount++
+			stat.MaxRPM = max(stat.MaxRPM, int(math.Round(rpm)))
+		}
+
+		if fuel, ok := statValue(point.Fields, statFieldFuelUsed); ok {
+			minFuel = math.Min(minFuel, fuel)
+			maxFuel = math.Max(maxFuel, fuel)
+		}
+
+		if temperature, ok := statValue(point.Fields, statFieldCoolantTemp); ok {
+			maxTemperature = math.Max(maxTemperature, temperature)
+		}
+	}
+
+	stat.TotalDistance = gpsDistance
+	if !hasPrevCoord {
+		stat.TotalDistance = speedDistance
+	}
+	if speedCount > 0 {
+		stat.AvgSpeed = speedSum / float64(speedCount)
+	}
+	if rpmCount > 0 {
+		stat.AvgRPM = int(math.Round(rpmSum / float64(rpmCount)))
+	}
+	if maxFuel >= minFuel {
+		stat.FuelConsumed = maxFuel - minFuel
+	}
+	if stat.TotalDistance > 0 {
+		stat.AvgConsumption = stat.FuelConsumed / stat.TotalDistance * 100
+	}
+	if maxTemperature > -math.MaxFloat64 {
+		stat.MaxTemperature = &maxTemperature
+	}
+	stat.TripDuration = int(points[len(points)-1].Time.Sub(points[0].Time).Seconds())
+
+	return stat, SessionStatSave(&stat)
+}
+
+// statValue returns the numeric value of a field of a data point, if present.
+func statValue(values map[string]interface{}, field string) (float64, bool) {
+	return numericValue(values[field])
+}
+
+// haversineKm returns the great-circle distance between two coordinates in kilometers.
+func haversineKm(lat1, lon1, lat2, lon2 float64) float64 {
+	dLat := (lat2 - lat1) * math.Pi / 180
+	dLon := (lon2 - lon1) * math.Pi / 180
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1*math.Pi/180)*math.Cos(lat2*math.Pi/180)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(a))
+}
@@ -0,0 +1,76 @@
+package models
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSessionStatInUnits(t *testing.T) {
+	temperature := func(value float64) *float64 { return &value }
+	imperial := UnitPreferences{System: UnitSystemImperial}
+
+	tests := []struct {
+		name            string
+		stat            SessionStat
+		preferences     UnitPreferences
+		wantDistance    float64
+		wantTemperature *float64
+		wantUnit        string
+	}{
+		{
+			name:            "metric",
+			stat:            SessionStat{TotalDistance: 16.09344, MaxTemperature: temperature(90)},
+			preferences:     UnitPreferences{System: UnitSystemMetric},
+			wantDistance:    16.09344,
+			wantTemperature: temperature(90),
+			wantUnit:        "°C",
+		},
+		{
+			name:            "imperial",
+			stat:            SessionStat{TotalDistance: 16.09344, MaxTemperature: temperature(90)},
+			preferences:     imperial,
+			wantDistance:    10,
+			wantTemperature: temperature(194),
+			wantUnit:        "°F",
+		},
+		{
+			name:            "freezing temperature is converted",
+			stat:            SessionStat{MaxTemperature: temperature(0)},
+			preferences:     imperial,
+			wantTemperature: temperature(32),
+			wantUnit:        "°F",
+		},
+		{
+			name:        "missing temperature stays missing",
+			stat:        SessionStat{},
+			preferences: imperial,
+			wantUnit:    "°F",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stat := test.stat.InUnits(test.preferences)
+			if math.Abs(stat.TotalDistance-test.wantDistance) > 1e-9 {
+				t.Errorf("distance = %v, want %v", stat.TotalDistance, test.wantDistance)
+			}
+			switch {
+			case test.wantTemperature == nil && stat.MaxTemperature != nil:
+				t.Errorf("temperature = %v, want none", *stat.MaxTemperature)
+			case test.wantTemperature != nil && stat.MaxTemperature == nil:
+				t.Errorf("temperature missing, want %v", *test.wantTemperature)
+			case test.wantTemperature != nil && math.Abs(*stat.MaxTemperature-*test.wantTemperature) > 1e-9:
+				t.Errorf("temperature = %v, want %v", *stat.MaxTemperature, *test.wantTemperature)
+			}
+			if stat.Units.Temperature != test.wantUnit {
+				t.Errorf("temperature unit = %s, want %s", stat.Units.Temperature, test.wantUnit)
+			}
+		})
+	}
+
+	original := SessionStat{MaxTemperature: temperature(90)}
+	original.InUnits(imperial)
+	if *original.MaxTemperature != 90 {
+		t.Errorf("InUnits changed the temperature of the original statistics to %v", *original.MaxTemperature)
+	}
+}
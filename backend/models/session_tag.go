@@ -0,0 +1,55 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// SessionTag is a label a user put on a session, e.g. commute or track day
+type SessionTag struct {
+	ID        uint      `gorm:"primarykey;autoIncrement"`
+	SessionID string    `gorm:"column:session_id;uniqueIndex:idx_session_tag_unique_tag;not null"`
+	UserID    uint      `gorm:"column:user_id;index:idx_session_tag_user_id;not null"`
+	Tag       string    `gorm:"column:tag;uniqueIndex:idx_session_tag_unique_tag;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+
+	Session Session `gorm:"foreignKey:SessionID;references:SessionID" json:"-"`
+	User    User    `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*SessionTag) TableName() string {
+	return "session_tags"
+}
+
+// SessionTagReplace replaces the tags of a session of the user.
+func SessionTagReplace(sessionID string, userID uint, tags []string) error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("session_id = ?", sessionID).Delete(&SessionTag{}).Error; err != nil {
+			return err
+		}
+		if len(tags) == 0 {
+			return nil
+		}
+
+		sessionTags := make([]SessionTag, 0, len(tags))
+		for _, tag := range tags {
+			sessionTags = append(sessionTags, SessionTag{SessionID: sessionID, UserID: userID, Tag: tag})
+		}
+		return tx.Create(&sessionTags).Error
+	})
+}
+
+// SessionTagListGetBySessionIDs retrieves the tags of the given sessions keyed by session ID, in alphabetical order.
+func SessionTagListGetBySessionIDs(sessionIDs []string) (map[string][]string, error) {
+	var sessionTags []SessionTag
+	err := DBSQLite.Where("session_id IN ?", sessionIDs).Order("tag COLLATE NOCASE ASC").Find(&sessionTags).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string][]string)
+	for _, sessionTag := range sessionTags {
+		tags[sessionTag.SessionID] = append(tags[sessionTag.SessionID], sessionTag.Tag)
+	}
+	return tags, nil
+}
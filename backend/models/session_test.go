@@ -0,0 +1,60 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSessionDelete(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2024, 5, 4, 8, 0, 0, 0, time.UTC)
+	user := User{Email: "delete@example.com", Password: "-", Name: "Delete"}
+	if err := UserCreate(&user); err != nil {
+		t.Fatalf("UserCreate: %v", err)
+	}
+
+	store := NewMemoryTimeSeriesStore()
+	session := mergeTestSession(t, store, user.ID, "1714809600000", start, 3)
+
+	profile := VehicleProfile{DeviceID: session.DeviceID, UserID: user.ID, SessionID: session.SessionID, Time: start}
+	if _, err := VehicleProfileCreate(&profile); err != nil {
+		t.Fatalf("VehicleProfileCreate: %v", err)
+	}
+	// First seen in the deleted session, last seen in a later one
+	for _, seen := range []struct {
+		sessionID string
+		at        time.Time
+	}{{session.SessionID, start}, {"1714813200000", start.Add(time.Hour)}} {
+		if _, _, err := deviceDTCRecord(DBSQLite, user.ID, session.DeviceID, seen.sessionID, "P0171", seen.at); err != nil {
+			t.Fatalf("deviceDTCRecord: %v", err)
+		}
+	}
+
+	if err := session.Delete(store); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	if _, err := SessionGetBySessionID(session.SessionID, user.ID); err == nil {
+		t.Error("session kept")
+	}
+	if points, err := QueryAll(ctx, store, session.timeSeriesQuery()); err != nil || len(points) != 0 {
+		t.Errorf("points = %d (%v), want none", len(points), err)
+	}
+
+	profiles, err := VehicleProfileListGetByDeviceID(user.ID, session.DeviceID, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("VehicleProfileListGetByDeviceID: %v", err)
+	}
+	if len(profiles) != 1 || profiles[0].SessionID != "" {
+		t.Errorf("vehicle profiles = %+v, want the profile unlinked from the session", profiles)
+	}
+
+	dtcs, err := DeviceDTCListGetByDeviceID(user.ID, session.DeviceID)
+	if err != nil {
+		t.Fatalf("DeviceDTCListGetByDeviceID: %v", err)
+	}
+	if len(dtcs) != 1 || dtcs[0].FirstSessionID != "" || dtcs[0].LastSessionID != "1714813200000" {
+		t.Errorf("trouble codes = %+v, want the code unlinked from the deleted session only", dtcs)
+	}
+}
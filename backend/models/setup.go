@@ -1,24 +1,21 @@
 package models
 
 import (
-	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
-	"github.com/influxdata/influxdb-client-go/v2/api"
+	"fmt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 )
 
 var DBSQLite *gorm.DB
-var DBInflux influxdb2.Client
-var DBInfluxWriteAPI api.WriteAPIBlocking
 
-// ConnectDatabase initializes connections to the SQLite and InfluxDB databases and configures required migrations.
+// ConnectDatabase initializes the connection to the SQLite database and configures required migrations.
+// The time-series storage is set up separately by NewTimeSeriesStore.
 func ConnectDatabase() {
-	var err error
-
 	time.Sleep(3 * time.Second)
 
 	pathSQLite := os.Getenv("DATABASE_SQLITE_URL")
@@ -34,22 +31,31 @@ func ConnectDatabase() {
 		log.Fatalf("Failed to create database file: %v", err)
 	}
 
-	DBSQLite, err = gorm.Open(sqlite.Open(pathSQLite), &gorm.Config{})
+	if err := OpenDatabase(pathSQLite); err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+
+	log.Println("Database connection initialized successfully")
+}
+
+// OpenDatabase opens the SQLite database at the given path as DBSQLite and migrates it.
+// ConnectDatabase uses it with the configured path, tests with a temporary one.
+func OpenDatabase(path string) error {
+	var err error
+	DBSQLite, err = gorm.Open(sqlite.Open(path), &gorm.Config{})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	if err := autoMigrateModels(); err != nil {
-		log.Fatalf("Failed to auto-migrate models: %v", err)
+		return fmt.Errorf("auto-migrate models: %w", err)
 	}
 
 	if err := createTriggers(); err != nil {
-		log.Fatalf("Failed to create triggers: %v", err)
+		return fmt.Errorf("create triggers: %w", err)
 	}
 
-	initInfluxDB()
-
-	log.Println("Database connection initialized successfully")
+	return nil
 }
 
 func ensureDatabaseDirectoryExists(dbPath string) error {
@@ -81,12 +87,35 @@ func ensureDatabaseFileExists(dbPath string) error {
 
 // autoMigrateModels performs auto-migration for all models
 func autoMigrateModels() error {
+	if err := removeDuplicateSessionEvents(); err != nil {
+		return err
+	}
+
 	models := []interface{}{
 		&User{},
 		&Device{},
 		&Session{},
 		&SessionField{},
 		&SessionStat{},
+		&UploadKey{},
+		&SessionEvent{},
+		&SessionTag{},
+		&SessionAlias{},
+		&DeviceDTC{},
+		&DeviceTransfer{},
+		&VehicleProfile{},
+		&Vehicle{},
+		&Dashboard{},
+		&DashboardChart{},
+		&DashboardChartVariable{},
+		&AlertRule{},
+		&AlertEvent{},
+		&Webhook{},
+		&WebhookDelivery{},
+		&Geofence{},
+		&GeofenceEvent{},
+		&PrivacyZone{},
+		&ShareLink{},
 	}
 
 	for _, model := range models {
@@ -99,7 +128,7 @@ func autoMigrateModels() error {
 }
 
 // createTriggers initializes database triggers for automatic updates of device last seen and session end times.
-// It creates two triggers: one for updating the last seen timestamp of devices, and one for setting session end times.
+// It creates two triggers: one for updating the last seen timestamp of devices, and one for setting missing session end times.
 // Returns an error if the trigger creation fails.
 func createTriggers() error {
 	err := DBSQLite.Exec(`
@@ -117,11 +146,17 @@ func createTriggers() error {
 		return err
 	}
 
+	// Earlier versions overwrote the end time unconditionally; replace them so the time of the last data point is kept.
+	err = DBSQLite.Exec(`DROP TRIGGER IF EXISTS update_session_end_time;`).Error
+	if err != nil {
+		return err
+	}
+
 	err = DBSQLite.Exec(`
     CREATE TRIGGER IF NOT EXISTS update_session_end_time
 	    AFTER UPDATE OF is_active ON sessions
 	    FOR EACH ROW
-	    WHEN NEW.is_active = 0 AND OLD.is_active = 1
+	    WHEN NEW.is_active = 0 AND OLD.is_active = 1 AND NEW.end_time IS NULL
 	BEGIN
 	    UPDATE sessions 
 	    SET end_time = CURRENT_TIMESTAMP 
@@ -135,19 +170,36 @@ func createTriggers() error {
 	return nil
 }
 
-// initInfluxDB initializes InfluxDB connection
-func initInfluxDB() {
-	influxURL := os.Getenv("INFLUX_URL")
-	influxToken := os.Getenv("INFLUX_TOKEN")
-	influxOrg := os.Getenv("INFLUX_ORG")
-	influxBucket := os.Getenv("INFLUX_BUCKET")
+// GetEnvDuration reads a duration such as "15m" from the given environment variable.
+// It returns the default value if the variable is unset or invalid.
+func GetEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Warning: invalid duration %q in %s, using %s", value, key, defaultValue)
+		return defaultValue
+	}
+
+	return duration
+}
+
+// GetEnvInt reads a positive integer from the given environment variable.
+// It returns the default value if the variable is unset or invalid.
+func GetEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
 
-	if influxURL == "" || influxToken == "" || influxOrg == "" || influxBucket == "" {
-		log.Println("Warning: InfluxDB configuration incomplete, skipping InfluxDB initialization")
-		return
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		log.Printf("Warning: invalid number %q in %s, using %d", value, key, defaultValue)
+		return defaultValue
 	}
 
-	log.Printf("Connecting to InfluxDB at: %s", influxURL)
-	DBInflux = influxdb2.NewClient(influxURL, influxToken)
-	DBInfluxWriteAPI = DBInflux.WriteAPIBlocking(influxOrg, influxBucket)
+	return number
 }
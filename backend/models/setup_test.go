@@ -0,0 +1,23 @@
+package models
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gorque-models-test")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := OpenDatabase(filepath.Join(dir, "gorque.db")); err != nil {
+		log.Fatal(err)
+	}
+
+	code := m.Run()
+
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
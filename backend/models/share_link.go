@@ -0,0 +1,117 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+// ShareLink grants read-only access to the data of a session to anyone knowing its token, without an account.
+// Only the SHA-256 hash of the token is stored; the plain token is shown once on creation.
+// The link can be limited to a set of fields and an expiry time, and can be revoked by its owner.
+type ShareLink struct {
+	ID                uint       `gorm:"primarykey;autoIncrement"`
+	UserID            uint       `gorm:"column:user_id;index:idx_share_link_user_id;not null"`
+	SessionID         string     `gorm:"column:session_id;index:idx_share_link_session_id;not null"`
+	Name              string     `gorm:"column:name"`
+	TokenHash         string     `gorm:"column:token_hash;uniqueIndex:idx_share_link_unique_token_hash;not null" json:"-"`
+	TokenPrefix       string     `gorm:"column:token_prefix"`
+	Fields            []string   `gorm:"column:fields;serializer:json"` // shared fields, all fields if empty
+	ApplyPrivacyZones bool       `gorm:"column:apply_privacy_zones;not null"`
+	ExpiresAt         *time.Time `gorm:"column:expires_at"`
+	CreatedAt         time.Time  `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	LastUsedAt        *time.Time `gorm:"column:last_used_at"`
+	RevokedAt         *time.Time `gorm:"column:revoked_at"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*ShareLink) TableName() string {
+	return "share_links"
+}
+
+// ShareLinkTokenHash returns the hex encoded SHA-256 hash under which a share token is stored.
+func ShareLinkTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// ShareLinkCreate inserts a new share link record into the database.
+func ShareLinkCreate(link *ShareLink) error {
+	return DBSQLite.Create(link).Error
+}
+
+// ShareLinkGetByToken retrieves a non-revoked, non-expired share link by its plain text token.
+func ShareLinkGetByToken(token string) (ShareLink, error) {
+	var link ShareLink
+	err := DBSQLite.
+		Where("token_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", ShareLinkTokenHash(token), time.Now()).
+		First(&link).Error
+	return link, err
+}
+
+// ShareLinkListGetByUserID retrieves the share links of a user, including revoked and expired ones, newest first.
+// If sessionID is not empty, only the links of that session are returned.
+func ShareLinkListGetByUserID(userID uint, sessionID string) ([]ShareLink, error) {
+	var links []ShareLink
+	query := DBSQLite.Where("user_id = ?", userID)
+	if sessionID != "" {
+		query = query.Where("session_id = ?", sessionID)
+	}
+	err := query.Order("created_at DESC, id DESC").Find(&links).Error
+	return links, err
+}
+
+// ShareLinkRevoke marks a share link of the given user as revoked.
+// Returns gorm.ErrRecordNotFound if the user has no active link with the given ID.
+func ShareLinkRevoke(userID uint, id uint) error {
+	result := DBSQLite.Model(&ShareLink{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateLastUsed records the time the share link was last opened.
+func (link *ShareLink) UpdateLastUsed(lastUsedAt time.Time) error {
+	return DBSQLite.Model(link).Update("last_used_at", lastUsedAt).Error
+}
+
+// Shares reports whether the link shares the field.
+func (link *ShareLink) Shares(field string) bool {
+	return len(link.Fields) == 0 || slices.Contains(link.Fields, field)
+}
+
+// SharesPosition reports whether the link shares the GPS position, so the coordinates can be shown on the map.
+func (link *ShareLink) SharesPosition() bool {
+	return link.Shares(statFieldLatitude) && link.Shares(statFieldLongitude)
+}
+
+// FilterStats clears the trip statistics calculated from fields the link does not share.
+// The distance is kept if either the position or a speed is shared, the duration and the number of points are always kept.
+func (link *ShareLink) FilterStats(stat SessionStat) SessionStat {
+	speed := link.Shares(statFieldSpeedOBD) || link.Shares(statFieldSpeedGPS)
+	if !speed {
+		stat.MaxSpeed, stat.AvgSpeed = 0, 0
+	}
+	if !speed && !link.SharesPosition() {
+		stat.TotalDistance = 0
+	}
+	if !link.Shares(statFieldEngineRPM) {
+		stat.MaxRPM, stat.AvgRPM = 0, 0
+	}
+	if !link.Shares(statFieldFuelUsed) {
+		stat.FuelConsumed, stat.AvgConsumption = 0, 0
+	}
+	if !link.Shares(statFieldCoolantTemp) {
+		stat.MaxTemperature = nil
+	}
+	return stat
+}
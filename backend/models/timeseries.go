@@ -0,0 +1,142 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// TimeSeriesMeasurement is the measurement under which the Torque samples are stored.
+const TimeSeriesMeasurement = "gorque_data"
+
+// TimeSeriesPoint is a single Torque sample: the values of all fields uploaded by a device at one point in time.
+type TimeSeriesPoint struct {
+	DeviceID  string
+	SessionID string
+	Tags      map[string]string
+	Fields    map[string]interface{}
+	Time      time.Time
+}
+
+// TimeSeriesQuery selects the points of a device session within the time range [Start, Stop).
+// Fields limits the returned fields, all fields are returned if it is empty.
+// If Every is set, the numeric fields are averaged over windows of that length aligned to the Unix epoch,
+// and each point is stamped with the start of its window.
+type TimeSeriesQuery struct {
+	DeviceID  string
+	SessionID string
+	Start     time.Time
+	Stop      time.Time
+	Fields    []string
+	Every     time.Duration
+}
+
+// TimeSeriesStore is the storage of the Torque samples.
+type TimeSeriesStore interface {
+	// Write stores the given points.
+	Write(ctx context.Context, points ...TimeSeriesPoint) error
+	// Query calls fn for every point matching the query in chronological order.
+	// Iteration stops at the first error returned by fn.
+	Query(ctx context.Context, query TimeSeriesQuery, fn func(point TimeSeriesPoint) error) error
+	// Delete removes all points matching the query.
+	Delete(ctx context.Context, query TimeSeriesQuery) error
+	// Close flushes pending writes and releases the resources held by the store.
+	Close()
+}
+
+// TimeSeriesFlusher is implemented by time-series stores that write asynchronously.
+type TimeSeriesFlusher interface {
+	// Flush blocks until the points written so far are stored.
+	Flush(ctx context.Context) error
+}
+
+// FlushTimeSeries waits until the points written to the store so far are stored, if the store writes asynchronously.
+func FlushTimeSeries(ctx context.Context, store TimeSeriesStore) error {
+	if flusher, ok := store.(TimeSeriesFlusher); ok {
+		return flusher.Flush(ctx)
+	}
+	return nil
+}
+
+// NewTimeSeriesStore creates the time-series store selected by the TIMESERIES_STORE environment variable.
+// Supported values are "influxdb", "sqlite" and "memory". If unset, InfluxDB is used when INFLUX_URL is set
+// and SQLite otherwise.
+func NewTimeSeriesStore() (TimeSeriesStore, error) {
+	storeType := os.Getenv("TIMESERIES_STORE")
+	if storeType == "" {
+		storeType = "sqlite"
+		if os.Getenv("INFLUX_URL") != "" {
+			storeType = "influxdb"
+		}
+	}
+
+	switch storeType {
+	case "influxdb":
+		store, err := initInfluxDB()
+		if err != nil {
+			return nil, err
+		}
+		return NewBufferedTimeSeriesStore(store, bufferedTimeSeriesConfig()), nil
+	case "sqlite":
+		store, err := initSQLiteTimeSeries()
+		if err != nil {
+			return nil, err
+		}
+		return NewBufferedTimeSeriesStore(store, bufferedTimeSeriesConfig()), nil
+	case "memory":
+		log.Println("Warning: using in-memory time-series store, data is lost on restart")
+		return NewMemoryTimeSeriesStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown time-series store: %s", storeType)
+	}
+}
+
+// bufferedTimeSeriesConfig reads the write pipeline configuration from the environment.
+// The spool is kept next to the SQLite database unless TIMESERIES_SPOOL_DIR is set.
+func bufferedTimeSeriesConfig() BufferedTimeSeriesConfig {
+	spoolDir := os.Getenv("TIMESERIES_SPOOL_DIR")
+	if spoolDir == "" {
+		spoolDir = filepath.Join(filepath.Dir(os.Getenv("DATABASE_SQLITE_URL")), "spool")
+	}
+
+	return BufferedTimeSeriesConfig{
+		QueueSize:     GetEnvInt("TIMESERIES_QUEUE_SIZE", 10000),
+		BatchSize:     GetEnvInt("TIMESERIES_BATCH_SIZE", 500),
+		FlushInterval: GetEnvDuration("TIMESERIES_FLUSH_INTERVAL", time.Second),
+		MaxRetries:    GetEnvInt("TIMESERIES_MAX_RETRIES", 5),
+		RetryBackoff:  GetEnvDuration("TIMESERIES_RETRY_BACKOFF", 500*time.Millisecond),
+		SpoolDir:      spoolDir,
+		SpoolMaxBytes: int64(GetEnvInt("TIMESERIES_SPOOL_MAX_MB", 64)) * 1024 * 1024,
+		SpoolAttempts: GetEnvInt("TIMESERIES_SPOOL_ATTEMPTS", 10),
+	}
+}
+
+// QueryAll returns all points matching the query in chronological order.
+func QueryAll(ctx context.Context, store TimeSeriesStore, query TimeSeriesQuery) ([]TimeSeriesPoint, error) {
+	var points []TimeSeriesPoint
+	err := store.Query(ctx, query, func(point TimeSeriesPoint) error {
+		points = append(points, point)
+		return nil
+	})
+	return points, err
+}
+
+// selectsField reports whether the field is returned by the query.
+func (query TimeSeriesQuery) selectsField(field string) bool {
+	return len(query.Fields) == 0 || slices.Contains(query.Fields, field)
+}
+
+// windowStart returns the start of the aggregation window containing t.
+func (query TimeSeriesQuery) windowStart(t time.Time) time.Time {
+	every := query.Every.Nanoseconds()
+	return time.Unix(0, t.UnixNano()/every*every).UTC()
+}
+
+// inRange reports whether t is within the time range of the query.
+func (query TimeSeriesQuery) inRange(t time.Time) bool {
+	return !t.Before(query.Start) && t.Before(query.Stop)
+}
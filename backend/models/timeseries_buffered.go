@@ -0,0 +1,440 @@
+package models
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// spoolReplayInterval is how often the spool is replayed while writes to the underlying store are failing.
+const spoolReplayInterval = 30 * time.Second
+
+// errTimeSeriesQueueFull is returned by BufferedTimeSeriesStore.Write if a point neither fits into the queue nor the spool.
+var errTimeSeriesQueueFull = errors.New("time-series write queue is full")
+
+// BufferedTimeSeriesConfig configures the write pipeline of a BufferedTimeSeriesStore.
+type BufferedTimeSeriesConfig struct {
+	QueueSize     int           // maximum number of points waiting in memory
+	BatchSize     int           // maximum number of points written at once
+	FlushInterval time.Duration // maximum time a point waits before it is written
+	MaxRetries    int           // write attempts of a batch before it is spooled
+	RetryBackoff  time.Duration // wait before the first retry, doubled on every further attempt
+	SpoolDir      string        // directory of the on-disk spool; empty disables spooling
+	SpoolMaxBytes int64         // maximum size of the spool file
+	SpoolAttempts int           // failed replays of a spooled batch before it is moved to the dead letter file
+}
+
+// BufferedTimeSeriesStore queues writes in memory and writes them to the underlying store in batches
+// from a background worker, so uploads do not wait on the latency of the time-series database.
+// Batches that cannot be written after retrying, and points that do not fit into the queue,
+// are spooled to disk and replayed once the underlying store accepts writes again.
+// Queries and deletes are passed through; queued points become visible once they are flushed.
+//
+// The in-memory queue is lossy: a point is acknowledged once it is queued, and only spooled points survive a crash.
+// A crash, unlike a graceful shutdown, loses the up to QueueSize queued points and the batch being written.
+// A smaller queue and flush interval narrow the window, at the cost of more write requests.
+type BufferedTimeSeriesStore struct {
+	store  TimeSeriesStore
+	config BufferedTimeSeriesConfig
+	queue  chan TimeSeriesPoint
+	spool  *timeSeriesSpool
+	done   chan struct{}
+	closed chan struct{}
+
+	flushRequests chan chan struct{} // answered by the worker once the queued points are written
+
+	healthy    bool      // whether the last write to the underlying store succeeded, owned by the worker
+	lastReplay time.Time // last spool replay attempt, owned by the worker
+}
+
+// NewBufferedTimeSeriesStore wraps the store into a write pipeline and starts its background worker.
+func NewBufferedTimeSeriesStore(store TimeSeriesStore, config BufferedTimeSeriesConfig) *BufferedTimeSeriesStore {
+	bs := &BufferedTimeSeriesStore{
+		store:   store,
+		config:  config,
+		queue:   make(chan TimeSeriesPoint, config.QueueSize),
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+		healthy: true,
+
+		flushRequests: make(chan chan struct{}),
+	}
+
+	if config.SpoolDir != "" {
+		if err := os.MkdirAll(config.SpoolDir, 0755); err != nil {
+			log.Printf("Warning: time-series spool disabled, cannot create %s: %v", config.SpoolDir, err)
+		} else {
+			bs.spool = &timeSeriesSpool{
+				path:        filepath.Join(config.SpoolDir, "timeseries.spool"),
+				maxBytes:    config.SpoolMaxBytes,
+				maxAttempts: config.SpoolAttempts,
+			}
+		}
+	}
+
+	go bs.run()
+
+	return bs
+}
+
+// Write queues the points. It only blocks if the queue is full and the points have to be spooled to disk.
+// Queued points are not durable until they are written or spooled, see BufferedTimeSeriesStore.
+func (bs *BufferedTimeSeriesStore) Write(_ context.Context, points ...TimeSeriesPoint) error {
+	for i, point := range points {
+		select {
+		case bs.queue <- point:
+		default:
+			if bs.spool == nil {
+				return errTimeSeriesQueueFull
+			}
+			return bs.spool.append(points[i:])
+		}
+	}
+	return nil
+}
+
+// Query is passed through to the underlying store.
+func (bs *BufferedTimeSeriesStore) Query(ctx context.Context, query TimeSeriesQuery, fn func(point TimeSeriesPoint) error) error {
+	return bs.store.Query(ctx, query, fn)
+}
+
+// Delete is passed through to the underlying store.
+func (bs *BufferedTimeSeriesStore) Delete(ctx context.Context, query TimeSeriesQuery) error {
+	return bs.store.Delete(ctx, query)
+}
+
+// Flush blocks until the points queued so far have been written to the underlying store or spooled.
+func (bs *BufferedTimeSeriesStore) Flush(ctx context.Context) error {
+	written := make(chan struct{})
+	select {
+	case bs.flushRequests <- written:
+	case <-bs.closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-written:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close flushes the queued points and closes the underlying store.
+func (bs *BufferedTimeSeriesStore) Close() {
+	close(bs.done)
+	<-bs.closed
+	bs.store.Close()
+}
+
+// run collects queued points into batches and writes them when a batch is full or the flush interval elapses
+func (bs *BufferedTimeSeriesStore) run() {
+	defer close(bs.closed)
+
+	ticker := time.NewTicker(bs.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]TimeSeriesPoint, 0, bs.config.BatchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			bs.writeBatch(batch)
+			batch = make([]TimeSeriesPoint, 0, bs.config.BatchSize)
+		}
+	}
+	// drain writes all queued points
+	drain := func() {
+		for {
+			select {
+			case point := <-bs.queue:
+				batch = append(batch, point)
+				if len(batch) >= bs.config.BatchSize {
+					flush()
+				}
+			default:
+				flush()
+				return
+			}
+		}
+	}
+
+	for {
+		select {
+		case point := <-bs.queue:
+			batch = append(batch, point)
+			if len(batch) >= bs.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			bs.replaySpool()
+		case written := <-bs.flushRequests:
+			drain()
+			close(written)
+		case <-bs.done:
+			drain()
+			return
+		}
+	}
+}
+
+// writeBatch writes a batch to the underlying store, retrying with exponential backoff.
+// A batch that still fails is spooled to disk.
+func (bs *BufferedTimeSeriesStore) writeBatch(batch []TimeSeriesPoint) {
+	err := bs.writeWithRetry(batch)
+	bs.healthy = err == nil
+	if err != nil {
+		log.Printf("Time-series write error after %d attempts: %v", bs.config.MaxRetries, err)
+		if bs.spool == nil {
+			log.Printf("Warning: %d time-series points dropped, spool disabled", len(batch))
+			return
+		}
+		if err := bs.spool.append(batch); err != nil {
+			log.Printf("Warning: %d time-series points dropped: %v", len(batch), err)
+		}
+	}
+}
+
+// writeWithRetry writes points to the underlying store with up to MaxRetries attempts
+func (bs *BufferedTimeSeriesStore) writeWithRetry(points []TimeSeriesPoint) error {
+	backoff := bs.config.RetryBackoff
+	var err error
+	for attempt := 1; attempt <= bs.config.MaxRetries; attempt++ {
+		if err = bs.store.Write(context.Background(), points...); err == nil {
+			return nil
+		}
+		if attempt < bs.config.MaxRetries {
+			select {
+			case <-time.After(backoff):
+			case <-bs.done:
+				// Shutting down, do not delay the final flush any further
+				return err
+			}
+			backoff *= 2
+		}
+	}
+	return err
+}
+
+// replaySpool writes spooled points back to the underlying store.
+// While the underlying store is failing, replay is only attempted every spoolReplayInterval.
+func (bs *BufferedTimeSeriesStore) replaySpool() {
+	if bs.spool == nil || (!bs.healthy && time.Since(bs.lastReplay) < spoolReplayInterval) {
+		return
+	}
+	bs.lastReplay = time.Now()
+
+	err := bs.spool.replay(bs.config.BatchSize, bs.healthy, func(points []TimeSeriesPoint) error {
+		return bs.store.Write(context.Background(), points...)
+	})
+	bs.healthy = err == nil
+	if err != nil {
+		log.Printf("Time-series spool replay error: %v", err)
+	}
+}
+
+// timeSeriesSpool is an append-only file of JSON encoded points waiting to be written.
+// A replay moves the spool aside to a replay file, which holds the points not written yet until all of them are.
+// Batches the underlying store keeps rejecting are moved to a dead letter file next to it, so they do not block
+// the later points. The dead letter file has the format of the spool: appended to the spool, it is replayed again.
+type timeSeriesSpool struct {
+	mutex       sync.Mutex // guards the spool file, the replay and dead letter files are only used by replay
+	path        string
+	maxBytes    int64
+	maxAttempts int // failed replays of a batch before it is moved to the dead letter file, 0 keeps it forever
+}
+
+// spooledPoint is a point in the spool file with the number of failed replays of its batch.
+// The point is embedded, so spool files written without the count are read as well.
+type spooledPoint struct {
+	TimeSeriesPoint
+	Attempts int `json:",omitempty"`
+}
+
+// append adds points to the spool file
+func (spool *timeSeriesSpool) append(points []TimeSeriesPoint) error {
+	spooled := make([]spooledPoint, 0, len(points))
+	for _, point := range points {
+		spooled = append(spooled, spooledPoint{TimeSeriesPoint: point})
+	}
+
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	if info, err := os.Stat(spool.path); err == nil && spool.maxBytes > 0 && info.Size() >= spool.maxBytes {
+		return errors.New("time-series spool is full")
+	}
+	return appendSpoolFile(spool.path, spooled)
+}
+
+// writeSpoolFile replaces the file at path with one holding the points. The points are written to a temporary file
+// renamed over it, so the file holds either the old or the new points.
+func writeSpoolFile(path string, points []spooledPoint) error {
+	if len(points) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	encoder := json.NewEncoder(file)
+	for _, point := range points {
+		if err := encoder.Encode(point); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+// appendSpoolFile adds points to the end of the file at path. If they cannot be written completely,
+// the file is truncated to its previous size, so it holds either none or all of them.
+func appendSpoolFile(path string, points []spooledPoint) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, point := range points {
+		if err = encoder.Encode(point); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Truncate(size)
+	}
+	return err
+}
+
+// replay passes the spooled points to write in batches. Points that could not be written stay in the replay file,
+// the written ones are removed from it, so no point is written twice.
+// A batch failing while the store accepts other writes, i.e. it was healthy before the replay or accepted an earlier
+// batch of it, is rejected: the failure is counted and the batch moved behind the other replayed points, so it does not
+// hold them back. Once a point of the batch has maxAttempts counted failures, the batch is moved to the dead letter file.
+func (spool *timeSeriesSpool) replay(batchSize int, healthy bool, write func(points []TimeSeriesPoint) error) error {
+	replayPath := spool.path + ".replay"
+
+	// Move the spool aside so new points can be appended while replaying
+	spool.mutex.Lock()
+	if _, err := os.Stat(replayPath); os.IsNotExist(err) {
+		if err := os.Rename(spool.path, replayPath); err != nil {
+			spool.mutex.Unlock()
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	spool.mutex.Unlock()
+
+	file, err := os.Open(replayPath)
+	if err != nil {
+		return err
+	}
+
+	var points []spooledPoint
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var point spooledPoint
+		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+			log.Printf("Skipping invalid spooled time-series point: %v", err)
+			continue
+		}
+		points = append(points, point)
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	written := 0
+	for start := 0; start < len(points); start += batchSize {
+		end := min(start+batchSize, len(points))
+		batch := points[start:end]
+		batchPoints := make([]TimeSeriesPoint, 0, len(batch))
+		for _, point := range batch {
+			batchPoints = append(batchPoints, point.TimeSeriesPoint)
+		}
+
+		err := write(batchPoints)
+		if err == nil {
+			written += len(batch)
+			continue
+		}
+
+		// While the store is down, the points are kept in order and the failure is not counted
+		kept := points[start:]
+		var dead []spooledPoint
+		if healthy || written > 0 {
+			for i := range batch {
+				batch[i].Attempts++
+			}
+			if spool.maxAttempts > 0 && slices.ContainsFunc(batch, func(point spooledPoint) bool { return point.Attempts >= spool.maxAttempts }) {
+				dead, batch = batch, nil
+			}
+			kept = append(slices.Clone(points[end:]), batch...)
+		}
+
+		if dead != nil {
+			if deadErr := appendSpoolFile(spool.path+".dead", dead); deadErr != nil {
+				// Kept with the counted failure, they are moved to the dead letter file on the next replay
+				log.Printf("Time-series dead letter error: %v", deadErr)
+				kept = points[start:]
+			} else {
+				log.Printf("Warning: %d spooled time-series points moved to %s.dead after %d failed replays: %v",
+					len(dead), spool.path, spool.maxAttempts, err)
+			}
+		}
+		if written > 0 {
+			log.Printf("Replayed %d spooled time-series points", written)
+		}
+
+		// Only the points not written stay in the replay file, which is replayed before the spool on the next replay
+		if writeErr := writeSpoolFile(replayPath, kept); writeErr != nil {
+			return writeErr
+		}
+		return err
+	}
+
+	if written > 0 {
+		log.Printf("Replayed %d spooled time-series points", written)
+	}
+
+	return os.Remove(replayPath)
+}
@@ -0,0 +1,175 @@
+package models
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// spoolTestPoints returns points with the speeds as kd values
+func spoolTestPoints(speeds ...float64) []TimeSeriesPoint {
+	points := make([]TimeSeriesPoint, 0, len(speeds))
+	for i, speed := range speeds {
+		points = append(points, memoryTestPoint(i, map[string]interface{}{"kd": speed}))
+	}
+	return points
+}
+
+// spoolTestSpeeds returns the kd values of the points in a spool file, nil if it does not exist
+func spoolTestSpeeds(t *testing.T, path string) []float64 {
+	t.Helper()
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var speeds []float64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var point spooledPoint
+		if err := json.Unmarshal(scanner.Bytes(), &point); err != nil {
+			t.Fatal(err)
+		}
+		speeds = append(speeds, point.Fields["kd"].(float64))
+	}
+	return speeds
+}
+
+// rejectingWrite returns a write function rejecting the batches containing the speed, recording the written speeds
+func rejectingWrite(rejected float64, written *[]float64) func(points []TimeSeriesPoint) error {
+	return func(points []TimeSeriesPoint) error {
+		for _, point := range points {
+			if point.Fields["kd"] == rejected {
+				return errors.New("rejected")
+			}
+		}
+		for _, point := range points {
+			*written = append(*written, point.Fields["kd"].(float64))
+		}
+		return nil
+	}
+}
+
+func TestTimeSeriesSpoolReplay(t *testing.T) {
+	down := func(points []TimeSeriesPoint) error { return errors.New("store down") }
+
+	tests := []struct {
+		name        string
+		replays     int
+		healthy     bool
+		write       func(written *[]float64) func(points []TimeSeriesPoint) error
+		deadFails   bool // the dead letter file cannot be written
+		wantWritten []float64
+		wantPending []float64 // points of the replay file followed by the spool
+		wantDead    []float64
+	}{
+		{
+			name:        "all written",
+			replays:     1,
+			healthy:     true,
+			write:       func(written *[]float64) func([]TimeSeriesPoint) error { return rejectingWrite(-1, written) },
+			wantWritten: []float64{1, 2, 3, 4, 5},
+		},
+		{
+			name:        "rejected batch moves behind the later points",
+			replays:     1,
+			healthy:     true,
+			write:       func(written *[]float64) func([]TimeSeriesPoint) error { return rejectingWrite(1, written) },
+			wantPending: []float64{3, 4, 5, 1, 2},
+		},
+		{
+			name:        "rejected batch is moved to the dead letter file",
+			replays:     3,
+			healthy:     true,
+			write:       func(written *[]float64) func([]TimeSeriesPoint) error { return rejectingWrite(1, written) },
+			wantWritten: []float64{3, 4, 2},
+			wantDead:    []float64{5, 1},
+		},
+		{
+			name:        "written points are not replayed again when the dead letter file fails",
+			replays:     3,
+			healthy:     true,
+			deadFails:   true,
+			write:       func(written *[]float64) func([]TimeSeriesPoint) error { return rejectingWrite(1, written) },
+			wantWritten: []float64{3, 4},
+			wantPending: []float64{5, 1, 2},
+		},
+		{
+			name:        "order is kept and failures are not counted while the store is down",
+			replays:     5,
+			healthy:     false,
+			write:       func(*[]float64) func([]TimeSeriesPoint) error { return down },
+			wantPending: []float64{1, 2, 3, 4, 5},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			spool := &timeSeriesSpool{path: filepath.Join(dir, "timeseries.spool"), maxAttempts: 2}
+			if err := spool.append(spoolTestPoints(1, 2, 3, 4, 5)); err != nil {
+				t.Fatalf("append: %v", err)
+			}
+			if test.deadFails {
+				if err := os.Mkdir(spool.path+".dead", 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var written []float64
+			for i := 0; i < test.replays; i++ {
+				spool.replay(2, test.healthy, test.write(&written))
+			}
+
+			if !reflect.DeepEqual(written, test.wantWritten) {
+				t.Errorf("written = %v, want %v", written, test.wantWritten)
+			}
+			pending := append(spoolTestSpeeds(t, spool.path+".replay"), spoolTestSpeeds(t, spool.path)...)
+			if !reflect.DeepEqual(pending, test.wantPending) {
+				t.Errorf("pending = %v, want %v", pending, test.wantPending)
+			}
+			if !test.deadFails {
+				if got := spoolTestSpeeds(t, spool.path+".dead"); !reflect.DeepEqual(got, test.wantDead) {
+					t.Errorf("dead letters = %v, want %v", got, test.wantDead)
+				}
+			}
+			if test.wantPending == nil {
+				if _, err := os.Stat(spool.path + ".replay"); !os.IsNotExist(err) {
+					t.Errorf("replay file left behind")
+				}
+			}
+		})
+	}
+}
+
+func TestTimeSeriesSpoolReadsPointsWithoutAttempts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "timeseries.spool")
+	line, err := json.Marshal(memoryTestPoint(0, map[string]interface{}{"kd": 7.0}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, append(line, '\n'), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	spool := &timeSeriesSpool{path: path, maxAttempts: 2}
+	var written []TimeSeriesPoint
+	err = spool.replay(10, true, func(points []TimeSeriesPoint) error {
+		written = append(written, points...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if len(written) != 1 || written[0].Fields["kd"] != 7.0 || !written[0].Time.Equal(memoryTestStart) {
+		t.Errorf("written = %v, want the spooled point", written)
+	}
+}
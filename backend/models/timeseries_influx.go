@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"errors"
+	influxdb2 "github.com/influxdata/influxdb-client-go/v2"
+	"github.com/influxdata/influxdb-client-go/v2/api"
+	"github.com/influxdata/influxdb-client-go/v2/api/write"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InfluxTimeSeriesStore stores the Torque samples in an InfluxDB 2 bucket.
+type InfluxTimeSeriesStore struct {
+	client    influxdb2.Client
+	writeAPI  api.WriteAPIBlocking
+	queryAPI  api.QueryAPI
+	deleteAPI api.DeleteAPI
+	org       string
+	bucket    string
+}
+
+// NewInfluxTimeSeriesStore creates a time-series store writing to the given InfluxDB organization and bucket.
+func NewInfluxTimeSeriesStore(url string, token string, org string, bucket string) *InfluxTimeSeriesStore {
+	client := influxdb2.NewClient(url, token)
+	return &InfluxTimeSeriesStore{
+		client:    client,
+		writeAPI:  client.WriteAPIBlocking(org, bucket),
+		queryAPI:  client.QueryAPI(org),
+		deleteAPI: client.DeleteAPI(),
+		org:       org,
+		bucket:    bucket,
+	}
+}
+
+// initInfluxDB initializes InfluxDB connection
+func initInfluxDB() (TimeSeriesStore, error) {
+	influxURL := os.Getenv("INFLUX_URL")
+	influxToken := os.Getenv("INFLUX_TOKEN")
+	influxOrg := os.Getenv("INFLUX_ORG")
+	influxBucket := os.Getenv("INFLUX_BUCKET")
+
+	if influxURL == "" || influxToken == "" || influxOrg == "" || influxBucket == "" {
+		return nil, errors.New("InfluxDB configuration incomplete, INFLUX_URL, INFLUX_TOKEN, INFLUX_ORG and INFLUX_BUCKET are required")
+	}
+
+	log.Printf("Connecting to InfluxDB at: %s", influxURL)
+	return NewInfluxTimeSeriesStore(influxURL, influxToken, influxOrg, influxBucket), nil
+}
+
+// Write stores the given points in the bucket, tagged with their device and session ID.
+func (store *InfluxTimeSeriesStore) Write(ctx context.Context, points ...TimeSeriesPoint) error {
+	influxPoints := make([]*write.Point, 0, len(points))
+	for _, point := range points {
+		tags := map[string]string{
+			"id":      point.DeviceID,
+			"session": point.SessionID,
+		}
+		for key, value := range point.Tags {
+			tags[key] = value
+		}
+		influxPoints = append(influxPoints, influxdb2.NewPoint(TimeSeriesMeasurement, tags, point.Fields, point.Time))
+	}
+
+	return store.writeAPI.WritePoint(ctx, influxPoints...)
+}
+
+// Query pivots the fields of each sample into a single row, so points can be streamed in chronological order.
+// Field selection and window aggregation are done by InfluxDB before pivoting.
+func (store *InfluxTimeSeriesStore) Query(ctx context.Context, query TimeSeriesQuery, fn func(point TimeSeriesPoint) error) error {
+	flux := `from(bucket: ` + fluxString(store.bucket) + `)
+    |> range(start: ` + query.Start.Format(time.RFC3339Nano) + `, stop: ` + query.Stop.Format(time.RFC3339Nano) + `)
+    |> filter(fn: (r) => r._measurement == ` + fluxString(TimeSeriesMeasurement) + `)
+    |> filter(fn: (r) => r.id == ` + fluxString(query.DeviceID) + `)
+    |> filter(fn: (r) => r.session == ` + fluxString(query.SessionID) + `)`
+	if len(query.Fields) > 0 {
+		fields := make([]string, len(query.Fields))
+		for i, field := range query.Fields {
+			fields[i] = fluxString(field)
+		}
+		flux += `
+    |> filter(fn: (r) => contains(value: r._field, set: [` + strings.Join(fields, ", ") + `]))`
+	}
+	if query.Every > 0 {
+		flux += `
+    |> aggregateWindow(every: ` + fluxDuration(query.Every) + `, fn: mean, createEmpty: false, timeSrc: "_start")`
+	}
+	flux += `
+    |> pivot(rowKey: ["_time"], columnKey: ["_field"], valueColumn: "_value")
+    |> group()
+    |> sort(columns: ["_time"], desc: false)`
+
+	result, err := store.queryAPI.Query(ctx, flux)
+	if err != nil {
+		return err
+	}
+	defer result.Close()
+
+	var current *TimeSeriesPoint
+	for result.Next() {
+		record := result.Record()
+
+		// Rows of the same sample with differing tag sets are merged into one point
+		if current != nil && !current.Time.Equal(record.Time()) {
+			if err := fn(*current); err != nil {
+				return err
+			}
+			current = nil
+		}
+		if current == nil {
+			current = &TimeSeriesPoint{
+				DeviceID:  query.DeviceID,
+				SessionID: query.SessionID,
+				Tags:      map[string]string{},
+				Fields:    map[string]interface{}{},
+				Time:      record.Time(),
+			}
+		}
+
+		for key, value := range record.Values() {
+			if value == nil || strings.HasPrefix(key, "_") || key == "result" || key == "table" || key == "id" || key == "session" {
+				continue
+			}
+			if tag, ok := value.(string); ok {
+				current.Tags[key] = tag
+				continue
+			}
+			current.Fields[key] = value
+		}
+	}
+
+	if result.Err() != nil {
+		return result.Err()
+	}
+
+	if current != nil {
+		return fn(*current)
+	}
+
+	return nil
+}
+
+// Delete removes the points of a device session within the time range of the query.
+func (store *InfluxTimeSeriesStore) Delete(ctx context.Context, query TimeSeriesQuery) error {
+	predicate := `_measurement=` + fluxString(TimeSeriesMeasurement) +
+		` AND id=` + fluxString(query.DeviceID) +
+		` AND session=` + fluxString(query.SessionID)
+
+	return store.deleteAPI.DeleteWithName(ctx, store.org, store.bucket, query.Start, query.Stop, predicate)
+}
+
+// Close releases the InfluxDB client.
+func (store *InfluxTimeSeriesStore) Close() {
+	store.client.Close()
+}
+
+// fluxString returns s as a quoted Flux string literal.
+func fluxString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `${`, `\${`).Replace(s) + `"`
+}
+
+// fluxDuration returns d as a Flux duration literal with millisecond precision.
+func fluxDuration(d time.Duration) string {
+	return strconv.FormatInt(max(d.Milliseconds(), 1), 10) + "ms"
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryTimeSeriesStore keeps the Torque samples in memory.
+// It is meant for tests and for running without a time-series database; data is lost on restart.
+type MemoryTimeSeriesStore struct {
+	mutex  sync.RWMutex
+	series map[memorySeriesKey][]TimeSeriesPoint // points of a device session, ordered by time
+}
+
+type memorySeriesKey struct {
+	deviceID  string
+	sessionID string
+}
+
+// NewMemoryTimeSeriesStore creates an empty in-memory time-series store.
+func NewMemoryTimeSeriesStore() *MemoryTimeSeriesStore {
+	return &MemoryTimeSeriesStore{
+		series: make(map[memorySeriesKey][]TimeSeriesPoint),
+	}
+}
+
+// Write stores the given points. Fields of a point written at an existing time are merged into it.
+func (store *MemoryTimeSeriesStore) Write(_ context.Context, points ...TimeSeriesPoint) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, point := range points {
+		key := memorySeriesKey{deviceID: point.DeviceID, sessionID: point.SessionID}
+		series := store.series[key]
+
+		i := sort.Search(len(series), func(i int) bool {
+			return !series[i].Time.Before(point.Time)
+		})
+
+		if i < len(series) && series[i].Time.Equal(point.Time) {
+			for k, v := range point.Tags {
+				series[i].Tags[k] = v
+			}
+			for k, v := range point.Fields {
+				series[i].Fields[k] = v
+			}
+			continue
+		}
+
+		stored := TimeSeriesPoint{
+			DeviceID:  point.DeviceID,
+			SessionID: point.SessionID,
+			Tags:      make(map[string]string, len(point.Tags)),
+			Fields:    make(map[string]interface{}, len(point.Fields)),
+			Time:      point.Time,
+		}
+		for k, v := range point.Tags {
+			stored.Tags[k] = v
+		}
+		for k, v := range point.Fields {
+			stored.Fields[k] = v
+		}
+
+		series = append(series, TimeSeriesPoint{})
+		copy(series[i+1:], series[i:])
+		series[i] = stored
+		store.series[key] = series
+	}
+
+	return nil
+}
+
+// Query calls fn for every point matching the query in chronological order.
+func (store *MemoryTimeSeriesStore) Query(_ context.Context, query TimeSeriesQuery, fn func(point TimeSeriesPoint) error) error {
+	store.mutex.RLock()
+	series := store.series[memorySeriesKey{deviceID: query.DeviceID, sessionID: query.SessionID}]
+	matching := make([]TimeSeriesPoint, 0, len(series))
+	for _, point := range series {
+		if query.inRange(point.Time) {
+			matching = append(matching, selectFields(point, query))
+		}
+	}
+	store.mutex.RUnlock()
+
+	if query.Every > 0 {
+		matching = aggregateWindows(matching, query)
+	}
+
+	for _, point := range matching {
+		if err := fn(point); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes all points matching the query.
+func (store *MemoryTimeSeriesStore) Delete(_ context.Context, query TimeSeriesQuery) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	key := memorySeriesKey{deviceID: query.DeviceID, sessionID: query.SessionID}
+	kept := store.series[key][:0]
+	for _, point := range store.series[key] {
+		if !query.inRange(point.Time) {
+			kept = append(kept, point)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(store.series, key)
+	} else {
+		store.series[key] = kept
+	}
+
+	return nil
+}
+
+// Close is a no-op for the in-memory store.
+func (store *MemoryTimeSeriesStore) Close() {}
+
+// selectFields returns a copy of the point holding only the fields selected by the query.
+func selectFields(point TimeSeriesPoint, query TimeSeriesQuery) TimeSeriesPoint {
+	selected := point
+	selected.Fields = make(map[string]interface{}, len(point.Fields))
+	for field, value := range point.Fields {
+		if query.selectsField(field) {
+			selected.Fields[field] = value
+		}
+	}
+	return selected
+}
+
+// aggregateWindows averages the numeric fields of chronologically ordered points over the windows of the query.
+// Windows without numeric values are left out.
+func aggregateWindows(points []TimeSeriesPoint, query TimeSeriesQuery) []TimeSeriesPoint {
+	type fieldSum struct {
+		sum   float64
+		count int
+	}
+
+	var aggregated []TimeSeriesPoint
+	var windowStart time.Time
+	var sums map[string]*fieldSum
+	emit := func() {
+		if len(sums) == 0 {
+			return
+		}
+		point := TimeSeriesPoint{
+			DeviceID:  query.DeviceID,
+			SessionID: query.SessionID,
+			Tags:      map[string]string{},
+			Fields:    make(map[string]interface{}, len(sums)),
+			Time:      windowStart,
+		}
+		for field, s := range sums {
+			point.Fields[field] = s.sum / float64(s.count)
+		}
+		aggregated = append(aggregated, point)
+	}
+
+	for _, point := range points {
+		start := query.windowStart(point.Time)
+		if sums == nil || !start.Equal(windowStart) {
+			emit()
+			windowStart = start
+			sums = map[string]*fieldSum{}
+		}
+		for field, value := range point.Fields {
+			number, ok := numericValue(value)
+			if !ok {
+				continue
+			}
+			if sums[field] == nil {
+				sums[field] = &fieldSum{}
+			}
+			sums[field].sum += number
+			sums[field].count++
+		}
+	}
+	emit()
+
+	return aggregated
+}
@@ -0,0 +1,313 @@
+package models
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var memoryTestStart = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// memoryTestPoint returns a point of device d1, session s1, the given seconds after memoryTestStart
+func memoryTestPoint(seconds int, fields map[string]interface{}) TimeSeriesPoint {
+	return TimeSeriesPoint{
+		DeviceID:  "d1",
+		SessionID: "s1",
+		Tags:      map[string]string{"profile": "car"},
+		Fields:    fields,
+		Time:      memoryTestStart.Add(time.Duration(seconds) * time.Second),
+	}
+}
+
+// memoryTestQuery returns a query of device d1, session s1 between the given seconds after memoryTestStart
+func memoryTestQuery(start, stop int) TimeSeriesQuery {
+	return TimeSeriesQuery{
+		DeviceID:  "d1",
+		SessionID: "s1",
+		Start:     memoryTestStart.Add(time.Duration(start) * time.Second),
+		Stop:      memoryTestStart.Add(time.Duration(stop) * time.Second),
+	}
+}
+
+// newMemoryTestStore returns a store holding speed and RPM values at 0 to 5 seconds,
+// and a point of another session at 0 seconds
+func newMemoryTestStore(t *testing.T) *MemoryTimeSeriesStore {
+	t.Helper()
+	store := NewMemoryTimeSeriesStore()
+	points := []TimeSeriesPoint{
+		// Written out of order, the store keeps them ordered by time
+		memoryTestPoint(3, map[string]interface{}{"kd": 30.0, "kc": 1300.0}),
+		memoryTestPoint(0, map[string]interface{}{"kd": 0.0, "kc": 1000.0}),
+		memoryTestPoint(1, map[string]interface{}{"kd": 10.0, "kc": 1100.0}),
+		memoryTestPoint(2, map[string]interface{}{"kd": 20.0, "kc": 1200.0}),
+		memoryTestPoint(4, map[string]interface{}{"kd": 40.0, "kc": 1400.0}),
+		memoryTestPoint(5, map[string]interface{}{"kd": 50.0, "kc": 1500.0, "note": "text"}),
+		{DeviceID: "d1", SessionID: "s2", Fields: map[string]interface{}{"kd": 99.0}, Time: memoryTestStart},
+	}
+	if err := store.Write(context.Background(), points...); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	return store
+}
+
+// memoryTestValues returns the values of a field of the points, nil for points lacking it
+func memoryTestValues(points []TimeSeriesPoint, field string) []interface{} {
+	values := make([]interface{}, 0, len(points))
+	for _, point := range points {
+		values = append(values, point.Fields[field])
+	}
+	return values
+}
+
+func TestMemoryTimeSeriesStoreWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []TimeSeriesPoint
+		want   []map[string]interface{}
+	}{
+		{
+			name:   "single point",
+			points: []TimeSeriesPoint{memoryTestPoint(0, map[string]interface{}{"kd": 1.0})},
+			want:   []map[string]interface{}{{"kd": 1.0}},
+		},
+		{
+			name: "ordered by time",
+			points: []TimeSeriesPoint{
+				memoryTestPoint(2, map[string]interface{}{"kd": 2.0}),
+				memoryTestPoint(0, map[string]interface{}{"kd": 0.0}),
+				memoryTestPoint(1, map[string]interface{}{"kd": 1.0}),
+			},
+			want: []map[string]interface{}{{"kd": 0.0}, {"kd": 1.0}, {"kd": 2.0}},
+		},
+		{
+			name: "fields at the same time are merged",
+			points: []TimeSeriesPoint{
+				memoryTestPoint(0, map[string]interface{}{"kd": 1.0, "kc": 900.0}),
+				memoryTestPoint(0, map[string]interface{}{"kc": 1000.0, "k5": 80.0}),
+			},
+			want: []map[string]interface{}{{"kd": 1.0, "kc": 1000.0, "k5": 80.0}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryTimeSeriesStore()
+			if err := store.Write(context.Background(), test.points...); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+
+			points, err := QueryAll(context.Background(), store, memoryTestQuery(0, 10))
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			got := make([]map[string]interface{}, 0, len(points))
+			for _, point := range points {
+				got = append(got, point.Fields)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("fields = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMemoryTimeSeriesStoreWriteCopiesPoint(t *testing.T) {
+	store := NewMemoryTimeSeriesStore()
+	fields := map[string]interface{}{"kd": 1.0}
+	if err := store.Write(context.Background(), memoryTestPoint(0, fields)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	fields["kd"] = 2.0
+
+	points, err := QueryAll(context.Background(), store, memoryTestQuery(0, 10))
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(points) != 1 || points[0].Fields["kd"] != 1.0 {
+		t.Errorf("points = %v, want the value written", points)
+	}
+}
+
+func TestMemoryTimeSeriesStoreQuery(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     TimeSeriesQuery
+		field     string
+		wantTimes []int // seconds after memoryTestStart
+		want      []interface{}
+	}{
+		{
+			name:      "all points",
+			query:     memoryTestQuery(0, 10),
+			field:     "kd",
+			wantTimes: []int{0, 1, 2, 3, 4, 5},
+			want:      []interface{}{0.0, 10.0, 20.0, 30.0, 40.0, 50.0},
+		},
+		{
+			name:      "start is inclusive, stop exclusive",
+			query:     memoryTestQuery(1, 4),
+			field:     "kd",
+			wantTimes: []int{1, 2, 3},
+			want:      []interface{}{10.0, 20.0, 30.0},
+		},
+		{
+			name:      "empty range",
+			query:     memoryTestQuery(6, 10),
+			field:     "kd",
+			wantTimes: []int{},
+			want:      []interface{}{},
+		},
+		{
+			name:      "other session",
+			query:     TimeSeriesQuery{DeviceID: "d1", SessionID: "s2", Start: memoryTestStart, Stop: memoryTestStart.Add(time.Minute)},
+			field:     "kd",
+			wantTimes: []int{0},
+			want:      []interface{}{99.0},
+		},
+		{
+			name: "unknown device",
+			query: TimeSeriesQuery{
+				DeviceID: "d2", SessionID: "s1", Start: memoryTestStart, Stop: memoryTestStart.Add(time.Minute),
+			},
+			field:     "kd",
+			wantTimes: []int{},
+			want:      []interface{}{},
+		},
+		{
+			name: "selected fields only",
+			query: func() TimeSeriesQuery {
+				query := memoryTestQuery(0, 2)
+				query.Fields = []string{"kc"}
+				return query
+			}(),
+			field:     "kd",
+			wantTimes: []int{0, 1},
+			want:      []interface{}{nil, nil},
+		},
+		{
+			name: "window averages numeric fields",
+			query: func() TimeSeriesQuery {
+				query := memoryTestQuery(0, 10)
+				query.Every = 2 * time.Second
+				return query
+			}(),
+			field:     "kc",
+			wantTimes: []int{0, 2, 4},
+			want:      []interface{}{1050.0, 1250.0, 1450.0},
+		},
+		{
+			name: "window within the time range",
+			query: func() TimeSeriesQuery {
+				query := memoryTestQuery(1, 5)
+				query.Every = 2 * time.Second
+				query.Fields = []string{"kd"}
+				return query
+			}(),
+			field:     "kd",
+			wantTimes: []int{0, 2, 4},
+			want:      []interface{}{10.0, 25.0, 40.0},
+		},
+	}
+
+	store := newMemoryTestStore(t)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			points, err := QueryAll(context.Background(), store, test.query)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+
+			times := make([]int, 0, len(points))
+			for _, point := range points {
+				times = append(times, int(point.Time.Sub(memoryTestStart)/time.Second))
+			}
+			if !reflect.DeepEqual(times, test.wantTimes) {
+				t.Errorf("times = %v, want %v", times, test.wantTimes)
+			}
+			if got := memoryTestValues(points, test.field); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("%s = %v, want %v", test.field, got, test.want)
+			}
+		})
+	}
+}
+
+func TestMemoryTimeSeriesStoreQueryStopsOnError(t *testing.T) {
+	store := newMemoryTestStore(t)
+	calls := 0
+	err := store.Query(context.Background(), memoryTestQuery(0, 10), func(point TimeSeriesPoint) error {
+		calls++
+		return context.Canceled
+	})
+	if err != context.Canceled {
+		t.Errorf("err = %v, want %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestMemoryTimeSeriesStoreDelete(t *testing.T) {
+	tests := []struct {
+		name       string
+		delete     TimeSeriesQuery
+		wantTimes  []int // seconds after memoryTestStart left in session s1
+		wantOthers int   // points left in session s2
+	}{
+		{
+			name:       "time range",
+			delete:     memoryTestQuery(1, 4),
+			wantTimes:  []int{0, 4, 5},
+			wantOthers: 1,
+		},
+		{
+			name:       "whole session",
+			delete:     memoryTestQuery(0, 10),
+			wantTimes:  []int{},
+			wantOthers: 1,
+		},
+		{
+			name:       "empty range",
+			delete:     memoryTestQuery(6, 10),
+			wantTimes:  []int{0, 1, 2, 3, 4, 5},
+			wantOthers: 1,
+		},
+		{
+			name:       "other session",
+			delete:     TimeSeriesQuery{DeviceID: "d1", SessionID: "s2", Start: memoryTestStart, Stop: memoryTestStart.Add(time.Minute)},
+			wantTimes:  []int{0, 1, 2, 3, 4, 5},
+			wantOthers: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := newMemoryTestStore(t)
+			if err := store.Delete(context.Background(), test.delete); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+
+			points, err := QueryAll(context.Background(), store, memoryTestQuery(0, 10))
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			times := make([]int, 0, len(points))
+			for _, point := range points {
+				times = append(times, int(point.Time.Sub(memoryTestStart)/time.Second))
+			}
+			if !reflect.DeepEqual(times, test.wantTimes) {
+				t.Errorf("times = %v, want %v", times, test.wantTimes)
+			}
+
+			others, err := QueryAll(context.Background(), store, TimeSeriesQuery{
+				DeviceID: "d1", SessionID: "s2", Start: memoryTestStart, Stop: memoryTestStart.Add(time.Minute),
+			})
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if len(others) != test.wantOthers {
+				t.Errorf("points of s2 = %d, want %d", len(others), test.wantOthers)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package models
+
+import (
+	"context"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"log"
+	"os"
+	"time"
+)
+
+// TimeSeriesSample is a single field value of a Torque sample, as stored by the SQLite time-series store.
+// Only numeric fields are kept; point tags are not stored.
+type TimeSeriesSample struct {
+	ID        uint    `gorm:"primarykey;autoIncrement"`
+	DeviceID  string  `gorm:"column:device_id;index:idx_timeseries_sample_series,priority:1;not null"`
+	SessionID string  `gorm:"column:session_id;index:idx_timeseries_sample_series,priority:2;not null"`
+	Time      int64   `gorm:"column:time;index:idx_timeseries_sample_series,priority:3;not null"` // Unix time in nanoseconds
+	Field     string  `gorm:"column:field;not null"`
+	Value     float64 `gorm:"column:value;not null"`
+}
+
+func (*TimeSeriesSample) TableName() string {
+	return "timeseries_samples"
+}
+
+// SQLiteTimeSeriesStore stores the Torque samples in a narrow SQLite table, one row per field value.
+// It allows running gorque without InfluxDB, e.g. on a Raspberry Pi.
+type SQLiteTimeSeriesStore struct {
+	db *gorm.DB
+}
+
+// NewSQLiteTimeSeriesStore creates a time-series store in the given SQLite database and migrates its table.
+func NewSQLiteTimeSeriesStore(db *gorm.DB) (*SQLiteTimeSeriesStore, error) {
+	if err := db.AutoMigrate(&TimeSeriesSample{}); err != nil {
+		return nil, err
+	}
+	return &SQLiteTimeSeriesStore{db: db}, nil
+}
+
+// initSQLiteTimeSeries opens the SQLite time-series store. The samples are kept in the main database
+// unless TIMESERIES_SQLITE_URL points to a separate database file.
+func initSQLiteTimeSeries() (TimeSeriesStore, error) {
+	path := os.Getenv("TIMESERIES_SQLITE_URL")
+	if path == "" {
+		log.Println("Using SQLite time-series store in the main database")
+		return NewSQLiteTimeSeriesStore(DBSQLite)
+	}
+
+	if err := ensureDatabaseDirectoryExists(path); err != nil {
+		return nil, err
+	}
+	if err := ensureDatabaseFileExists(path); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Using SQLite time-series store at: %s", path)
+	db, err := gorm.Open(sqlite.Open(path+"?_journal_mode=WAL&_busy_timeout=5000"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Warn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return NewSQLiteTimeSeriesStore(db)
+}
+
+// Write stores the numeric fields of the given points in a single transaction.
+func (store *SQLiteTimeSeriesStore) Write(ctx context.Context, points ...TimeSeriesPoint) error {
+	var samples []TimeSeriesSample
+	for _, point := range points {
+		for field, value := range point.Fields {
+			number, ok := numericValue(value)
+			if !ok {
+				continue
+			}
+			samples = append(samples, TimeSeriesSample{
+				DeviceID:  point.DeviceID,
+				SessionID: point.SessionID,
+				Time:      point.Time.UnixNano(),
+				Field:     field,
+				Value:     number,
+			})
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil
+	}
+
+	return store.db.WithContext(ctx).CreateInBatches(samples, 500).Error
+}
+
+// Query streams the samples ordered by time and calls fn once per point in time.
+// Aggregation windows are computed by grouping on the window start in SQL.
+func (store *SQLiteTimeSeriesStore) Query(ctx context.Context, query TimeSeriesQuery, fn func(point TimeSeriesPoint) error) error {
+	tx := store.db.WithContext(ctx).Model(&TimeSeriesSample{}).
+		Where("device_id = ? AND session_id = ? AND time >= ? AND time < ?",
+			query.DeviceID, query.SessionID, query.Start.UnixNano(), query.Stop.UnixNano())
+	if len(query.Fields) > 0 {
+		tx = tx.Where("field IN ?", query.Fields)
+	}
+	if query.Every > 0 {
+		every := query.Every.Nanoseconds()
+		tx = tx.Select("time / ? * ? AS window_start, field, AVG(value)", every, every).
+			Group("window_start, field").
+			Order("window_start ASC")
+	} else {
+		tx = tx.Select("time, field, value").Order("time ASC")
+	}
+
+	rows, err := tx.Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var current *TimeSeriesPoint
+	for rows.Next() {
+		var nanos int64
+		var field string
+		var value float64
+		if err := rows.Scan(&nanos, &field, &value); err != nil {
+			return err
+		}
+
+		if current != nil && current.Time.UnixNano() != nanos {
+			if err := fn(*current); err != nil {
+				return err
+			}
+			current = nil
+		}
+		if current == nil {
+			current = &TimeSeriesPoint{
+				DeviceID:  query.DeviceID,
+				SessionID: query.SessionID,
+				Tags:      map[string]string{},
+				Fields:    map[string]interface{}{},
+				Time:      time.Unix(0, nanos).UTC(),
+			}
+		}
+		current.Fields[field] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if current != nil {
+		return fn(*current)
+	}
+
+	return nil
+}
+
+// Delete removes all samples matching the query.
+func (store *SQLiteTimeSeriesStore) Delete(ctx context.Context, query TimeSeriesQuery) error {
+	return store.db.WithContext(ctx).
+		Where("device_id = ? AND session_id = ? AND time >= ? AND time < ?",
+			query.DeviceID, query.SessionID, query.Start.UnixNano(), query.Stop.UnixNano()).
+		Delete(&TimeSeriesSample{}).Error
+}
+
+// Close releases a separately opened time-series database. The main database is left open.
+func (store *SQLiteTimeSeriesStore) Close() {
+	if store.db == DBSQLite {
+		return
+	}
+	if sqlDB, err := store.db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// numericValue converts a field value to float64, if it is numeric.
+func numericValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
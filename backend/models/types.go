@@ -3,6 +3,23 @@ package models
 type ProfileDataCode string
 type UserDataCode string
 
+// FieldKey returns the key under which Torque uploads and gorque stores the value of the code.
+// Torque does not zero pad single digit PIDs, e.g. the value of k0d is uploaded as kd.
+func (code UserDataCode) FieldKey() string {
+	if len(code) == 3 && code[1] == '0' {
+		return "k" + string(code[2:])
+	}
+	return string(code)
+}
+
+// UserDataCodeFromFieldKey returns the code of a field key uploaded by Torque, the reverse of FieldKey.
+func UserDataCodeFromFieldKey(key string) UserDataCode {
+	if len(key) == 2 && key[0] == 'k' {
+		return UserDataCode("k0" + key[1:])
+	}
+	return UserDataCode(key)
+}
+
 //type userDataName string
 
 type UserDataItem struct {
@@ -13,409 +30,19 @@ type UserDataItem struct {
 	FullName  string       `json:"full_name"`
 }
 
+// UserDataRequest holds the core parameters of a Torque upload.
+// The data, profile and field definition parameters are parsed generically from the query.
 type UserDataRequest struct {
-	Email       string   `form:"eml"`
-	V           int      `form:"v"`
-	Session     int64    `form:"session"` // Session ID
-	ID          string   `form:"id"`      // Device ID
-	Time        int64    `form:"time"`    // Timestamp
-	Lat         float64  `form:"lat"`
-	Lon         float64  `form:"lon"`
-	Notice      string   `form:"notice"`
-	NoticeClass string   `form:"noticeClass"`
-	K03         *float64 `form:"k3" json:"k03,omitempty"`  // Fuel Status
-	K04         *float64 `form:"k4" json:"k04,omitempty"`  // Engine Load
-	K05         *float64 `form:"k5" json:"k05,omitempty"`  // Engine Coolant Temperature
-	K06         *float64 `form:"k6" json:"k06,omitempty"`  // Fuel Trim Bank 1 Short Term
-	K07         *float64 `form:"k7" json:"k07,omitempty"`  // Fuel Trim Bank 1 Long Term
-	K08         *float64 `form:"k8" json:"k08,omitempty"`  // Fuel Trim Bank 2 Short Term
-	K09         *float64 `form:"k9" json:"k09,omitempty"`  // Fuel Trim Bank 2 Long Term
-	K0A         *float64 `form:"ka" json:"k0a,omitempty"`  // Fuel pressure
-	K0B         *float64 `form:"kb" json:"k0b,omitempty"`  // Intake Manifold Pressure
-	K0C         *float64 `form:"kc" json:"k0c,omitempty"`  // Engine RPM
-	K0D         *float64 `form:"kd" json:"k0d,omitempty"`  // Speed (OBD)
-	K0E         *float64 `form:"ke" json:"k0e,omitempty"`  // Timing Advance
-	K0F         *float64 `form:"kf" json:"k0f,omitempty"`  // Intake Air Temperature
-	K10         *float64 `form:"k10" json:"k10,omitempty"` // Mass Air Flow Rate
-	K11         *float64 `form:"k11" json:"k11,omitempty"` // Throttle Position (Manifold)
-	K12         *float64 `form:"k12" json:"k12,omitempty"` // Air Status
-	K14         *float64 `form:"k14" json:"k14,omitempty"` // Fuel trim bank 1 sensor 1
-	K15         *float64 `form:"k15" json:"k15,omitempty"` // Fuel trim bank 1 sensor 2
-	K16         *float64 `form:"k16" json:"k16,omitempty"` // Fuel trim bank 1 sensor 3
-	K17         *float64 `form:"k17" json:"k17,omitempty"` // Fuel trim bank 1 sensor 4
-	K18         *float64 `form:"k18" json:"k18,omitempty"` // Fuel trim bank 2 sensor 1
-	K19         *float64 `form:"k19" json:"k19,omitempty"` // Fuel trim bank 2 sensor 2
-	K1A         *float64 `form:"k1a" json:"k1a,omitempty"` // Fuel trim bank 2 sensor 3
-	K1B         *float64 `form:"k1b" json:"k1b,omitempty"` // Fuel trim bank 2 sensor 4
-	K1F         *float64 `form:"k1f" json:"k1f,omitempty"` // Run time since engine start
-	K21         *float64 `form:"k21" json:"k21,omitempty"` // Distance travelled with MIL/CEL lit
-	K22         *float64 `form:"k22" json:"k22,omitempty"` // Fuel Rail Pressure (relative to manifold vacuum)
-	K23         *float64 `form:"k23" json:"k23,omitempty"` // Fuel Rail Pressure
-	K24         *float64 `form:"k24" json:"k24,omitempty"` // O2 Sensor1 Equivalence Ratio
-	K25         *float64 `form:"k25" json:"k25,omitempty"` // O2 Sensor2 Equivalence Ratio
-	K26         *float64 `form:"k26" json:"k26,omitempty"` // O2 Sensor3 Equivalence Ratio
-	K27         *float64 `form:"k27" json:"k27,omitempty"` // O2 Sensor4 Equivalence Ratio
-	K28         *float64 `form:"k28" json:"k28,omitempty"` // O2 Sensor5 Equivalence Ratio
-	K29         *float64 `form:"k29" json:"k29,omitempty"` // O2 Sensor6 Equivalence Ratio
-	K2A         *float64 `form:"k2a" json:"k2a,omitempty"` // O2 Sensor7 Equivalence Ratio
-	K2B         *float64 `form:"k2b" json:"k2b,omitempty"` // O2 Sensor8 Equivalence Ratio
-	K2C         *float64 `form:"k2c" json:"k2c,omitempty"` // EGR Commanded
-	K2D         *float64 `form:"k2d" json:"k2d,omitempty"` // EGR Error
-	K2F         *float64 `form:"k2f" json:"k2f,omitempty"` // Fuel Level (From Engine ECU)
-	K31         *float64 `form:"k31" json:"k31,omitempty"` // Distance travelled since codes cleared
-	K32         *float64 `form:"k32" json:"k32,omitempty"` // Evap System Vapour Pressure
-	K33         *float64 `form:"k33" json:"k33,omitempty"` // Barometric pressure (from vehicle)
-	K34         *float64 `form:"k34" json:"k34,omitempty"` // O2 Sensor1 Equivalence Ratio (alternate)
-	K3C         *float64 `form:"k3c" json:"k3c,omitempty"` // Catalyst Temperature (Bank 1 Sensor 1)
-	K3D         *float64 `form:"k3d" json:"k3d,omitempty"` // Catalyst Temperature (Bank 2 Sensor 1)
-	K3E         *float64 `form:"k3e" json:"k3e,omitempty"` // Catalyst Temperature (Bank 1 Sensor 2)
-	K3F         *float64 `form:"k3f"`                      // Catalyst Temperature (Bank 2 Sensor 2)
-	K42         *float64 `form:"k42"`                      // Voltage (Control Module)
-	K43         *float64 `form:"k43"`                      // Engine Load (Absolute)
-	K44         *float64 `form:"k44"`                      // Commanded Equivalence Ratio (lambda)
-	K45         *float64 `form:"k45"`                      // Relative Throttle Position
-	K46         *float64 `form:"k46"`                      // Ambient air temp
-	K47         *float64 `form:"k47"`                      // Absolute Throttle Position B
-	K49         *float64 `form:"k49"`                      // Accelerator Pedal Position D
-	K4A         *float64 `form:"k4a"`                      // Accelerator Pedal Position E
-	K4B         *float64 `form:"k4b"`                      // Accelerator Pedal Position F
-	K52         *float64 `form:"k52"`                      // Ethanol Fuel %
-	K5A         *float64 `form:"k5a"`                      // Relative Accelerator Pedal Position
-	K5C         *float64 `form:"k5c"`                      // Engine Oil Temperature
-	K78         *float64 `form:"k78"`                      // Exhaust Gas Temperature 1
-	K79         *float64 `form:"k79"`                      // Exhaust Gas Temperature 2
-	KB4         *float64 `form:"kb4"`                      // Transmission Temperature (Method 2)
-	KFF1001     *float64 `form:"kff1001"`                  // Speed (GPS)
-	KFF1005     *float64 `form:"kff1005"`                  // GPS Longitude
-	KFF1006     *float64 `form:"kff1006"`                  // GPS Latitude
-	KFF1007     *float64 `form:"kff1007"`
-	KFF1010     *float64 `form:"kff1010"` // GPS Altitude
-	KFF1201     *float64 `form:"kff1201"` // Miles Per Gallon(Instant)
-	KFF1202     *float64 `form:"kff1202"` // Turbo Boost & Vacuum Gauge
-	KFF1203     *float64 `form:"kff1203"` // Kilometers Per Litre (Instant)
-	KFF1204     *float64 `form:"kff1204"` // Trip Distance
-	KFF1205     *float64 `form:"kff1205"` // Trip average MPG
-	KFF1206     *float64 `form:"kff1206"` // Trip average KPL
-	KFF1207     *float64 `form:"kff1207"` // Litres Per 100 Kilometer (Instant)
-	KFF1208     *float64 `form:"kff1208"` // Trip average Litres/100 KM
-	KFF120C     *float64 `form:"kff120c"` // Trip distance (stored in vehicle profile)
-	KFF1214     *float64 `form:"kff1214"` // O2 Volts Bank 1 sensor 1
-	KFF1215     *float64 `form:"kff1215"` // O2 Volts Bank 1 sensor 2
-	KFF1216     *float64 `form:"kff1216"` // O2 Volts Bank 1 sensor 3
-	KFF1217     *float64 `form:"kff1217"` // O2 Volts Bank 1 sensor 4
-	KFF1218     *float64 `form:"kff1218"` // O2 Volts Bank 2 sensor 1
-	KFF1219     *float64 `form:"kff1219"` // O2 Volts Bank 2 sensor 2
-	KFF121A     *float64 `form:"kff121a"` // O2 Volts Bank 2 sensor 3
-	KFF121B     *float64 `form:"kff121b"` // O2 Volts Bank 2 sensor 4
-	KFF1220     *float64 `form:"kff1220"` // Acceleration Sensor (X axis)
-	KFF1221     *float64 `form:"kff1221"` // Acceleration Sensor (Y axis)
-	KFF1222     *float64 `form:"kff1222"` // Acceleration Sensor (Z axis)
-	KFF1223     *float64 `form:"kff1223"` // Acceleration Sensor (Total)
-	KFF1225     *float64 `form:"kff1225"` // Torque
-	KFF1226     *float64 `form:"kff1226"` // Horsepower (At the wheels)
-	KFF122D     *float64 `form:"kff122d"` // 0-60mph Time
-	KFF122E     *float64 `form:"kff122e"` // 0-100kph Time
-	KFF122F     *float64 `form:"kff122f"` // 1/4 mile time
-	KFF1230     *float64 `form:"kff1230"` // 1/8 mile time
-	KFF1237     *float64 `form:"kff1237"` // GPS vs OBD Speed difference
-	KFF1238     *float64 `form:"kff1238"` // Voltage (OBD Adapter)
-	KFF1239     *float64 `form:"kff1239"` // GPS Accuracy
-	KFF123A     *float64 `form:"kff123a"` // GPS Satellites
-	KFF123B     *float64 `form:"kff123b"` // GPS Bearing
-	KFF1240     *float64 `form:"kff1240"` // O2 Sensor1 wide-range Voltage
-	KFF1241     *float64 `form:"kff1241"` // O2 Sensor2 wide-range Voltage
-	KFF1242     *float64 `form:"kff1242"` // O2 Sensor3 wide-range Voltage
-	KFF1243     *float64 `form:"kff1243"` // O2 Sensor4 wide-range Voltage
-	KFF1244     *float64 `form:"kff1244"` // O2 Sensor5 wide-range Voltage
-	KFF1245     *float64 `form:"kff1245"` // O2 Sensor6 wide-range Voltage
-	KFF1246     *float64 `form:"kff1246"` // O2 Sensor7 wide-range Voltage
-	KFF1247     *float64 `form:"kff1247"` // O2 Sensor8 wide-range Voltage
-	KFF1249     *float64 `form:"kff1249"` // Air Fuel Ratio (Measured)
-	KFF124A     *float64 `form:"kff124a"` // Tilt (x)
-	KFF124B     *float64 `form:"kff124b"` // Tilt (y)
-	KFF124C     *float64 `form:"kff124c"` // Tilt (z)
-	KFF124D     *float64 `form:"kff124d"` // Air Fuel Ratio (Commanded)
-	KFF124F     *float64 `form:"kff124f"` // 0-200kph Time
-	KFF1257     *float64 `form:"kff1257"` // CO₂ in g/km (Instantaneous)
-	KFF1258     *float64 `form:"kff1258"` // CO₂ in g/km (Average)
-	KFF125A     *float64 `form:"kff125a"` // Fuel flow rate/minute
-	KFF125C     *float64 `form:"kff125c"` // Fuel cost (trip)
-	KFF125D     *float64 `form:"kff125d"` // Fuel flow rate/hour
-	KFF125E     *float64 `form:"kff125e"` // 60-120mph Time
-	KFF125F     *float64 `form:"kff125f"` // 60-80mph Time
-	KFF1260     *float64 `form:"kff1260"` // 40-60mph Time
-	KFF1261     *float64 `form:"kff1261"` // 80-100mph Time
-	KFF1263     *float64 `form:"kff1263"` // Average trip speed (whilst moving only)
-	KFF1264     *float64 `form:"kff1264"` // 100-0kph Time
-	KFF1265     *float64 `form:"kff1265"` // 60-0mph Time
-	KFF1266     *float64 `form:"kff1266"` // Trip Time (Since journey start)
-	KFF1267     *float64 `form:"kff1267"` // Trip time (whilst stationary)
-	KFF1268     *float64 `form:"kff1268"` // Trip Time (whilst moving)
-	KFF1269     *float64 `form:"kff1269"` // Volumetric Efficiency (Calculated)
-	KFF126A     *float64 `form:"kff126a"` // Distance to empty (Estimated)
-	KFF126B     *float64 `form:"kff126b"` // Fuel Remaining (Calculated from vehicle profile)
-	KFF126D     *float64 `form:"kff126d"` // Cost per mile/km (Instant)
-	KFF126E     *float64 `form:"kff126e"` // Cost per mile/km (Trip)
-	KFF1270     *float64 `form:"kff1270"` // Barometer (on Android device)
-	KFF1271     *float64 `form:"kff1271"` // Fuel used (trip)
-	KFF1272     *float64 `form:"kff1272"` // Average trip speed (whilst stopped or moving)
-	KFF1273     *float64 `form:"kff1273"` // Engine kW (At the wheels)
-	KFF1275     *float64 `form:"kff1275"` // 80-120kph Time
-	KFF1276     *float64 `form:"kff1276"` // 60-130mph Time
-	KFF1277     *float64 `form:"kff1277"` // 0-30mph Time
-	KFF1805     *float64 `form:"kff1805"` // Transmission Temperature (Method 1)
-	KFF5201     *float64 `form:"kff5201"` // Miles Per Gallon (Long Term Average)
-	KFF5202     *float64 `form:"kff5202"` // Kilometers Per Litre (Long Term Average)
-	KFF5203     *float64 `form:"kff5203"` // Litres Per 100 Kilometer (Long Term Average)
-
-	ProfileBoostAdjust  *float64 `form:"profileBoostAdjust"`
-	ProfileDisplacement *float64 `form:"profileDisplacement"`
-	ProfileDragCoeff    *float64 `form:"profileDragCoeff"`
-	ProfileFuelCost     *float64 `form:"profileFuelCost"`
-	ProfileFuelType     *int64   `form:"profileFuelType"`
-	ProfileMPGAdjust    *float64 `form:"profileMPGAdjust"`
-	ProfileName         *string  `form:"profileName"`
-	ProfileOBDAdjust    *float64 `form:"profileOBDAdjust"`
-	ProfileOdometer     *int64   `form:"profileOdometer"`
-	ProfileTankCapacity *float64 `form:"profileTankCapacity"`
-	ProfileTankUsed     *float64 `form:"profileTankUsed"`
-	ProfileVe           *float64 `form:"profileVe"`
-	ProfileVehicleType  *int64   `form:"profileVehicleType"`
-	ProfileWeight       *float64 `form:"profileWeight"`
-
-	DefaultUnit04     *string `form:"defaultUnit04"`
-	DefaultUnit05     *string `form:"defaultUnit05"`
-	DefaultUnit06     *string `form:"defaultUnit06"`
-	DefaultUnit07     *string `form:"defaultUnit07"`
-	DefaultUnit0b     *string `form:"defaultUnit0b"`
-	DefaultUnit0c     *string `form:"defaultUnit0c"`
-	DefaultUnit0d     *string `form:"defaultUnit0d"`
-	DefaultUnit0e     *string `form:"defaultUnit0e"`
-	DefaultUnit0f     *string `form:"defaultUnit0f"`
-	DefaultUnit10     *string `form:"defaultUnit10"`
-	DefaultUnit11     *string `form:"defaultUnit11"`
-	DefaultUnit14     *string `form:"defaultUnit14"`
-	DefaultUnit15     *string `form:"defaultUnit15"`
-	DefaultUnit1f     *string `form:"defaultUnit1f"`
-	DefaultUnit21     *string `form:"defaultUnit21"`
-	DefaultUnit31     *string `form:"defaultUnit31"`
-	DefaultUnit33     *string `form:"defaultUnit33"`
-	DefaultUnit42     *string `form:"defaultUnit42"`
-	DefaultUnit43     *string `form:"defaultUnit43"`
-	DefaultUnit44     *string `form:"defaultUnit44"`
-	DefaultUnit45     *string `form:"defaultUnit45"`
-	DefaultUnit46     *string `form:"defaultUnit46"`
-	DefaultUnit47     *string `form:"defaultUnit47"`
-	DefaultUnit49     *string `form:"defaultUnit49"`
-	DefaultUnit4a     *string `form:"defaultUnit4a"`
-	DefaultUnitFF1001 *string `form:"defaultUnitff1001"`
-	DefaultUnitFF1005 *string `form:"defaultUnitff1005"`
-	DefaultUnitFF1006 *string `form:"defaultUnitff1006"`
-	DefaultUnitFF1007 *string `form:"defaultUnitff1007"`
-	DefaultUnitFF1201 *string `form:"defaultUnitff1201"`
-	DefaultUnitFF1202 *string `form:"defaultUnitff1202"`
-	DefaultUnitFF1203 *string `form:"defaultUnitff1203"`
-	DefaultUnitFF1204 *string `form:"defaultUnitff1204"`
-	DefaultUnitFF1206 *string `form:"defaultUnitff1206"`
-	DefaultUnitFF1208 *string `form:"defaultUnitff1208"`
-	DefaultUnitFF120c *string `form:"defaultUnitff120c"`
-	DefaultUnitFF1214 *string `form:"defaultUnitff1214"`
-	DefaultUnitFF1215 *string `form:"defaultUnitff1215"`
-	DefaultUnitFF1225 *string `form:"defaultUnitff1225"`
-	DefaultUnitFF1226 *string `form:"defaultUnitff1226"`
-	DefaultUnitFF1237 *string `form:"defaultUnitff1237"`
-	DefaultUnitFF1238 *string `form:"defaultUnitff1238"`
-	DefaultUnitFF123a *string `form:"defaultUnitff123a"`
-	DefaultUnitFF124d *string `form:"defaultUnitff124d"`
-	DefaultUnitFF125a *string `form:"defaultUnitff125a"`
-	DefaultUnitFF125c *string `form:"defaultUnitff125c"`
-	DefaultUnitFF125d *string `form:"defaultUnitff125d"`
-	DefaultUnitFF1264 *string `form:"defaultUnitff1264"`
-	DefaultUnitFF1265 *string `form:"defaultUnitff1265"`
-	DefaultUnitFF1266 *string `form:"defaultUnitff1266"`
-	DefaultUnitFF1267 *string `form:"defaultUnitff1267"`
-	DefaultUnitFF1268 *string `form:"defaultUnitff1268"`
-	DefaultUnitFF126a *string `form:"defaultUnitff126a"`
-	DefaultUnitFF126b *string `form:"defaultUnitff126b"`
-	DefaultUnitFF1271 *string `form:"defaultUnitff1271"`
-	DefaultUnitFF1272 *string `form:"defaultUnitff1272"`
-	DefaultUnitFF1273 *string `form:"defaultUnitff1273"`
-	DefaultUnitFF5201 *string `form:"defaultUnitff5201"`
-	DefaultUnitFF5202 *string `form:"defaultUnitff5202"`
-	DefaultUnitFF5203 *string `form:"defaultUnitff5203"`
-
-	UserFullName04      *string `form:"userFullName04"`
-	UserFullName05      *string `form:"userFullName05"`
-	UserFullName06      *string `form:"userFullName06"`
-	UserFullName07      *string `form:"userFullName07"`
-	UserFullName0b      *string `form:"userFullName0b"`
-	UserFullName0c      *string `form:"userFullName0c"`
-	UserFullName0d      *string `form:"userFullName0d"`
-	UserFullName0e      *string `form:"userFullName0e"`
-	UserFullName0f      *string `form:"userFullName0f"`
-	UserFullName10      *string `form:"userFullName10"`
-	UserFullName11      *string `form:"userFullName11"`
-	UserFullName14      *string `form:"userFullName14"`
-	UserFullName15      *string `form:"userFullName15"`
-	UserFullName1f      *string `form:"userFullName1f"`
-	UserFullName21      *string `form:"userFullName21"`
-	UserFullName31      *string `form:"userFullName31"`
-	UserFullName33      *string `form:"userFullName33"`
-	UserFullName42      *string `form:"userFullName42"`
-	UserFullName43      *string `form:"userFullName43"`
-	UserFullName44      *string `form:"userFullName44"`
-	UserFullName45      *string `form:"userFullName45"`
-	UserFullName46      *string `form:"userFullName46"`
-	UserFullName47      *string `form:"userFullName47"`
-	UserFullName49      *string `form:"userFullName49"`
-	UserFullName4a      *string `form:"userFullName4a"`
-	UserFullNameFF1201  *string `form:"userFullNameff1201"`
-	UserFullNameFF1202  *string `form:"userFullNameff1202"`
-	UserFullNameFF1203  *string `form:"userFullNameff1203"`
-	UserFullNameFF1204  *string `form:"userFullNameff1204"`
-	UserFullNameFF1206  *string `form:"userFullNameff1206"`
-	UserFullNameFF1208  *string `form:"userFullNameff1208"`
-	UserFullNameFF120c  *string `form:"userFullNameff120c"`
-	UserFullNameFF1214  *string `form:"userFullNameff1214"`
-	UserFullNameFF1215  *string `form:"userFullNameff1215"`
-	UserFullNameFF1225  *string `form:"userFullNameff1225"`
-	UserFullNameFF1226  *string `form:"userFullNameff1226"`
-	UserFullNameFF1237  *string `form:"userFullNameff1237"`
-	UserFullNameFF1238  *string `form:"userFullNameff1238"`
-	UserFullNameFF123a  *string `form:"userFullNameff123a"`
-	UserFullNameFF124d  *string `form:"userFullNameff124d"`
-	UserFullNameFF125a  *string `form:"userFullNameff125a"`
-	UserFullNameFF125c  *string `form:"userFullNameff125c"`
-	UserFullNameFF125d  *string `form:"userFullNameff125d"`
-	UserFullNameFF1264  *string `form:"userFullNameff1264"`
-	UserFullNameFF1265  *string `form:"userFullNameff1265"`
-	UserFullNameFF1266  *string `form:"userFullNameff1266"`
-	UserFullNameFF1267  *string `form:"userFullNameff1267"`
-	UserFullNameFF1268  *string `form:"userFullNameff1268"`
-	UserFullNameFF126a  *string `form:"userFullNameff126a"`
-	UserFullNameFF126b  *string `form:"userFullNameff126b"`
-	UserFullNameFF1271  *string `form:"userFullNameff1271"`
-	UserFullNameFF1272  *string `form:"userFullNameff1272"`
-	UserFullNameFF1273  *string `form:"userFullNameff1273"`
-	UserFullNameFF5201  *string `form:"userFullNameff5201"`
-	UserFullNameFF5202  *string `form:"userFullNameff5202"`
-	UserFullNameFF5203  *string `form:"userFullNameff5203"`
-	UserShortName04     *string `form:"userShortName04"`
-	UserShortName05     *string `form:"userShortName05"`
-	UserShortName06     *string `form:"userShortName06"`
-	UserShortName07     *string `form:"userShortName07"`
-	UserShortName0b     *string `form:"userShortName0b"`
-	UserShortName0c     *string `form:"userShortName0c"`
-	UserShortName0d     *string `form:"userShortName0d"`
-	UserShortName0e     *string `form:"userShortName0e"`
-	UserShortName0f     *string `form:"userShortName0f"`
-	UserShortName10     *string `form:"userShortName10"`
-	UserShortName11     *string `form:"userShortName11"`
-	UserShortName14     *string `form:"userShortName14"`
-	UserShortName15     *string `form:"userShortName15"`
-	UserShortName1f     *string `form:"userShortName1f"`
-	UserShortName21     *string `form:"userShortName21"`
-	UserShortName31     *string `form:"userShortName31"`
-	UserShortName33     *string `form:"userShortName33"`
-	UserShortName42     *string `form:"userShortName42"`
-	UserShortName43     *string `form:"userShortName43"`
-	UserShortName44     *string `form:"userShortName44"`
-	UserShortName45     *string `form:"userShortName45"`
-	UserShortName46     *string `form:"userShortName46"`
-	UserShortName47     *string `form:"userShortName47"`
-	UserShortName49     *string `form:"userShortName49"`
-	UserShortName4a     *string `form:"userShortName4a"`
-	UserShortNameFF1201 *string `form:"userShortNameff1201"`
-	UserShortNameFF1202 *string `form:"userShortNameff1202"`
-	UserShortNameFF1203 *string `form:"userShortNameff1203"`
-	UserShortNameFF1204 *string `form:"userShortNameff1204"`
-	UserShortNameFF1206 *string `form:"userShortNameff1206"`
-	UserShortNameFF1208 *string `form:"userShortNameff1208"`
-	UserShortNameFF120c *string `form:"userShortNameff120c"`
-	UserShortNameFF1214 *string `form:"userShortNameff1214"`
-	UserShortNameFF1215 *string `form:"userShortNameff1215"`
-	UserShortNameFF1225 *string `form:"userShortNameff1225"`
-	UserShortNameFF1226 *string `form:"userShortNameff1226"`
-	UserShortNameFF1237 *string `form:"userShortNameff1237"`
-	UserShortNameFF1238 *string `form:"userShortNameff1238"`
-	UserShortNameFF123a *string `form:"userShortNameff123a"`
-	UserShortNameFF124d *string `form:"userShortNameff124d"`
-	UserShortNameFF125a *string `form:"userShortNameff125a"`
-	UserShortNameFF125c *string `form:"userShortNameff125c"`
-	UserShortNameFF125d *string `form:"userShortNameff125d"`
-	UserShortNameFF1264 *string `form:"userShortNameff1264"`
-	UserShortNameFF1265 *string `form:"userShortNameff1265"`
-	UserShortNameFF1266 *string `form:"userShortNameff1266"`
-	UserShortNameFF1267 *string `form:"userShortNameff1267"`
-	UserShortNameFF1268 *string `form:"userShortNameff1268"`
-	UserShortNameFF126a *string `form:"userShortNameff126a"`
-	UserShortNameFF126b *string `form:"userShortNameff126b"`
-	UserShortNameFF1271 *string `form:"userShortNameff1271"`
-	UserShortNameFF1272 *string `form:"userShortNameff1272"`
-	UserShortNameFF1273 *string `form:"userShortNameff1273"`
-	UserShortNameFF5201 *string `form:"userShortNameff5201"`
-	UserShortNameFF5202 *string `form:"userShortNameff5202"`
-	UserShortNameFF5203 *string `form:"userShortNameff5203"`
-	UserUnit04          *string `form:"userUnit04"`
-	UserUnit05          *string `form:"userUnit05"`
-	UserUnit06          *string `form:"userUnit06"`
-	UserUnit07          *string `form:"userUnit07"`
-	UserUnit0b          *string `form:"userUnit0b"`
-	UserUnit0c          *string `form:"userUnit0c"`
-	UserUnit0d          *string `form:"userUnit0d"`
-	UserUnit0e          *string `form:"userUnit0e"`
-	UserUnit0f          *string `form:"userUnit0f"`
-	UserUnit10          *string `form:"userUnit10"`
-	UserUnit11          *string `form:"userUnit11"`
-	UserUnit14          *string `form:"userUnit14"`
-	UserUnit15          *string `form:"userUnit15"`
-	UserUnit1f          *string `form:"userUnit1f"`
-	UserUnit21          *string `form:"userUnit21"`
-	UserUnit31          *string `form:"userUnit31"`
-	UserUnit33          *string `form:"userUnit33"`
-	UserUnit42          *string `form:"userUnit42"`
-	UserUnit43          *string `form:"userUnit43"`
-	UserUnit44          *string `form:"userUnit44"`
-	UserUnit45          *string `form:"userUnit45"`
-	UserUnit46          *string `form:"userUnit46"`
-	UserUnit47          *string `form:"userUnit47"`
-	UserUnit49          *string `form:"userUnit49"`
-	UserUnit4a          *string `form:"userUnit4a"`
-	UserUnitFF1001      *string `form:"userUnitff1001"`
-	UserUnitFF1005      *string `form:"userUnitff1005"`
-	UserUnitFF1006      *string `form:"userUnitff1006"`
-	UserUnitFF1007      *string `form:"userUnitff1007"`
-	UserUnitFF1201      *string `form:"userUnitff1201"`
-	UserUnitFF1202      *string `form:"userUnitff1202"`
-	UserUnitFF1203      *string `form:"userUnitff1203"`
-	UserUnitFF1204      *string `form:"userUnitff1204"`
-	UserUnitFF1206      *string `form:"userUnitff1206"`
-	UserUnitFF1208      *string `form:"userUnitff1208"`
-	UserUnitFF120c      *string `form:"userUnitff120c"`
-	UserUnitFF1214      *string `form:"userUnitff1214"`
-	UserUnitFF1215      *string `form:"userUnitff1215"`
-	UserUnitFF1225      *string `form:"userUnitff1225"`
-	UserUnitFF1226      *string `form:"userUnitff1226"`
-	UserUnitFF1237      *string `form:"userUnitff1237"`
-	UserUnitFF1238      *string `form:"userUnitff1238"`
-	UserUnitFF123a      *string `form:"userUnitff123a"`
-	UserUnitFF124d      *string `form:"userUnitff124d"`
-	UserUnitFF125a      *string `form:"userUnitff125a"`
-	UserUnitFF125c      *string `form:"userUnitff125c"`
-	UserUnitFF125d      *string `form:"userUnitff125d"`
-	UserUnitFF1264      *string `form:"userUnitff1264"`
-	UserUnitFF1265      *string `form:"userUnitff1265"`
-	UserUnitFF1266      *string `form:"userUnitff1266"`
-	UserUnitFF1267      *string `form:"userUnitff1267"`
-	UserUnitFF1268      *string `form:"userUnitff1268"`
-	UserUnitFF126a      *string `form:"userUnitff126a"`
-	UserUnitFF126b      *string `form:"userUnitff126b"`
-	UserUnitFF1271      *string `form:"userUnitff1271"`
-	UserUnitFF1272      *string `form:"userUnitff1272"`
-	UserUnitFF1273      *string `form:"userUnitff1273"`
-	UserUnitFF5201      *string `form:"userUnitff5201"`
-	UserUnitFF5202      *string `form:"userUnitff5202"`
-	UserUnitFF5203      *string `form:"userUnitff5203"`
+	Email       string  `form:"eml"`
+	Key         string  `form:"key"` // Upload key, alternatively sent in eml
+	V           int     `form:"v"`
+	Session     int64   `form:"session"` // Session ID
+	ID          string  `form:"id"`      // Device ID
+	Time        int64   `form:"time"`    // Timestamp
+	Lat         float64 `form:"lat"`
+	Lon         float64 `form:"lon"`
+	Notice      string  `form:"notice"`
+	NoticeClass string  `form:"noticeClass"`
 }
 
 var (
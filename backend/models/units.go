@@ -0,0 +1,292 @@
+package models
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Quantity is a physical quantity whose values can be converted between units
+type Quantity string
+
+const (
+	QuantitySpeed       Quantity = "speed"
+	QuantityTemperature Quantity = "temperature"
+	QuantityPressure    Quantity = "pressure"
+	QuantityDistance    Quantity = "distance"
+	QuantityLength      Quantity = "length" // e.g. altitude
+	QuantityVolume      Quantity = "volume"
+	QuantityFuelEconomy Quantity = "fuelEconomy"
+	QuantityFuelFlow    Quantity = "fuelFlow"
+	QuantityPower       Quantity = "power"
+	QuantityTorque      Quantity = "torque"
+)
+
+// Unit systems of the unit preferences
+const (
+	UnitSystemMetric   = "metric"
+	UnitSystemImperial = "imperial"
+)
+
+// unitDefinition describes a unit by the conversion of its values from and to the base unit of its quantity
+type unitDefinition struct {
+	quantity Quantity
+	system   string
+	toBase   func(value float64) float64
+	fromBase func(value float64) float64
+}
+
+// scaledUnit defines a unit whose values are the values in the base unit multiplied by factor
+func scaledUnit(quantity Quantity, system string, factor float64) unitDefinition {
+	return unitDefinition{
+		quantity: quantity,
+		system:   system,
+		toBase:   func(value float64) float64 { return value / factor },
+		fromBase: func(value float64) float64 { return value * factor },
+	}
+}
+
+// inverseUnit defines a unit whose values are factor divided by the values in the base unit, e.g. l/100km of km/l
+func inverseUnit(quantity Quantity, system string, factor float64) unitDefinition {
+	inverse := func(value float64) float64 {
+		if value == 0 {
+			return 0
+		}
+		return factor / value
+	}
+	return unitDefinition{quantity: quantity, system: system, toBase: inverse, fromBase: inverse}
+}
+
+// unitDefinitions are the convertible units keyed by their canonical name.
+// The base units are km/h, °C, kPa, km, m, l, km/l, l/hr, kW and Nm.
+var unitDefinitions = map[string]unitDefinition{
+	"km/h": scaledUnit(QuantitySpeed, UnitSystemMetric, 1),
+	"m/s":  scaledUnit(QuantitySpeed, UnitSystemMetric, 1/3.6),
+	"mph":  scaledUnit(QuantitySpeed, UnitSystemImperial, 1/1.609344),
+
+	"°C": scaledUnit(QuantityTemperature, UnitSystemMetric, 1),
+	"°F": {
+		quantity: QuantityTemperature,
+		system:   UnitSystemImperial,
+		toBase:   func(value float64) float64 { return (value - 32) * 5 / 9 },
+		fromBase: func(value float64) float64 { return value*9/5 + 32 },
+	},
+
+	"kPa":  scaledUnit(QuantityPressure, UnitSystemMetric, 1),
+	"hPa":  scaledUnit(QuantityPressure, UnitSystemMetric, 10),
+	"Pa":   scaledUnit(QuantityPressure, UnitSystemMetric, 1000),
+	"bar":  scaledUnit(QuantityPressure, UnitSystemMetric, 0.01),
+	"psi":  scaledUnit(QuantityPressure, UnitSystemImperial, 0.1450377377),
+	"inHg": scaledUnit(QuantityPressure, UnitSystemImperial, 0.2952998751),
+
+	"km": scaledUnit(QuantityDistance, UnitSystemMetric, 1),
+	"mi": scaledUnit(QuantityDistance, UnitSystemImperial, 1/1.609344),
+
+	"m":  scaledUnit(QuantityLength, UnitSystemMetric, 1),
+	"ft": scaledUnit(QuantityLength, UnitSystemImperial, 1/0.3048),
+
+	"l":       scaledUnit(QuantityVolume, UnitSystemMetric, 1),
+	"gal":     scaledUnit(QuantityVolume, UnitSystemImperial, 1/3.785411784),
+	"gal(UK)": scaledUnit(QuantityVolume, UnitSystemImperial, 1/4.54609),
+
+	"kpl":     scaledUnit(QuantityFuelEconomy, UnitSystemMetric, 1),
+	"l/100km": inverseUnit(QuantityFuelEconomy, UnitSystemMetric, 100),
+	"mpg":     scaledUnit(QuantityFuelEconomy, UnitSystemImperial, 3.785411784/1.609344),
+	"mpg(UK)": scaledUnit(QuantityFuelEconomy, UnitSystemImperial, 4.54609/1.609344),
+
+	"l/hr":   scaledUnit(QuantityFuelFlow, UnitSystemMetric, 1),
+	"gal/hr": scaledUnit(QuantityFuelFlow, UnitSystemImperial, 1/3.785411784),
+
+	"kW": scaledUnit(QuantityPower, UnitSystemMetric, 1),
+	"hp": scaledUnit(QuantityPower, UnitSystemImperial, 1/0.745699872),
+
+	"Nm":    scaledUnit(QuantityTorque, UnitSystemMetric, 1),
+	"lb-ft": scaledUnit(QuantityTorque, UnitSystemImperial, 1/1.3558179483),
+}
+
+// unitAliases maps other spellings of units, e.g. in Torque settings and CSV logs, to their canonical name
+var unitAliases = map[string]string{
+	"kph":        "km/h",
+	"kmh":        "km/h",
+	"mps":        "m/s",
+	"c":          "°C",
+	"f":          "°F",
+	"deg c":      "°C",
+	"deg f":      "°F",
+	"degc":       "°C",
+	"degf":       "°F",
+	"miles":      "mi",
+	"mile":       "mi",
+	"feet":       "ft",
+	"litre":      "l",
+	"liter":      "l",
+	"litres":     "l",
+	"liters":     "l",
+	"gallon":     "gal",
+	"gallons":    "gal",
+	"gal(us)":    "gal",
+	"km/l":       "kpl",
+	"mpg(us)":    "mpg",
+	"mpguk":      "mpg(UK)",
+	"l/h":        "l/hr",
+	"lph":        "l/hr",
+	"gph":        "gal/hr",
+	"ft-lb":      "lb-ft",
+	"lbft":       "lb-ft",
+	"lb ft":      "lb-ft",
+	"bhp":        "hp",
+	"kilopascal": "kPa",
+}
+
+// unitSystemDefaults are the units values are converted to in the metric and imperial unit systems
+var unitSystemDefaults = map[string]map[Quantity]string{
+	UnitSystemMetric: {
+		QuantitySpeed:       "km/h",
+		QuantityTemperature: "°C",
+		QuantityPressure:    "kPa",
+		QuantityDistance:    "km",
+		QuantityLength:      "m",
+		QuantityVolume:      "l",
+		QuantityFuelEconomy: "l/100km",
+		QuantityFuelFlow:    "l/hr",
+		QuantityPower:       "kW",
+		QuantityTorque:      "Nm",
+	},
+	UnitSystemImperial: {
+		QuantitySpeed:       "mph",
+		QuantityTemperature: "°F",
+		QuantityPressure:    "psi",
+		QuantityDistance:    "mi",
+		QuantityLength:      "ft",
+		QuantityVolume:      "gal",
+		QuantityFuelEconomy: "mpg",
+		QuantityFuelFlow:    "gal/hr",
+		QuantityPower:       "hp",
+		QuantityTorque:      "lb-ft",
+	},
+}
+
+// CanonicalUnit returns the canonical name of a unit, or the unit itself if it is not convertible.
+func CanonicalUnit(unit string) string {
+	unit = strings.TrimSpace(unit)
+	if _, exists := unitDefinitions[unit]; exists {
+		return unit
+	}
+	if canonical, exists := unitAliases[strings.ToLower(unit)]; exists {
+		return canonical
+	}
+	for canonical := range unitDefinitions {
+		if strings.EqualFold(canonical, unit) {
+			return canonical
+		}
+	}
+	return unit
+}
+
+// UnitConversion converts values from one unit to another
+type UnitConversion struct {
+	From    string
+	To      string
+	convert func(value float64) float64
+}
+
+// Apply converts a value, values of an identity conversion are returned unchanged.
+func (conversion UnitConversion) Apply(value float64) float64 {
+	if conversion.convert == nil {
+		return value
+	}
+	return conversion.convert(value)
+}
+
+// IsIdentity reports whether the conversion leaves the values unchanged
+func (conversion UnitConversion) IsIdentity() bool {
+	return conversion.convert == nil
+}
+
+// ConvertUnit returns the conversion between two units of the same quantity.
+// Returns false if either unit is unknown or they measure different quantities.
+func ConvertUnit(from string, to string) (UnitConversion, bool) {
+	from, to = CanonicalUnit(from), CanonicalUnit(to)
+	if from == to {
+		return UnitConversion{From: from, To: to}, true
+	}
+
+	fromDefinition, fromExists := unitDefinitions[from]
+	toDefinition, toExists := unitDefinitions[to]
+	if !fromExists || !toExists || fromDefinition.quantity != toDefinition.quantity {
+		return UnitConversion{}, false
+	}
+
+	return UnitConversion{
+		From: from,
+		To:   to,
+		convert: func(value float64) float64 {
+			return toDefinition.fromBase(fromDefinition.toBase(value))
+		},
+	}, true
+}
+
+// UnitPreferences selects the units values are shown in.
+// Values are converted to the unit system, except for units that already belong to it, e.g. hPa in the metric system.
+// Units set per quantity override the unit system.
+type UnitPreferences struct {
+	System string              `json:"system"`
+	Units  map[Quantity]string `json:"units"`
+}
+
+// Validate checks the unit system and that the units set per quantity measure that quantity.
+func (preferences UnitPreferences) Validate() error {
+	if _, exists := unitSystemDefaults[preferences.System]; !exists {
+		return fmt.Errorf("invalid unit system, %s or %s expected", UnitSystemMetric, UnitSystemImperial)
+	}
+	for quantity, unit := range preferences.Units {
+		if _, exists := unitSystemDefaults[UnitSystemMetric][quantity]; !exists {
+			return fmt.Errorf("invalid quantity %q", quantity)
+		}
+		definition, exists := unitDefinitions[unit]
+		if !exists || definition.quantity != quantity {
+			return fmt.Errorf("invalid unit %q for %s", unit, quantity)
+		}
+	}
+	return nil
+}
+
+// Conversion returns the conversion of values stored in the unit to the preferred unit.
+// Values in units that are not convertible are left unchanged.
+func (preferences UnitPreferences) Conversion(unit string) UnitConversion {
+	from := CanonicalUnit(unit)
+	definition, exists := unitDefinitions[from]
+	if !exists {
+		return UnitConversion{From: unit, To: unit}
+	}
+
+	to, overridden := preferences.Units[definition.quantity]
+	if !overridden {
+		system := preferences.System
+		if _, exists := unitSystemDefaults[system]; !exists {
+			system = UnitSystemMetric
+		}
+		if definition.system == system {
+			return UnitConversion{From: from, To: from}
+		}
+		to = unitSystemDefaults[system][definition.quantity]
+	}
+
+	conversion, _ := ConvertUnit(from, to)
+	return conversion
+}
+
+// UnitOptions returns the units selectable per quantity, the metric ones first.
+func UnitOptions() map[Quantity][]string {
+	options := make(map[Quantity][]string)
+	for _, system := range []string{UnitSystemMetric, UnitSystemImperial} {
+		units := slices.Sorted(maps.Keys(unitDefinitions))
+		for _, unit := range units {
+			if definition := unitDefinitions[unit]; definition.system == system {
+				options[definition.quantity] = append(options[definition.quantity], unit)
+			}
+		}
+	}
+	return options
+}
@@ -0,0 +1,74 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"gorm.io/gorm"
+	"time"
+)
+
+// UploadKey is a credential that authorizes Torque uploads on behalf of a user.
+// Only the SHA-256 hash of the key is stored; the plain key is shown once on creation.
+// A key bound to a DeviceID is accepted only for uploads from that device.
+type UploadKey struct {
+	ID         uint       `gorm:"primarykey;autoIncrement"`
+	UserID     uint       `gorm:"column:user_id;index:idx_upload_key_user_id;not null"`
+	DeviceID   string     `gorm:"column:device_id;index:idx_upload_key_device_id"`
+	Name       string     `gorm:"column:name"`
+	KeyHash    string     `gorm:"column:key_hash;uniqueIndex:idx_upload_key_unique_key_hash;not null" json:"-"`
+	KeyPrefix  string     `gorm:"column:key_prefix"`
+	CreatedAt  time.Time  `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	LastUsedAt *time.Time `gorm:"column:last_used_at"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*UploadKey) TableName() string {
+	return "upload_keys"
+}
+
+// UploadKeyHash returns the hex encoded SHA-256 hash under which an upload key is stored.
+func UploadKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// UploadKeyCreate inserts a new upload key record into the database.
+func UploadKeyCreate(uploadKey *UploadKey) error {
+	return DBSQLite.Create(uploadKey).Error
+}
+
+// UploadKeyGetByKey retrieves a non-revoked upload key by its plain text value.
+func UploadKeyGetByKey(key string) (UploadKey, error) {
+	var uploadKey UploadKey
+	err := DBSQLite.Where("key_hash = ? AND revoked_at IS NULL", UploadKeyHash(key)).First(&uploadKey).Error
+	return uploadKey, err
+}
+
+// UploadKeyListGetByUserID retrieves all upload keys of a user, including revoked ones, newest first.
+func UploadKeyListGetByUserID(userID uint) ([]UploadKey, error) {
+	var uploadKeys []UploadKey
+	err := DBSQLite.Where("user_id = ?", userID).Order("created_at DESC").Find(&uploadKeys).Error
+	return uploadKeys, err
+}
+
+// UploadKeyRevoke marks an upload key of the given user as revoked.
+// Returns gorm.ErrRecordNotFound if the user has no active key with the given ID.
+func UploadKeyRevoke(userID uint, id uint) error {
+	result := DBSQLite.Model(&UploadKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// UpdateLastUsed records the time the upload key was last used.
+func (uploadKey *UploadKey) UpdateLastUsed(lastUsedAt time.Time) error {
+	return DBSQLite.Model(uploadKey).Update("last_used_at", lastUsedAt).Error
+}
@@ -9,6 +9,9 @@ type User struct {
 	Password  string `gorm:"not null"`
 	Name      string `gorm:"not null"`
 	CreatedAt time.Time
+
+	UnitSystem string              `gorm:"column:unit_system;not null;default:metric"`
+	Units      map[Quantity]string `gorm:"column:units;serializer:json"` // units overriding the unit system per quantity
 }
 
 // TableName specifies the custom table name for the User struct when used with an ORM.
@@ -40,9 +43,9 @@ func UserGetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// GetDevices retrieves all devices associated with the user.
-func (user *User) GetDevices() ([]Device, error) {
-	return DeviceListGetByUserID(user.ID)
+// GetDevices retrieves the devices associated with the user, archived ones only if includeArchived is set.
+func (user *User) GetDevices(includeArchived bool) ([]Device, error) {
+	return DeviceListGetByUserID(user.ID, includeArchived)
 }
 
 // UpdateName updates the name of a user in the database and returns an error if the operation fails.
@@ -54,3 +57,21 @@ func (user *User) UpdateName(name string) error {
 func (user *User) UpdatePassword(hashedPassword string) error {
 	return DBSQLite.Model(user).Update("password", hashedPassword).Error
 }
+
+// UnitPreferences returns the units the user wants values shown in.
+func (user *User) UnitPreferences() UnitPreferences {
+	preferences := UnitPreferences{System: user.UnitSystem, Units: user.Units}
+	if preferences.System == "" {
+		preferences.System = UnitSystemMetric
+	}
+	if preferences.Units == nil {
+		preferences.Units = map[Quantity]string{}
+	}
+	return preferences
+}
+
+// UpdateUnitPreferences updates the unit preferences of a user in the database and returns an error if the operation fails.
+func (user *User) UpdateUnitPreferences(preferences UnitPreferences) error {
+	return DBSQLite.Model(user).Select("unit_system", "units").
+		Updates(User{UnitSystem: preferences.System, Units: preferences.Units}).Error
+}
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"errors"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+// Vehicle is a car of a user. Sessions are assigned to vehicles independently of the device they were recorded with,
+// as a phone may be used in several cars and a car may be driven with different phones.
+// Sessions are assigned automatically by the profile name Torque sends, or manually.
+type Vehicle struct {
+	ID            uint      `gorm:"primarykey;autoIncrement"`
+	UserID        uint      `gorm:"column:user_id;index:idx_vehicle_user_torque_profile,priority:1;not null"`
+	Name          string    `gorm:"column:name;not null"`
+	TorqueProfile string    `gorm:"column:torque_profile;index:idx_vehicle_user_torque_profile,priority:2"` // Torque profile name matched on uploads
+	VIN           string    `gorm:"column:vin"`
+	Make          string    `gorm:"column:make"`
+	Model         string    `gorm:"column:model"`
+	Year          int       `gorm:"column:year"`
+	CreatedAt     time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt     time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+
+	VehicleProfileData            // the latest vehicle profile sent by Torque, including the odometer
+	ProfileUpdatedAt   *time.Time `gorm:"column:profile_updated_at"` // time of the latest vehicle profile
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*Vehicle) TableName() string {
+	return "vehicles"
+}
+
+// VehicleStats summarizes the trip statistics of the sessions of a vehicle across all devices.
+type VehicleStats struct {
+	SessionCount   int
+	DeviceIDs      []string
+	TotalDistance  float64
+	TripDuration   int
+	FuelConsumed   float64
+	AvgConsumption float64
+	MaxSpeed       float64
+
+	Units SessionStatUnits // units of the values, stored in km, km/h, l and l/100km
+}
+
+// InUnits returns the statistics converted to the preferred units.
+func (stats VehicleStats) InUnits(preferences UnitPreferences) VehicleStats {
+	stat := SessionStat{
+		TotalDistance:  stats.TotalDistance,
+		FuelConsumed:   stats.FuelConsumed,
+		AvgConsumption: stats.AvgConsumption,
+		MaxSpeed:       stats.MaxSpeed,
+	}.InUnits(preferences)
+
+	stats.TotalDistance = stat.TotalDistance
+	stats.FuelConsumed = stat.FuelConsumed
+	stats.AvgConsumption = stat.AvgConsumption
+	stats.MaxSpeed = stat.MaxSpeed
+	stats.Units = stat.Units
+	return stats
+}
+
+// VehicleCreate inserts a new vehicle record into the database.
+func VehicleCreate(vehicle *Vehicle) error {
+	return DBSQLite.Create(vehicle).Error
+}
+
+// VehicleGetByID retrieves a vehicle of the user by its ID.
+func VehicleGetByID(id uint, userID uint) (Vehicle, error) {
+	var vehicle Vehicle
+	err := DBSQLite.Where("id = ? AND user_id = ?", id, userID).First(&vehicle).Error
+	return vehicle, err
+}
+
+// VehicleGetByTorqueProfile retrieves the vehicle of the user matching a Torque profile name, ignoring case.
+func VehicleGetByTorqueProfile(userID uint, profileName string) (Vehicle, error) {
+	var vehicle Vehicle
+	err := DBSQLite.Where("user_id = ? AND torque_profile = ? COLLATE NOCASE", userID, profileName).
+		Order("id ASC").
+		First(&vehicle).Error
+	return vehicle, err
+}
+
+// VehicleListGetByUserID retrieves all vehicles of a user ordered by name.
+func VehicleListGetByUserID(userID uint) ([]Vehicle, error) {
+	var vehicles []Vehicle
+	err := DBSQLite.Where("user_id = ?", userID).Order("name COLLATE NOCASE ASC").Find(&vehicles).Error
+	return vehicles, err
+}
+
+// VehicleUpdateDetails updates the details of a vehicle set by the user.
+func VehicleUpdateDetails(vehicle *Vehicle) error {
+	return DBSQLite.Model(vehicle).
+		Select("name", "torque_profile", "vin", "make", "model", "year", "updated_at").
+		Updates(Vehicle{
+			Name:          vehicle.Name,
+			TorqueProfile: vehicle.TorqueProfile,
+			VIN:           vehicle.VIN,
+			Make:          vehicle.Make,
+			Model:         vehicle.Model,
+			Year:          vehicle.Year,
+			UpdatedAt:     time.Now(),
+		}).Error
+}
+
+// VehicleAssignProfile assigns a vehicle profile snapshot, and the session in which it arrived, to the vehicle
+// of the user matching the Torque profile name. A vehicle is created for profile names not seen before.
+// Sessions assigned to a vehicle already keep their assignment. The profile of the vehicle is updated
+// if the snapshot is the latest one. Returns the vehicle, or gorm.ErrRecordNotFound for a profile without a name.
+func VehicleAssignProfile(profile *VehicleProfile) (Vehicle, error) {
+	name := strings.TrimSpace(profile.ProfileName)
+	if name == "" {
+		return Vehicle{}, gorm.ErrRecordNotFound
+	}
+
+	vehicle, err := VehicleGetByTorqueProfile(profile.UserID, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		vehicle = Vehicle{UserID: profile.UserID, Name: name, TorqueProfile: name}
+		err = VehicleCreate(&vehicle)
+	}
+	if err != nil {
+		return vehicle, err
+	}
+
+	err = DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(profile).Update("vehicle_id", vehicle.ID).Error; err != nil {
+			return err
+		}
+		if profile.SessionID != "" {
+			err := tx.Model(&Session{}).
+				Where("session_id = ? AND vehicle_id IS NULL", profile.SessionID).
+				Update("vehicle_id", vehicle.ID).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if vehicle.ProfileUpdatedAt != nil && vehicle.ProfileUpdatedAt.After(profile.Time) {
+			return nil
+		}
+		vehicle.VehicleProfileData = profile.VehicleProfileData
+		vehicle.ProfileUpdatedAt = &profile.Time
+		return tx.Model(&vehicle).
+			Select("profileBoostAdjust", "profileDisplacement", "profileDragCoeff", "profileFuelCost", "profileFuelType",
+				"profileMPGAdjust", "profileName", "profileOBDAdjust", "profileOdometer", "profileTankCapacity",
+				"profileTankUsed", "profileVe", "profileVehicleType", "profileWeight", "profile_updated_at").
+			Updates(&vehicle).Error
+	})
+	return vehicle, err
+}
+
+// Delete removes the vehicle. Its sessions and vehicle profile snapshots are kept unassigned.
+func (vehicle *Vehicle) Delete() error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{&Session{}, &VehicleProfile{}} {
+			if err := tx.Model(model).Where("vehicle_id = ?", vehicle.ID).Update("vehicle_id", nil).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Delete(vehicle).Error
+	})
+}
+
+// GetSessions retrieves the sessions of the vehicle recorded with any device that match the filter,
+// ordered by end_time in descending order.
+func (vehicle *Vehicle) GetSessions(filter SessionFilter) ([]Session, error) {
+	return SessionListGetByUserAndVehicleID(vehicle.UserID, vehicle.ID, filter)
+}
+
+// GetStats summarizes the trip statistics of the sessions of the vehicle.
+// Sessions without calculated statistics are counted, but do not contribute to the totals.
+func (vehicle *Vehicle) GetStats() (VehicleStats, error) {
+	stats := VehicleStats{DeviceIDs: []string{}}
+
+	sessions := DBSQLite.Model(&Session{}).Where("user_id = ? AND vehicle_id = ?", vehicle.UserID, vehicle.ID)
+	var count int64
+	if err := sessions.Session(&gorm.Session{}).Count(&count).Error; err != nil {
+		return stats, err
+	}
+	stats.SessionCount = int(count)
+	err := sessions.Session(&gorm.Session{}).Distinct("device_id").Order("device_id ASC").Pluck("device_id", &stats.DeviceIDs).Error
+	if err != nil {
+		return stats, err
+	}
+
+	var totals struct {
+		TotalDistance float64
+		TripDuration  int
+		FuelConsumed  float64
+		MaxSpeed      float64
+	}
+	err = DBSQLite.Model(&SessionStat{}).
+		Select("COALESCE(SUM(total_distance), 0) AS total_distance, COALESCE(SUM(trip_duration), 0) AS trip_duration, "+
+			"COALESCE(SUM(fuel_consumed), 0) AS fuel_consumed, COALESCE(MAX(max_speed), 0) AS max_speed").
+		Where("session_id IN (?)", sessions.Session(&gorm.Session{}).Select("session_id")).
+		Scan(&totals).Error
+	if err != nil {
+		return stats, err
+	}
+
+	stats.TotalDistance = totals.TotalDistance
+	stats.TripDuration = totals.TripDuration
+	stats.FuelConsumed = totals.FuelConsumed
+	stats.MaxSpeed = totals.MaxSpeed
+	if stats.TotalDistance > 0 {
+		stats.AvgConsumption = stats.FuelConsumed / stats.TotalDistance * 100
+	}
+	stats.Units = storedSessionStatUnits
+	return stats, nil
+}
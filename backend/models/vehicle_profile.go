@@ -0,0 +1,96 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// VehicleProfileData holds the vehicle profile values Torque sends in profile calls.
+// The columns are named after the upload parameters, which is how the upload path maps the values.
+type VehicleProfileData struct {
+	ProfileBoostAdjust  float64 `gorm:"column:profileBoostAdjust"`
+	ProfileDisplacement float64 `gorm:"column:profileDisplacement"`
+	ProfileDragCoeff    float64 `gorm:"column:profileDragCoeff"`
+	ProfileFuelCost     float64 `gorm:"column:profileFuelCost"`
+	ProfileFuelType     int64   `gorm:"column:profileFuelType"`
+	ProfileMPGAdjust    float64 `gorm:"column:profileMPGAdjust"`
+	ProfileName         string  `gorm:"column:profileName"`
+	ProfileOBDAdjust    float64 `gorm:"column:profileOBDAdjust"`
+	ProfileOdometer     int64   `gorm:"column:profileOdometer"`
+	ProfileTankCapacity float64 `gorm:"column:profileTankCapacity"`
+	ProfileTankUsed     float64 `gorm:"column:profileTankUsed"`
+	ProfileVe           float64 `gorm:"column:profileVe"`
+	ProfileVehicleType  int64   `gorm:"column:profileVehicleType"`
+	ProfileWeight       float64 `gorm:"column:profileWeight"`
+}
+
+// VehicleProfile is a snapshot of the vehicle profile of a device, taken each time Torque sends it.
+// The snapshots keep the history of e.g. the odometer and the fuel cost, the device only holds the latest one.
+type VehicleProfile struct {
+	ID        uint      `gorm:"primarykey;autoIncrement"`
+	DeviceID  string    `gorm:"column:device_id;index:idx_vehicle_profile_device_time,priority:1;not null"`
+	UserID    uint      `gorm:"column:user_id;index:idx_vehicle_profile_user_id;not null"`
+	SessionID string    `gorm:"column:session_id;index:idx_vehicle_profile_session_id"` // session in which the profile arrived
+	VehicleID *uint     `gorm:"column:vehicle_id;index:idx_vehicle_profile_vehicle_id"`
+	Version   int       `gorm:"column:version"`
+	Time      time.Time `gorm:"column:time;index:idx_vehicle_profile_device_time,priority:2;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+
+	VehicleProfileData
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*VehicleProfile) TableName() string {
+	return "vehicle_profiles"
+}
+
+// VehicleProfileCreate stores a vehicle profile snapshot and links it to the session in which it arrived.
+// Returns whether it is the latest snapshot of the device, which the device profile should be updated to.
+func VehicleProfileCreate(profile *VehicleProfile) (bool, error) {
+	var newer int64
+	err := DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(profile).Error; err != nil {
+			return err
+		}
+		if profile.SessionID != "" {
+			if err := sessionUpdateVehicleProfile(tx, profile.SessionID, profile.ID); err != nil {
+				return err
+			}
+		}
+		return tx.Model(&VehicleProfile{}).
+			Where("device_id = ? AND time > ?", profile.DeviceID, profile.Time).
+			Count(&newer).Error
+	})
+	return newer == 0, err
+}
+
+// VehicleProfileListGetByDeviceID retrieves the vehicle profile snapshots of a device of the user
+// taken within the time range, in chronological order. Zero times do not limit the range.
+func VehicleProfileListGetByDeviceID(userID uint, deviceID string, from time.Time, to time.Time) ([]VehicleProfile, error) {
+	var profiles []VehicleProfile
+	query := DBSQLite.Where("user_id = ? AND device_id = ?", userID, deviceID)
+	if !from.IsZero() {
+		query = query.Where("time >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("time < ?", to)
+	}
+	err := query.Order("time ASC, id ASC").Find(&profiles).Error
+	return profiles, err
+}
+
+// VehicleProfileListGetByVehicleID retrieves the vehicle profile snapshots of a vehicle of the user
+// taken with any device within the time range, in chronological order. Zero times do not limit the range.
+func VehicleProfileListGetByVehicleID(userID uint, vehicleID uint, from time.Time, to time.Time) ([]VehicleProfile, error) {
+	var profiles []VehicleProfile
+	query := DBSQLite.Where("user_id = ? AND vehicle_id = ?", userID, vehicleID)
+	if !from.IsZero() {
+		query = query.Where("time >= ?", from)
+	}
+	if !to.IsZero() {
+		query = query.Where("time < ?", to)
+	}
+	err := query.Order("time ASC, id ASC").Find(&profiles).Error
+	return profiles, err
+}
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"slices"
+	"time"
+)
+
+// Events webhooks can subscribe to
+const (
+	WebhookEventSessionStarted = "session.started"
+	WebhookEventSessionEnded   = "session.ended"
+	WebhookEventDTCNew         = "dtc.new"
+	WebhookEventAlertTriggered = "alert.triggered"
+	WebhookEventStatsComputed  = "stats.computed"
+)
+
+// WebhookEventPing is the event sent by the webhook test, regardless of the subscribed events
+const WebhookEventPing = "ping"
+
+// WebhookEvents are the events webhooks can subscribe to
+var WebhookEvents = []string{
+	WebhookEventSessionStarted,
+	WebhookEventSessionEnded,
+	WebhookEventDTCNew,
+	WebhookEventAlertTriggered,
+	WebhookEventStatsComputed,
+}
+
+// Webhook is a URL the events of the user are posted to.
+// The payloads are signed with the HMAC-SHA256 of the secret, which is shown once on creation.
+type Webhook struct {
+	ID        uint      `gorm:"primarykey;autoIncrement"`
+	UserID    uint      `gorm:"column:user_id;index:idx_webhook_user_id;not null"`
+	Name      string    `gorm:"column:name"`
+	URL       string    `gorm:"column:url;not null"`
+	Secret    string    `gorm:"column:secret;not null" json:"-"`
+	Events    []string  `gorm:"column:events;serializer:json"`
+	IsEnabled bool      `gorm:"column:is_enabled;not null"`
+	CreatedAt time.Time `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt time.Time `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*Webhook) TableName() string {
+	return "webhooks"
+}
+
+// Statuses of the webhook deliveries
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is an event posted to a webhook, with the result of the last attempt.
+// Failed attempts are retried until the delivery succeeds or runs out of attempts.
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primarykey;autoIncrement"`
+	WebhookID      uint       `gorm:"column:webhook_id;index:idx_webhook_delivery_webhook_id;not null"`
+	UserID         uint       `gorm:"column:user_id;index:idx_webhook_delivery_user_id;not null"`
+	Event          string     `gorm:"column:event;not null"`
+	Payload        string     `gorm:"column:payload;not null"`
+	Status         string     `gorm:"column:status;index:idx_webhook_delivery_status;not null"`
+	Attempts       int        `gorm:"column:attempts;not null"`
+	ResponseStatus int        `gorm:"column:response_status"` // HTTP status of the last attempt, 0 if no response was received
+	Error          string     `gorm:"column:error"`           // error of the last attempt
+	NextAttemptAt  *time.Time `gorm:"column:next_attempt_at"`
+	DeliveredAt    *time.Time `gorm:"column:delivered_at"`
+	CreatedAt      time.Time  `gorm:"column:created_at;default:CURRENT_TIMESTAMP"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;default:CURRENT_TIMESTAMP"`
+
+	User User `gorm:"foreignKey:UserID;references:ID" json:"-"`
+}
+
+func (*WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// WebhookCreate inserts a new webhook record into the database.
+func WebhookCreate(webhook *Webhook) error {
+	return DBSQLite.Create(webhook).Error
+}
+
+// WebhookGetByID retrieves a webhook of the user by its ID.
+func WebhookGetByID(userID uint, id uint) (Webhook, error) {
+	var webhook Webhook
+	err := DBSQLite.Where("id = ? AND user_id = ?", id, userID).First(&webhook).Error
+	return webhook, err
+}
+
+// WebhookListGetByUserID retrieves the webhooks of a user in the order they were created.
+func WebhookListGetByUserID(userID uint) ([]Webhook, error) {
+	var webhooks []Webhook
+	err := DBSQLite.Where("user_id = ?", userID).Order("id ASC").Find(&webhooks).Error
+	return webhooks, err
+}
+
+// WebhookListGetSubscribed retrieves the enabled webhooks of a user subscribed to the event.
+func WebhookListGetSubscribed(userID uint, event string) ([]Webhook, error) {
+	var webhooks []Webhook
+	if err := DBSQLite.Where("user_id = ? AND is_enabled = ?", userID, true).Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(webhooks, func(webhook Webhook) bool {
+		return !slices.Contains(webhook.Events, event)
+	}), nil
+}
+
+// WebhookUpdate updates the settings of a webhook, the secret is kept.
+func WebhookUpdate(webhook *Webhook) error {
+	webhook.UpdatedAt = time.Now()
+	return DBSQLite.Model(webhook).
+		Select("name", "url", "events", "is_enabled", "updated_at").
+		Updates(webhook).Error
+}
+
+// Delete removes the webhook with its deliveries.
+func (webhook *Webhook) Delete() error {
+	return DBSQLite.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", webhook.ID).Delete(&WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(webhook).Error
+	})
+}
+
+// WebhookDeliveryCreate inserts a new webhook delivery record into the database.
+func WebhookDeliveryCreate(delivery *WebhookDelivery) error {
+	return DBSQLite.Create(delivery).Error
+}
+
+// WebhookDeliveryUpdateAttempt stores the result of a delivery attempt.
+func WebhookDeliveryUpdateAttempt(delivery *WebhookDelivery) error {
+	delivery.UpdatedAt = time.Now()
+	return DBSQLite.Model(delivery).
+		Select("status", "attempts", "response_status", "error", "next_attempt_at", "delivered_at", "updated_at").
+		Updates(delivery).Error
+}
+
+// WebhookDeliveryListGetPending retrieves the deliveries that still have attempts left, oldest first.
+func WebhookDeliveryListGetPending() ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := DBSQLite.Where("status = ?", WebhookDeliveryPending).Order("id ASC").Find(&deliveries).Error
+	return deliveries, err
+}
+
+// WebhookDeliveryListGetByWebhookID retrieves the deliveries of a webhook, most recent first.
+// At most limit deliveries are returned.
+func WebhookDeliveryListGetByWebhookID(webhookID uint, limit int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := DBSQLite.Where("webhook_id = ?", webhookID).Order("id DESC").Limit(limit).Find(&deliveries).Error
+	return deliveries, err
+}
@@ -14,29 +14,38 @@ export const useChartStore = defineStore('chart', () => {
   const configLoading = ref(false);
   const configError = ref(null);
 
-  function isoToMillis(isoString) {
-    return new Date(isoString).getTime();
-  }
+  const maxPoints = 1000;
 
   function processVariableData(variableKey) {
-    if (!chartData.value || Object.keys(chartData.value).length === 0) {
-      return [];
-    }
+    return chartData.value[variableKey] || [];
+  }
 
-    const data = [];
+  function seriesToChartData(series) {
+    const data = {};
 
-    Object.entries(chartData.value).forEach(([timestamp, item]) => {
-      const time = isoToMillis(timestamp);
+    series.forEach((item) => {
+      data[item.field] = item.times.map((time, index) => ({
+        x: time,
+        y: item.values[index],
+      }));
+    });
 
-      if (item[variableKey] !== undefined) {
-        data.push({
-          x: time,
-          y: parseFloat(item[variableKey]),
-        });
-      }
+    return data;
+  }
+
+  // The data endpoint returns the fields as uploaded (e.g. kd), the configuration keys are zero padded (e.g. k0d)
+  function variableField(variable) {
+    return variable.field || variable.key;
+  }
+
+  function configuredFields() {
+    const fields = new Set();
+
+    chartConfigurations.value.forEach((chart) => {
+      chart.variables.forEach((variable) => fields.add(variableField(variable)));
     });
 
-    return data.sort((a, b) => a.x - b.x);
+    return [...fields];
   }
 
   const allChartSeries = computed(() => {
@@ -51,7 +60,7 @@ export const useChartStore = defineStore('chart', () => {
       const series = chart.variables.map((variable) => {
         return {
           name: `${variable.name}${variable.unit ? ` (${variable.unit})` : ''}`,
-          data: processVariableData(variable.key),
+          data: processVariableData(variableField(variable)),
         };
       });
 
@@ -86,51 +95,18 @@ export const useChartStore = defineStore('chart', () => {
       return [];
     }
 
-    const kdData = [];
-    const kff1001Data = [];
-    const kff1237Data = [];
-
-    Object.entries(chartData.value).forEach(([timestamp, item]) => {
-      const time = isoToMillis(timestamp);
-
-      if (item.kd !== undefined) {
-        kdData.push({
-          x: time,
-          y: parseFloat(item.kd),
-        });
-      }
-
-      if (item.kff1001 !== undefined) {
-        kff1001Data.push({
-          x: time,
-          y: parseFloat(item.kff1001),
-        });
-      }
-
-      if (item.kff1237 !== undefined) {
-        kff1237Data.push({
-          x: time,
-          y: parseFloat(item.kff1237),
-        });
-      }
-    });
-
-    kdData.sort((a, b) => a.x - b.x);
-    kff1001Data.sort((a, b) => a.x - b.x);
-    kff1237Data.sort((a, b) => a.x - b.x);
-
     return [
       {
         name: 'Speed (OBD)',
-        data: kdData,
+        data: processVariableData('kd'),
       },
       {
         name: 'Speed (GPS)',
-        data: kff1001Data,
+        data: processVariableData('kff1001'),
       },
       {
         name: 'GPS vs OBD Speed difference',
-        data: kff1237Data,
+        data: processVariableData('kff1237'),
       },
     ];
   }
@@ -157,7 +133,9 @@ export const useChartStore = defineStore('chart', () => {
     configError.value = null;
 
     try {
-      const response = await fetch(`${baseURL}/configuration`, {
+      const params = new URLSearchParams({ 'device-id': deviceId });
+
+      const response = await fetch(`${baseURL}/configuration?${params}`, {
         headers: {
           Authorization: 'Bearer ' + localStorage.getItem('token'),
         },
@@ -201,14 +179,19 @@ export const useChartStore = defineStore('chart', () => {
     try {
       await fetchChartConfigurations(deviceId, sessionId);
 
-      const response = await fetch(
-        `${baseURL}/data?device-id=${deviceId}&session-id=${sessionId}`,
-        {
-          headers: {
-            Authorization: 'Bearer ' + localStorage.getItem('token'),
-          },
-        }
-      );
+      const params = new URLSearchParams({
+        'device-id': deviceId,
+        'session-id': sessionId,
+        fields: configuredFields().join(','),
+        'max-points': maxPoints,
+        method: 'lttb',
+      });
+
+      const response = await fetch(`${baseURL}/data?${params}`, {
+        headers: {
+          Authorization: 'Bearer ' + localStorage.getItem('token'),
+        },
+      });
 
       if (!response.ok) {
         throw new Error(`HTTP error: ${response.status}`);
@@ -217,12 +200,9 @@ export const useChartStore = defineStore('chart', () => {
       const data = await response.json();
       console.log('Chart data response:', data);
 
-      if (data && data.data) {
-        chartData.value = data.data;
-        console.log(
-          'Chart data set successfully, sample keys:',
-          Object.keys(data.data).slice(0, 3)
-        );
+      if (data && Array.isArray(data.series)) {
+        chartData.value = seriesToChartData(data.series);
+        console.log('Chart data set successfully, fields:', Object.keys(chartData.value));
       } else {
         console.warn('Invalid data format received');
         chartData.value = {};
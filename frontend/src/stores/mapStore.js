@@ -4,7 +4,7 @@ import { ref } from 'vue';
 export const useMapStore = defineStore('map', () => {
   const baseURL = import.meta.env.VITE_API_URL || '/api';
 
-  const dataMap = ref({ center: [0.0, 0.0], coords: [], data: {} });
+  const dataMap = ref({ center: [0.0, 0.0], coords: [], series: [] });
   const initialized = ref(false);
   const loading = ref(false);
   const error = ref(null);
@@ -17,7 +17,7 @@ export const useMapStore = defineStore('map', () => {
       initialized.value &&
       currentDeviceId.value === deviceId &&
       currentSessionId.value === sessionId &&
-      Object.keys(dataMap.value.data).length > 0
+      dataMap.value.coords.length > 0
     ) {
       return;
     }
@@ -26,14 +26,19 @@ export const useMapStore = defineStore('map', () => {
     error.value = null;
 
     try {
-      const response = await fetch(
-        `${baseURL}/data?device-id=${deviceId}&session-id=${sessionId}`,
-        {
-          headers: {
-            Authorization: 'Bearer ' + localStorage.getItem('token'),
-          },
-        }
-      );
+      const params = new URLSearchParams({
+        'device-id': deviceId,
+        'session-id': sessionId,
+        fields: 'kff1006,kff1005',
+        'max-points': 2000,
+        method: 'lttb',
+      });
+
+      const response = await fetch(`${baseURL}/data?${params}`, {
+        headers: {
+          Authorization: 'Bearer ' + localStorage.getItem('token'),
+        },
+      });
 
       if (!response.ok) {
         throw new Error(`HTTP error: ${response.status}`);
@@ -53,7 +58,7 @@ export const useMapStore = defineStore('map', () => {
   }
 
   function resetState() {
-    dataMap.value = { center: [0.0, 0.0], coords: [], data: {} };
+    dataMap.value = { center: [0.0, 0.0], coords: [], series: [] };
     initialized.value = false;
     error.value = null;
     currentDeviceId.value = null;